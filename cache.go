@@ -0,0 +1,73 @@
+package rhsm2
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultCacheDirPath is the directory, where various data are cached
+const DefaultCacheDirPath = "/var/lib/rhsm"
+
+// computeHash tries to compute SHA256 hash of JSON representation of given data.
+// Keys of maps are sorted by JSON encoder. Thus, the hash of the same map
+// is always the same.
+func computeHash(data interface{}) (string, error) {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal data: %s", err)
+	}
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// readJSONCache tries to read the cache file and unmarshal its content
+// to the given data
+func readJSONCache(filePath string, data interface{}) error {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(content, data)
+	if err != nil {
+		return fmt.Errorf("unable to parse cache file %s: %s", filePath, err)
+	}
+	return nil
+}
+
+// writeJSONCache tries to write JSON representation of given data to the
+// cache file. The directory of the cache file is created, when it does not exist.
+// Access permissions are given by the policy of access permissions.
+func writeJSONCache(logger *zerolog.Logger, filePath string, data interface{}, permissions FilePermissions) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("unable to marshal data: %s", err)
+	}
+	err = os.MkdirAll(filepath.Dir(filePath), permissions.Dir)
+	if err != nil {
+		return fmt.Errorf("unable to create directory for cache file %s: %s", filePath, err)
+	}
+	err = writeFileAtomically(logger, filePath, content, permissions.Config)
+	if err != nil {
+		return fmt.Errorf("unable to write cache file %s: %s", filePath, err)
+	}
+	return nil
+}
+
+// removeCacheFile tries to remove the cache file. It is not an error,
+// when the cache file does not exist.
+func removeCacheFile(filePath string) error {
+	if filePath == "" {
+		return nil
+	}
+	err := os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
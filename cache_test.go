@@ -0,0 +1,67 @@
+package rhsm2
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+)
+
+// TestComputeHash test that hash of the same map is always the same and
+// hash of different maps is different
+func TestComputeHash(t *testing.T) {
+	t.Parallel()
+	hash1, err := computeHash(map[string]string{"a": "1", "b": "2", "c": "3"})
+	if err != nil {
+		t.Fatalf("computeHash() returned error: %s", err)
+	}
+	hash2, err := computeHash(map[string]string{"c": "3", "b": "2", "a": "1"})
+	if err != nil {
+		t.Fatalf("computeHash() returned error: %s", err)
+	}
+	if hash1 != hash2 {
+		t.Errorf("hash of the same map differs: %s != %s", hash1, hash2)
+	}
+	hash3, err := computeHash(map[string]string{"a": "1", "b": "2", "c": "4"})
+	if err != nil {
+		t.Fatalf("computeHash() returned error: %s", err)
+	}
+	if hash1 == hash3 {
+		t.Errorf("hash of different maps is the same: %s", hash1)
+	}
+}
+
+// TestReadWriteJSONCache test writing and reading of cache file
+func TestReadWriteJSONCache(t *testing.T) {
+	t.Parallel()
+	cacheFilePath := filepath.Join(t.TempDir(), "foo", "bar.json")
+
+	err := writeJSONCache(&log.Logger, cacheFilePath, map[string]string{"foo": "bar"}, DefaultFilePermissions())
+	if err != nil {
+		t.Fatalf("writeJSONCache() returned error: %s", err)
+	}
+
+	var data map[string]string
+	err = readJSONCache(cacheFilePath, &data)
+	if err != nil {
+		t.Fatalf("readJSONCache() returned error: %s", err)
+	}
+	if data["foo"] != "bar" {
+		t.Errorf("unexpected content of cache: %v", data)
+	}
+
+	err = removeCacheFile(cacheFilePath)
+	if err != nil {
+		t.Fatalf("removeCacheFile() returned error: %s", err)
+	}
+	err = readJSONCache(cacheFilePath, &data)
+	if err == nil {
+		t.Errorf("readJSONCache() should return error for removed cache file")
+	}
+
+	// It is not an error to remove missing cache file
+	err = removeCacheFile(cacheFilePath)
+	if err != nil {
+		t.Errorf("removeCacheFile() returned error for missing file: %s", err)
+	}
+}
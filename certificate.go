@@ -1,48 +1,111 @@
 package rhsm2
 
 import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"os"
+	"path/filepath"
 
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
 // writePemFile Tries to write content of PEM (cert or key) to file.
-// If mode is not nil, then access permissions to give file will be modified
-func writePemFile(filePath *string, pemFileContent *string, mode *os.FileMode) error {
+// The file is written atomically with given access permissions
+func writePemFile(logger *zerolog.Logger, filePath *string, pemFileContent *string, mode os.FileMode) error {
 	if len(*pemFileContent) == 0 {
 		return fmt.Errorf("canceling writing pem file: %s, because provided content is empty", *filePath)
 	}
 
-	file, err := os.Create(*filePath)
+	err := writeFileAtomically(logger, *filePath, []byte(*pemFileContent), mode)
 	if err != nil {
 		return err
 	}
 
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			log.Error().Msgf("unable to close %s: %s", *filePath, err)
-		}
-	}(file)
+	logger.Debug().Msgf("installed %s", *filePath)
+
+	return nil
+}
 
-	// Print content of cert using Fprint(), because
-	// the string contains formatting sequences like \n
-	_, err = fmt.Fprint(file, *pemFileContent)
+// parsePEMCertificate tries to parse the first block "CERTIFICATE" from PEM data
+func parsePEMCertificate(data []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no block \"CERTIFICATE\" found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
 
+// loadCACertificates tries to load all CA certificates from the directory with CA
+// certificates (typically /etc/rhsm/ca). Files, which cannot be read or parsed, are skipped.
+func (rhsmClient *RHSMClient) loadCACertificates() []*x509.Certificate {
+	caDir := rhsmClient.RHSMConf.RHSM.CACertDir
+	caFiles, err := os.ReadDir(caDir)
 	if err != nil {
-		return err
+		rhsmClient.getLogger().Warn().Msgf("unable to read CA directory %s: %s", caDir, err)
+		return nil
 	}
 
-	// Optionally try to change access permission to file
-	if mode != nil {
-		err = os.Chmod(*filePath, *mode)
+	var caCerts []*x509.Certificate
+	for _, file := range caFiles {
+		caFilePath := filepath.Join(caDir, file.Name())
+		data, err := os.ReadFile(caFilePath)
 		if err != nil {
-			return fmt.Errorf("unable to change access permission of %s to (%v): %v", *filePath, *mode, err)
+			rhsmClient.getLogger().Warn().Msgf("unable to read CA certificate %s: %s", caFilePath, err)
+			continue
+		}
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			caCert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				rhsmClient.getLogger().Warn().Msgf("unable to parse CA certificate %s: %s", caFilePath, err)
+				continue
+			}
+			caCerts = append(caCerts, caCert)
 		}
 	}
+	return caCerts
+}
 
-	log.Debug().Msgf("installed %s", *filePath)
+// verifyEntitlementDataSignature tries to verify RSA signature (SHA256) of entitlement data.
+// Candlepin signs entitlement data only with the private key of its CA. Thus, the signature
+// is verified only with public keys of CA certificates. The key of entitlement certificate
+// is never used, because the private key of entitlement certificate is installed on every
+// consumer. When the signature cannot be verified, then EntitlementSignatureError is returned.
+func verifyEntitlementDataSignature(
+	entitlementData []byte,
+	signature []byte,
+	caCerts []*x509.Certificate,
+) error {
+	if len(signature) == 0 {
+		return &EntitlementSignatureError{Reason: "no block \"RSA SIGNATURE\" found"}
+	}
 
-	return nil
+	hash := sha256.Sum256(entitlementData)
+	for _, caCert := range caCerts {
+		publicKey, ok := caCert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+		if rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signature) == nil {
+			return nil
+		}
+	}
+
+	return &EntitlementSignatureError{Reason: "signature does not match any CA certificate"}
 }
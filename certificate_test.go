@@ -0,0 +1,387 @@
+package rhsm2
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testingCA is testing certificate authority used for signing of entitlement data
+type testingCA struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+// createTestingCA tries to generate new testing CA certificate and key
+func createTestingCA() (*testingCA, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate CA key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Testing Candlepin CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create CA certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse CA certificate: %s", err)
+	}
+	return &testingCA{cert: cert, key: key}, nil
+}
+
+// newTestingCA tries to generate new testing CA certificate and key
+func newTestingCA(t *testing.T) *testingCA {
+	ca, err := createTestingCA()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return ca
+}
+
+// signData tries to create RSA signature (SHA256) of data
+func (ca *testingCA) signData(data []byte) ([]byte, error) {
+	hash := sha256.Sum256(data)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, ca.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign data: %s", err)
+	}
+	return signature, nil
+}
+
+// sign tries to create RSA signature (SHA256) of data
+func (ca *testingCA) sign(t *testing.T, data []byte) []byte {
+	signature, err := ca.signData(data)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return signature
+}
+
+// newTestingEntCert tries to generate new testing entitlement certificate and key.
+// The certificate is issued by given CA. When the CA is nil, then the certificate is self-signed
+func newTestingEntCert(t *testing.T, issuer *testingCA) *testingCA {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(4709416649487329566),
+		Subject:      pkix.Name{CommonName: "735f8c7f-90f1-4348-9421-d5cb81905940"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	parent, signingKey := template, key
+	if issuer != nil {
+		parent, signingKey = issuer.cert, issuer.key
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signingKey)
+	if err != nil {
+		t.Fatalf("unable to create entitlement certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatalf("unable to parse entitlement certificate: %s", err)
+	}
+	return &testingCA{cert: cert, key: key}
+}
+
+// certPEM returns CA certificate in PEM format
+func (ca *testingCA) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+// readTestingEntCertBlocks tries to read PEM blocks of testing entitlement certificate
+func readTestingEntCertBlocks(t *testing.T) map[string]*pem.Block {
+	data, err := os.ReadFile("testdata/etc/pki/entitlement/" + testEntCertSerialNumber + ".pem")
+	if err != nil {
+		t.Fatalf("unable to read entitlement certificate: %s", err)
+	}
+	blocks := make(map[string]*pem.Block)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		blocks[block.Type] = block
+	}
+	return blocks
+}
+
+// tamperEntitlementData returns compressed entitlement data with modified content path
+func tamperEntitlementData(t *testing.T, entitlementData []byte) []byte {
+	zReader, err := zlib.NewReader(bytes.NewReader(entitlementData))
+	if err != nil {
+		t.Fatalf("unable to uncompress entitlement data: %s", err)
+	}
+	var content bytes.Buffer
+	_, err = content.ReadFrom(zReader)
+	if err != nil {
+		t.Fatalf("unable to uncompress entitlement data: %s", err)
+	}
+	tampered := strings.ReplaceAll(content.String(), "/path/to/", "https://evil.example.com/")
+
+	var compressed bytes.Buffer
+	zWriter := zlib.NewWriter(&compressed)
+	_, _ = zWriter.Write([]byte(tampered))
+	_ = zWriter.Close()
+	return compressed.Bytes()
+}
+
+// encodeEntCert creates entitlement certificate in PEM format from given blocks
+func encodeEntCert(cert *pem.Block, entitlementData []byte, signature []byte) string {
+	result := pem.EncodeToMemory(cert)
+	result = append(result, pem.EncodeToMemory(&pem.Block{Type: "ENTITLEMENT DATA", Bytes: entitlementData})...)
+	if signature != nil {
+		result = append(result, pem.EncodeToMemory(&pem.Block{Type: "RSA SIGNATURE", Bytes: signature})...)
+	}
+	return string(result)
+}
+
+// TestVerifyEntitlementDataSignature test verification of signature of entitlement data
+func TestVerifyEntitlementDataSignature(t *testing.T) {
+	t.Parallel()
+	ca := newTestingCA(t)
+	otherCA := newTestingCA(t)
+	issuedEntCert := newTestingEntCert(t, ca)
+	selfSignedEntCert := newTestingEntCert(t, nil)
+	data := []byte("entitlement data")
+
+	tests := []struct {
+		name      string
+		data      []byte
+		signature []byte
+		caCerts   []*x509.Certificate
+		wantErr   bool
+	}{
+		{
+			name:      "valid signature",
+			data:      data,
+			signature: ca.sign(t, data),
+			caCerts:   []*x509.Certificate{otherCA.cert, ca.cert},
+		},
+		{
+			name:      "tampered data",
+			data:      []byte("tampered entitlement data"),
+			signature: ca.sign(t, data),
+			caCerts:   []*x509.Certificate{ca.cert},
+			wantErr:   true,
+		},
+		{
+			name:      "unknown CA",
+			data:      data,
+			signature: ca.sign(t, data),
+			caCerts:   []*x509.Certificate{otherCA.cert},
+			wantErr:   true,
+		},
+		{
+			name:    "missing signature",
+			data:    data,
+			caCerts: []*x509.Certificate{ca.cert},
+			wantErr: true,
+		},
+		{
+			name:      "signed by key of entitlement certificate issued by CA",
+			data:      data,
+			signature: issuedEntCert.sign(t, data),
+			caCerts:   []*x509.Certificate{ca.cert},
+			wantErr:   true,
+		},
+		{
+			name:      "signed by key of self-signed entitlement certificate",
+			data:      data,
+			signature: selfSignedEntCert.sign(t, data),
+			caCerts:   []*x509.Certificate{ca.cert},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := verifyEntitlementDataSignature(tt.data, tt.signature, tt.caCerts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyEntitlementDataSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !IsInvalidEntitlementSignature(err) {
+				t.Errorf("expected EntitlementSignatureError, got: %T", err)
+			}
+		})
+	}
+}
+
+// TestGetContentFromEntCertSignature test that content of entitlement certificate
+// is used only, when the signature of entitlement data is valid
+func TestGetContentFromEntCertSignature(t *testing.T) {
+	t.Parallel()
+	ca := newTestingCA(t)
+	selfSignedEntCert := newTestingEntCert(t, nil)
+	selfSignedEntCertBlock := &pem.Block{Type: "CERTIFICATE", Bytes: selfSignedEntCert.cert.Raw}
+	issuedEntCert := newTestingEntCert(t, ca)
+	issuedEntCertBlock := &pem.Block{Type: "CERTIFICATE", Bytes: issuedEntCert.cert.Raw}
+	blocks := readTestingEntCertBlocks(t)
+	entitlementData := blocks["ENTITLEMENT DATA"].Bytes
+	tamperedData := tamperEntitlementData(t, entitlementData)
+
+	tests := []struct {
+		name    string
+		entCert string
+		caCerts []*x509.Certificate
+		wantErr bool
+	}{
+		{
+			name:    "signed by key of self-signed certificate",
+			entCert: encodeEntCert(selfSignedEntCertBlock, tamperedData, selfSignedEntCert.sign(t, tamperedData)),
+			caCerts: []*x509.Certificate{ca.cert},
+			wantErr: true,
+		},
+		{
+			name:    "signed by key of certificate issued by CA",
+			entCert: encodeEntCert(issuedEntCertBlock, tamperedData, issuedEntCert.sign(t, tamperedData)),
+			caCerts: []*x509.Certificate{ca.cert},
+			wantErr: true,
+		},
+		{
+			name:    "signed by CA",
+			entCert: encodeEntCert(blocks["CERTIFICATE"], tamperedData, ca.sign(t, tamperedData)),
+			caCerts: []*x509.Certificate{ca.cert},
+		},
+		{
+			name:    "signed by unknown CA",
+			entCert: encodeEntCert(blocks["CERTIFICATE"], tamperedData, ca.sign(t, tamperedData)),
+			wantErr: true,
+		},
+		{
+			name:    "tampered entitlement data",
+			entCert: encodeEntCert(blocks["CERTIFICATE"], tamperedData, blocks["RSA SIGNATURE"].Bytes),
+			caCerts: []*x509.Certificate{ca.cert},
+			wantErr: true,
+		},
+		{
+			name:    "missing signature",
+			entCert: encodeEntCert(blocks["CERTIFICATE"], entitlementData, nil),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			products, err := getContentFromEntCert(&tt.entCert, tt.caCerts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getContentFromEntCert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !IsInvalidEntitlementSignature(err) {
+					t.Errorf("expected EntitlementSignatureError, got: %v", err)
+				}
+				if products != nil {
+					t.Errorf("content returned from certificate with invalid signature: %v", products)
+				}
+				return
+			}
+			if len(products) != 1 || len(products[0].Content) == 0 {
+				t.Errorf("no content returned from certificate with valid signature")
+			}
+		})
+	}
+}
+
+// TestGenerateRepoFileTamperedEntCert test that content of tampered entitlement
+// certificate is not written to redhat.repo and content of entitlement certificate
+// signed by CA from CA directory is written to redhat.repo
+func TestGenerateRepoFileTamperedEntCert(t *testing.T) {
+	t.Parallel()
+	ca := newTestingCA(t)
+	blocks := readTestingEntCertBlocks(t)
+	tamperedData := tamperEntitlementData(t, blocks["ENTITLEMENT DATA"].Bytes)
+
+	tests := []struct {
+		name            string
+		signature       []byte
+		installCA       bool
+		expectedContent bool
+	}{
+		{
+			name:            "tampered certificate",
+			signature:       blocks["RSA SIGNATURE"].Bytes,
+			installCA:       true,
+			expectedContent: false,
+		},
+		{
+			name:            "certificate signed by installed CA",
+			signature:       ca.sign(t, tamperedData),
+			installCA:       true,
+			expectedContent: true,
+		},
+		{
+			name:            "certificate signed by not installed CA",
+			signature:       ca.sign(t, tamperedData),
+			installCA:       false,
+			expectedContent: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			testingFiles, err := setupTestingFileSystem(
+				t.TempDir(), false, true, true, true, true)
+			if err != nil {
+				t.Fatalf("unable to setup testing environment: %s", err)
+			}
+			rhsmClient, err := setupTestingRHSMClient(testingFiles, nil, nil)
+			if err != nil {
+				t.Fatalf("unable to setup testing rhsm client: %s", err)
+			}
+
+			entCertFilePath := filepath.Join(testingFiles.EntitlementDirPath, testEntCertSerialNumber+".pem")
+			err = os.WriteFile(entCertFilePath, []byte(encodeEntCert(blocks["CERTIFICATE"], tamperedData, tt.signature)), 0644)
+			if err != nil {
+				t.Fatalf("unable to write entitlement certificate: %s", err)
+			}
+			if tt.installCA {
+				err = os.WriteFile(filepath.Join(testingFiles.CACertDirPath, "candlepin-ca.pem"), ca.certPEM(), 0644)
+				if err != nil {
+					t.Fatalf("unable to write CA certificate: %s", err)
+				}
+			}
+
+			_, err = rhsmClient.generateRepoFileFromInstalledEntitlementCerts()
+			if err != nil {
+				t.Fatalf("unable to generate repo file: %s", err)
+			}
+			repoFile, err := os.ReadFile(testingFiles.YumRepoFilePath)
+			if err != nil {
+				t.Fatalf("unable to read repo file: %s", err)
+			}
+			if containsContent := strings.Contains(string(repoFile), "evil.example.com"); containsContent != tt.expectedContent {
+				t.Errorf("repo file contains content of entitlement certificate: %v, expected: %v",
+					containsContent, tt.expectedContent)
+			}
+		})
+	}
+}
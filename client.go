@@ -1,6 +1,7 @@
 package rhsm2
 
 import (
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -8,6 +9,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -24,12 +26,43 @@ type UserAgentInfo struct {
 // certificate/key is installed. Note: we do not create special connection for
 // "Base Auth", because it is actually noAuthConnection with special HTTP header.
 // entitlementCertAuthConnection could be used for communication with CDN.
+// Connections are guarded by connectionMutex, because they are created lazily
+// and they could be replaced, when certificates are changed.
 type RHSMClient struct {
 	UserAgent                     *UserAgentInfo
 	RHSMConf                      *RHSMConf
 	noAuthConnection              *RHSMConnection
 	consumerCertAuthConnection    *RHSMConnection
 	entitlementCertAuthConnection *RHSMConnection
+	connectionMutex               sync.Mutex
+	factCollectors                []FactCollector
+	contentHandlers               []ContentHandler
+	packageProvider               PackageProvider
+	logger                        *zerolog.Logger
+	transport                     http.RoundTripper
+}
+
+// RHSMClientOptions is structure with options used for creating new instance of RHSMClient
+type RHSMClientOptions struct {
+	// ConfFilePath is the path of rhsm.conf. When it is empty, then
+	// DefaultRHSMConfFilePath in RootDirPath is used.
+	ConfFilePath string
+	// RootDirPath is the root directory of the managed system (e.g. offline
+	// image or installroot). All managed files (certificates, repo file,
+	// syspurpose, etc.) are read from and written to this root directory.
+	// Hostname and facts are read from this root directory too. When
+	// ConfFilePath is empty, then rhsm.conf is read from this root directory.
+	RootDirPath string
+	// UserAgent is information used in User-Agent HTTP header. When Distribution
+	// is empty, then it is read from os-release file.
+	UserAgent UserAgentInfo
+	// Logger is used for messages logged by RHSMClient. When it is nil,
+	// then global zerolog logger is used.
+	Logger *zerolog.Logger
+	// Transport is used for all connections instead of default transport,
+	// when it is not nil. TLS and proxy configuration from rhsm.conf is not
+	// applied to this transport.
+	Transport http.RoundTripper
 }
 
 var singletonRhsmClient *RHSMClient
@@ -38,7 +71,8 @@ var once sync.Once
 // GetRHSMClient tries to return the instance of RHSMClient. If the instance
 // already exists, then the existing instance is returned. The confFilePath
 // is used only in the first call of the function. It is just ignored
-// in any other next call.
+// in any other next call. Use NewRHSMClient for creating independent
+// instances of RHSMClient.
 func GetRHSMClient(appName *string, confFilePath *string) (*RHSMClient, error) {
 	var err error
 	once.Do(func() {
@@ -52,114 +86,87 @@ func GetRHSMClient(appName *string, confFilePath *string) (*RHSMClient, error) {
 
 // createRHSMClient tries to create structure holding information about RHSM client
 func createRHSMClient(appName *string, confFilePath *string) (*RHSMClient, error) {
+	options := RHSMClientOptions{
+		UserAgent: UserAgentInfo{
+			AppName: *appName,
+		},
+	}
+	if confFilePath != nil {
+		options.ConfFilePath = *confFilePath
+	}
+	return NewRHSMClient(&options)
+}
+
+// NewRHSMClient tries to create new instance of RHSMClient using given options.
+// Every call returns new independent instance. Thus, it is possible to manage
+// more systems (root directories) in one process.
+func NewRHSMClient(options *RHSMClientOptions) (*RHSMClient, error) {
 	var err error
 	var rhsmConf *RHSMConf
 
+	if options == nil {
+		options = &RHSMClientOptions{}
+	}
+
 	// Try to load configuration file
-	if confFilePath != nil {
-		rhsmConf, err = LoadRHSMConf(*confFilePath)
-	} else {
-		rhsmConf, err = LoadRHSMConf(DefaultRHSMConfFilePath)
+	confFilePath := options.ConfFilePath
+	if confFilePath == "" {
+		confFilePath = filepath.Join(options.RootDirPath, DefaultRHSMConfFilePath)
 	}
+	rhsmConf, err = LoadRHSMConf(confFilePath)
 	if err != nil {
 		return nil, err
 	}
+	rhsmConf.setRootDirPath(options.RootDirPath)
 
+	userAgent := options.UserAgent
 	rhsmClient := &RHSMClient{
-		UserAgent: &UserAgentInfo{
-			AppName:      *appName,
-			Distribution: "",
-		},
+		UserAgent:                     &userAgent,
 		RHSMConf:                      rhsmConf,
 		noAuthConnection:              nil,
 		consumerCertAuthConnection:    nil,
 		entitlementCertAuthConnection: nil,
+		logger:                        options.Logger,
+		transport:                     options.Transport,
 	}
 
-	// Try to get information about the Linux distribution from /etc/os-release
-	content, err := os.ReadFile(rhsmClient.RHSMConf.osReleaseFilePath)
-	if err == nil {
-		release, err := parseOSRelease(&content)
+	// Try to get information about the Linux distribution from /etc/os-release,
+	// when it was not provided in options
+	if rhsmClient.UserAgent.Distribution == "" {
+		content, err := os.ReadFile(rhsmClient.RHSMConf.osReleaseFilePath)
 		if err == nil {
-			rhsmClient.UserAgent.Distribution = release.ID + "/" + release.VersionID
-		}
-	}
-
-	// Try to create connection without authentication
-	// Note: It doesn't do any TCP/TLS handshake ATM
-	err = rhsmClient.createNoAuthConnection(
-		&rhsmConf.Server.Hostname,
-		&rhsmConf.Server.Port,
-		&rhsmConf.Server.Prefix)
-	if err != nil {
-		return nil, err
-	}
-
-	// When the consumer key and the certificate exist, then it is possible
-	// to create a connection using consumer cert/key for authentication
-	consumerCertFilePath := filepath.Join(rhsmConf.RHSM.ConsumerCertDir, "cert.pem")
-	if _, err := os.Stat(consumerCertFilePath); err == nil {
-		consumerKeyFilePath := filepath.Join(rhsmConf.RHSM.ConsumerCertDir, "key.pem")
-		if _, err := os.Stat(consumerKeyFilePath); err == nil {
-			err = rhsmClient.createCertAuthConnection(
-				&rhsmConf.Server.Hostname,
-				&rhsmConf.Server.Port,
-				&rhsmConf.Server.Prefix,
-				&consumerCertFilePath,
-				&consumerKeyFilePath,
-			)
-			if err != nil {
-				return nil, err
+			release, err := parseOSRelease(&content)
+			if err == nil {
+				rhsmClient.UserAgent.Distribution = release.ID + "/" + release.VersionID
 			}
 		}
 	}
 
-	// When the entitlement key and the certificate exist, then it is possible
-	// to create a connection using entitlement cert/key for authentication to CDN
-	certKeys, err := rhsmClient.getInstalledEntitlementCertificateKeys()
+	// Try to create all connections from installed certificates
+	err = rhsmClient.ReloadConnections()
 	if err != nil {
 		return nil, err
 	}
 
-	for _, certKey := range certKeys {
-		if certKey.CertPath == nil {
-			log.Debug().Msgf("cert path is nil")
-			continue
-		}
-		if certKey.KeyPath == nil {
-			log.Debug().Msgf("key path is nil")
-			continue
-		}
-		if _, err := os.Stat(*certKey.KeyPath); err == nil {
-			if _, err := os.Stat(*certKey.CertPath); err == nil {
-				cdnHost, cdnPort, cdnPath, err := parseBaseURL(rhsmConf.RHSM.BaseURL)
-				if err != nil {
-					return nil, err
-				}
-				err = rhsmClient.createEntitlementCertAuthConnection(
-					&cdnHost,
-					&cdnPort,
-					&cdnPath,
-					certKey.CertPath,
-					certKey.KeyPath,
-				)
-				if err != nil {
-					return nil, err
-				}
-			}
-		}
-	}
-
 	return rhsmClient, nil
 }
 
+// getLogger returns logger used by RHSMClient. When no logger was
+// provided in options, then global zerolog logger is used.
+func (rhsmClient *RHSMClient) getLogger() *zerolog.Logger {
+	if rhsmClient.logger == nil {
+		return &log.Logger
+	}
+	return rhsmClient.logger
+}
+
 // parseBaseURL tries to parse the base URL from the RHSM configuration
-func parseBaseURL(baseURL string) (string, string, string, error) {
+func parseBaseURL(logger *zerolog.Logger, baseURL string) (string, string, string, error) {
 	cdnURL, err := url.Parse(baseURL)
 	if err != nil {
 		return "", "", "", err
 	}
-	log.Debug().Msgf("cdnURL: %s, host: %s, port %s", cdnURL, cdnURL.Host, cdnURL.Port())
+	logger.Debug().Msgf("cdnURL: %s, host: %s, port %s", cdnURL, cdnURL.Host, cdnURL.Port())
 	cdnPort := cdnURL.Port()
 	cdnHost := cdnURL.Host
 	if cdnPort == "" {
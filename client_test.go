@@ -1,6 +1,18 @@
 package rhsm2
 
-import "testing"
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
 
 // TestCreateRHSMClient test the case, when client is
 // successfully created using given configuration file
@@ -55,3 +67,244 @@ func TestGetRHSMClient(t *testing.T) {
 		}
 	}
 }
+
+// TestNewRHSMClient test that NewRHSMClient returns independent
+// instances of RHSMClient
+func TestNewRHSMClient(t *testing.T) {
+	t.Parallel()
+	confFilePath := "./testdata/etc/rhsm/rhsm.conf"
+	options := RHSMClientOptions{
+		ConfFilePath: confFilePath,
+		UserAgent: UserAgentInfo{
+			AppName:      "unit-tester/0.1",
+			Distribution: "foo-linux/10.0",
+		},
+	}
+
+	rhsmClient01, err := NewRHSMClient(&options)
+	if err != nil {
+		t.Fatalf("unable to create RHSM client: %s", err)
+	}
+	rhsmClient02, err := NewRHSMClient(&options)
+	if err != nil {
+		t.Fatalf("unable to create another RHSM client: %s", err)
+	}
+
+	if rhsmClient01 == rhsmClient02 {
+		t.Fatalf("NewRHSMClient returned the same instance twice")
+	}
+	if rhsmClient01.RHSMConf == rhsmClient02.RHSMConf {
+		t.Fatalf("instances of RHSM client share configuration")
+	}
+	if rhsmClient01.noAuthConnection == rhsmClient02.noAuthConnection {
+		t.Fatalf("instances of RHSM client share no-auth connection")
+	}
+
+	// Changing of one client must not affect the other one
+	rhsmClient01.RHSMConf.Server.Hostname = "candlepin.example.com"
+	if rhsmClient02.RHSMConf.Server.Hostname != "candlepin.company.com" {
+		t.Fatalf("changing configuration of one client changed configuration of other client")
+	}
+
+	// Distribution provided in options is not replaced by content of os-release
+	if rhsmClient01.UserAgent.Distribution != "foo-linux/10.0" {
+		t.Fatalf("expected distribution: %s, got: %s", "foo-linux/10.0", rhsmClient01.UserAgent.Distribution)
+	}
+}
+
+// testingRootDirRHSMConf is rhsm.conf installed in testing root directory.
+// Default paths of all directories are used.
+const testingRootDirRHSMConf = `[server]
+hostname = candlepin.company.com
+prefix = /candlepin
+port = 8443
+
+[rhsm]
+baseurl = https://cdn.redhat.com
+`
+
+// TestNewRHSMClientRootDir test that rhsm.conf is read from root
+// directory, when no configuration file is provided, and all managed
+// files are relocated to the root directory
+func TestNewRHSMClientRootDir(t *testing.T) {
+	t.Parallel()
+	rootDirPath := t.TempDir()
+	testingFiles, err := setupTestingFileSystem(rootDirPath, true, true, true, true, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	srcCACertFilePath := "./testdata/etc/rhsm/ca/candlepin-ca.pem"
+	dstCACertFilePath := filepath.Join(testingFiles.CACertDirPath, "candlepin-ca.pem")
+	perm := os.FileMode(0644)
+	err = copyFile(&srcCACertFilePath, &dstCACertFilePath, &perm)
+	if err != nil {
+		t.Fatalf("unable to copy %s: %s", srcCACertFilePath, err)
+	}
+
+	dstConfFilePath := filepath.Join(rootDirPath, DefaultRHSMConfFilePath)
+	err = os.WriteFile(dstConfFilePath, []byte(testingRootDirRHSMConf), 0644)
+	if err != nil {
+		t.Fatalf("unable to write %s: %s", dstConfFilePath, err)
+	}
+
+	err = os.WriteFile(filepath.Join(rootDirPath, "etc", "hostname"), []byte("image.company.com\n"), 0644)
+	if err != nil {
+		t.Fatalf("unable to write hostname: %s", err)
+	}
+
+	rhsmClient, err := NewRHSMClient(&RHSMClientOptions{
+		RootDirPath: rootDirPath,
+		UserAgent:   UserAgentInfo{AppName: "unit-tester/0.1"},
+	})
+	if err != nil {
+		t.Fatalf("unable to create RHSM client: %s", err)
+	}
+
+	if rhsmClient.RHSMConf.filePath != dstConfFilePath {
+		t.Fatalf("expected configuration file: %s, got: %s", dstConfFilePath, rhsmClient.RHSMConf.filePath)
+	}
+
+	if rhsmClient.RHSMConf.RootDirPath() != rootDirPath {
+		t.Fatalf("expected root directory: %s, got: %s", rootDirPath, rhsmClient.RHSMConf.RootDirPath())
+	}
+
+	// All managed paths have to be in the root directory
+	expectedPaths := map[string]string{
+		"consumer cert dir":        testingFiles.ConsumerDirPath,
+		"entitlement cert dir":     testingFiles.EntitlementDirPath,
+		"product cert dir":         testingFiles.ProductDirPath,
+		"default product cert dir": testingFiles.ProductDefaultDirPath,
+		"CA cert dir":              testingFiles.CACertDirPath,
+		"redhat.repo":              testingFiles.YumRepoFilePath,
+		"syspurpose":               filepath.Join(testingFiles.SyspurposeDirPath, "syspurpose.json"),
+		"os-release":               testingFiles.OsReleaseFilePath,
+		"dnf vars":                 filepath.Join(rootDirPath, DefaultDnfVarsReleaseFilePath),
+		"dnf5 override file":       filepath.Join(rootDirPath, dnf5RedHatReposOverrideFilePath),
+		"facts root dir":           rootDirPath,
+		"facts cache":              filepath.Join(testingFiles.CacheDirPath, "facts", "facts.json"),
+		"repo file cache":          filepath.Join(testingFiles.CacheDirPath, "repos", "redhat.repo.json"),
+	}
+	actualPaths := map[string]string{
+		"consumer cert dir":        rhsmClient.RHSMConf.RHSM.ConsumerCertDir,
+		"entitlement cert dir":     rhsmClient.RHSMConf.RHSM.EntitlementCertDir,
+		"product cert dir":         rhsmClient.RHSMConf.RHSM.ProductCertDir,
+		"default product cert dir": rhsmClient.RHSMConf.RHSM.DefaultProductCertDir,
+		"CA cert dir":              rhsmClient.RHSMConf.RHSM.CACertDir,
+		"redhat.repo":              rhsmClient.RHSMConf.yumRepoFilePath,
+		"syspurpose":               rhsmClient.RHSMConf.syspurposeFilePath,
+		"os-release":               rhsmClient.RHSMConf.osReleaseFilePath,
+		"dnf vars":                 rhsmClient.RHSMConf.dnfVarsReleaseFilePath,
+		"dnf5 override file":       rhsmClient.RHSMConf.dnf5ReposOverrideFilePath,
+		"facts root dir":           rhsmClient.RHSMConf.factsRootDirPath,
+		"facts cache":              rhsmClient.RHSMConf.factsCacheFilePath,
+		"repo file cache":          rhsmClient.RHSMConf.repoFileCacheFilePath,
+	}
+	for name, expectedPath := range expectedPaths {
+		if filepath.Clean(actualPaths[name]) != filepath.Clean(expectedPath) {
+			t.Errorf("expected %s: %s, got: %s", name, expectedPath, actualPaths[name])
+		}
+	}
+
+	// Consumer certificate from root directory is used
+	if rhsmClient.consumerCertAuthConnection == nil {
+		t.Fatalf("consumer cert auth connection not created from consumer cert in root directory")
+	}
+
+	// Distribution is read from os-release in root directory
+	if rhsmClient.UserAgent.Distribution != "rhel/10.0" {
+		t.Fatalf("expected distribution: %s, got: %s", "rhel/10.0", rhsmClient.UserAgent.Distribution)
+	}
+
+	// Hostname is read from root directory
+	hostname, err := rhsmClient.getHostname()
+	if err != nil {
+		t.Fatalf("unable to get hostname: %s", err)
+	}
+	if hostname != "image.company.com" {
+		t.Fatalf("expected hostname: %s, got: %s", "image.company.com", hostname)
+	}
+
+	// Repo file is written to root directory, but it refers to paths in the managed system
+	_, err = rhsmClient.generateRepoFileFromInstalledEntitlementCerts()
+	if err != nil {
+		t.Fatalf("unable to generate repo file: %s", err)
+	}
+	content, err := os.ReadFile(testingFiles.YumRepoFilePath)
+	if err != nil {
+		t.Fatalf("unable to read %s: %s", testingFiles.YumRepoFilePath, err)
+	}
+	if !strings.Contains(string(content), "sslclientcert=/etc/pki/entitlement/4709416649487329566.pem") {
+		t.Fatalf("repo file does not refer to entitlement cert in managed system:\n%s", content)
+	}
+	if strings.Contains(string(content), rootDirPath) {
+		t.Fatalf("repo file contains path of root directory:\n%s", content)
+	}
+
+	// Configuration file does not exist in empty root directory
+	_, err = NewRHSMClient(&RHSMClientOptions{
+		RootDirPath: t.TempDir(),
+		UserAgent:   UserAgentInfo{AppName: "unit-tester/0.1"},
+	})
+	if err == nil {
+		t.Fatalf("creating RHSM client without configuration file did not fail")
+	}
+}
+
+// TestNewRHSMClientTransportLogger test that transport and logger
+// provided in options are used by RHSMClient
+func TestNewRHSMClientTransportLogger(t *testing.T) {
+	t.Parallel()
+	var handlerCounter int32
+
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&handlerCounter, 1)
+			if req.Method != http.MethodGet || req.URL.Path != "/candlepin/status" {
+				t.Errorf("unexpected REST API call: %s %s", req.Method, req.URL.String())
+			}
+			rw.WriteHeader(200)
+			_, _ = rw.Write([]byte(serverStatusResponse))
+		}))
+	defer server.Close()
+
+	var logBuffer bytes.Buffer
+	logger := zerolog.New(&logBuffer).Level(zerolog.DebugLevel)
+
+	rhsmClient, err := NewRHSMClient(&RHSMClientOptions{
+		ConfFilePath: "./testdata/etc/rhsm/rhsm.conf",
+		UserAgent:    UserAgentInfo{AppName: "unit-tester/0.1"},
+		Logger:       &logger,
+		Transport:    server.Client().Transport,
+	})
+	if err != nil {
+		t.Fatalf("unable to create RHSM client: %s", err)
+	}
+
+	// Connections use pointers to the configuration
+	serverURL, _ := url.Parse(server.URL)
+	rhsmClient.RHSMConf.Server.Hostname = serverURL.Hostname()
+	rhsmClient.RHSMConf.Server.Port = serverURL.Port()
+
+	serverStatus, err := rhsmClient.GetServerStatus(nil)
+	if err != nil {
+		t.Fatalf("getting server status failed: %s", err)
+	}
+	if serverStatus.Version != "4.3.8" {
+		t.Fatalf("expected server version: %s, got: %s", "4.3.8", serverStatus.Version)
+	}
+	if atomic.LoadInt32(&handlerCounter) != 1 {
+		t.Fatalf("expected one request, got: %d", atomic.LoadInt32(&handlerCounter))
+	}
+	if !strings.Contains(logBuffer.String(), "http request GET /candlepin/status") {
+		t.Fatalf("http request was not logged using provided logger, log: %s", logBuffer.String())
+	}
+
+	// Trigger some debug message logged by RHSMClient
+	_ = rhsmClient.getProxyFunc()
+	rhsmClient.RHSMConf.Server.ProxyHostname = "proxy.example.com"
+	_ = rhsmClient.getProxyFunc()
+	if !strings.Contains(logBuffer.String(), "using proxy configuration from rhsm.conf") {
+		t.Fatalf("message was not logged using provided logger, log: %s", logBuffer.String())
+	}
+}
@@ -2,6 +2,7 @@ package rhsm2
 
 import (
 	"fmt"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
@@ -86,6 +87,51 @@ type RHSMConf struct {
 	// osReleaseFilePath is the file path of the os-release file
 	osReleaseFilePath string
 
+	// factsRootDirPath is the root directory used for collecting system facts
+	factsRootDirPath string
+
+	// customFactsDirPath is the directory with custom facts (*.facts files)
+	customFactsDirPath string
+
+	// factsCacheFilePath is the file path of the cache with reported facts
+	factsCacheFilePath string
+
+	// packageProfileCacheFilePath is the file path of the cache with uploaded package profile
+	packageProfileCacheFilePath string
+
+	// repoFileCacheFilePath is the file path of the cache with repositories generated to redhat.repo
+	repoFileCacheFilePath string
+
+	// dnf5ReposOverrideFilePath is the file path of the dnf5 repo override file with content overrides
+	dnf5ReposOverrideFilePath string
+
+	// containerCertsDirPath is the directory with certificates of container registries
+	containerCertsDirPath string
+
+	// ostreeRemotesFilePath is the file path of configuration of ostree remotes
+	ostreeRemotesFilePath string
+
+	// contentOverridesCacheFilePath is the file path of the cache with content overrides
+	contentOverridesCacheFilePath string
+
+	// productIdDBFilePath is the file path of the database mapping product IDs to repositories
+	productIdDBFilePath string
+
+	// dnfVersion is the generation of dnf used on the managed system
+	dnfVersion DnfVersion
+
+	// rootDirPath is the root directory of the managed system. All managed
+	// file paths are relocated to this directory. When it is empty, then "/" is used.
+	rootDirPath string
+
+	// filePermissions is the policy of access permissions of written files.
+	// When it is nil, then DefaultFilePermissions is used
+	filePermissions *FilePermissions
+
+	// retryPolicy is the policy used for retrying failed HTTP requests.
+	// When it is nil, then failed HTTP requests are not retried
+	retryPolicy *RetryPolicy
+
 	// Public attributes
 
 	// Server represents section [server]
@@ -183,11 +229,21 @@ func IsValueAllowed(value *reflect.Value, allowedValues *string) (bool, error) {
 // RHSMConf structure
 func LoadRHSMConf(confFilePath string) (*RHSMConf, error) {
 	rhsmConf := &RHSMConf{
-		filePath:               confFilePath,
-		yumRepoFilePath:        DefaultRepoFilePath,
-		dnfVarsReleaseFilePath: DefaultDnfVarsReleaseFilePath,
-		syspurposeFilePath:     DefaultSystemPurposeFilePath,
-		osReleaseFilePath:      DefaultOsReleaseFilePath,
+		filePath:                      confFilePath,
+		yumRepoFilePath:               DefaultRepoFilePath,
+		dnfVarsReleaseFilePath:        DefaultDnfVarsReleaseFilePath,
+		syspurposeFilePath:            DefaultSystemPurposeFilePath,
+		osReleaseFilePath:             DefaultOsReleaseFilePath,
+		factsRootDirPath:              DefaultFactsRootDirPath,
+		customFactsDirPath:            DefaultCustomFactsDirPath,
+		factsCacheFilePath:            DefaultFactsCacheFilePath,
+		packageProfileCacheFilePath:   DefaultPackageProfileCacheFilePath,
+		repoFileCacheFilePath:         DefaultRepoFileCacheFilePath,
+		dnf5ReposOverrideFilePath:     dnf5RedHatReposOverrideFilePath,
+		contentOverridesCacheFilePath: DefaultContentOverridesCacheFilePath,
+		containerCertsDirPath:         DefaultContainerCertsDirPath,
+		ostreeRemotesFilePath:         DefaultOstreeRemotesFilePath,
+		productIdDBFilePath:           DefaultProductIdDBFilePath,
 	}
 
 	err := rhsmConf.load()
@@ -197,3 +253,62 @@ func LoadRHSMConf(confFilePath string) (*RHSMConf, error) {
 
 	return rhsmConf, nil
 }
+
+// setRootDirPath tries to relocate all managed file paths (certificate directories,
+// repo file, dnf variables, syspurpose, os-release, facts, caches, etc.) to the given
+// root directory. It is intended for managing offline images or installroot. It should
+// be called only once, after the configuration file is loaded. The repo_ca_cert is not
+// relocated, because it is only referenced from generated repo file.
+func (rhsmConf *RHSMConf) setRootDirPath(rootDirPath string) {
+	if rootDirPath == "" || filepath.Clean(rootDirPath) == "/" {
+		return
+	}
+	rootDirPath = filepath.Clean(rootDirPath)
+	rhsmConf.rootDirPath = rootDirPath
+
+	for _, path := range []*string{
+		&rhsmConf.yumRepoFilePath,
+		&rhsmConf.dnfVarsReleaseFilePath,
+		&rhsmConf.syspurposeFilePath,
+		&rhsmConf.osReleaseFilePath,
+		&rhsmConf.factsRootDirPath,
+		&rhsmConf.customFactsDirPath,
+		&rhsmConf.factsCacheFilePath,
+		&rhsmConf.packageProfileCacheFilePath,
+		&rhsmConf.repoFileCacheFilePath,
+		&rhsmConf.dnf5ReposOverrideFilePath,
+		&rhsmConf.contentOverridesCacheFilePath,
+		&rhsmConf.containerCertsDirPath,
+		&rhsmConf.ostreeRemotesFilePath,
+		&rhsmConf.productIdDBFilePath,
+		&rhsmConf.RHSM.CACertDir,
+		&rhsmConf.RHSM.ConsumerCertDir,
+		&rhsmConf.RHSM.EntitlementCertDir,
+		&rhsmConf.RHSM.ProductCertDir,
+		&rhsmConf.RHSM.DefaultProductCertDir,
+	} {
+		*path = filepath.Join(rootDirPath, *path)
+	}
+}
+
+// RootDirPath returns the root directory of the managed system
+func (rhsmConf *RHSMConf) RootDirPath() string {
+	if rhsmConf.rootDirPath == "" {
+		return "/"
+	}
+	return rhsmConf.rootDirPath
+}
+
+// pathInRootDir returns the file path as it is seen from the managed system,
+// when the root directory is used. It is used for file paths written
+// to generated configuration files (e.g. sslclientcert in redhat.repo).
+func (rhsmConf *RHSMConf) pathInRootDir(filePath string) string {
+	if rhsmConf.rootDirPath == "" {
+		return filePath
+	}
+	relPath, err := filepath.Rel(rhsmConf.rootDirPath, filePath)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, "../") {
+		return filePath
+	}
+	return filepath.Join("/", relPath)
+}
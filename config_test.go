@@ -1,6 +1,7 @@
 package rhsm2
 
 import (
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -106,3 +107,68 @@ func TestIsValueAllowed(t *testing.T) {
 		}
 	}
 }
+
+// TestRHSMConfSetRootDirPath test relocating of managed paths to root directory
+// and translating of paths back to paths in the managed system
+func TestRHSMConfSetRootDirPath(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name                    string
+		rootDirPath             string
+		expectedRootDirPath     string
+		expectedConsumerCertDir string
+	}{
+		{
+			name:                    "no root directory",
+			rootDirPath:             "",
+			expectedRootDirPath:     "/",
+			expectedConsumerCertDir: "/etc/pki/consumer",
+		},
+		{
+			name:                    "slash",
+			rootDirPath:             "/",
+			expectedRootDirPath:     "/",
+			expectedConsumerCertDir: "/etc/pki/consumer",
+		},
+		{
+			name:                    "image root directory",
+			rootDirPath:             "/var/tmp/image/",
+			expectedRootDirPath:     "/var/tmp/image",
+			expectedConsumerCertDir: "/var/tmp/image/etc/pki/consumer",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			rhsmConf, err := LoadRHSMConf("./testdata/etc/rhsm/rhsm.conf")
+			if err != nil {
+				t.Fatalf("unable to load configuration file: %s", err)
+			}
+			rhsmConf.RHSM.ConsumerCertDir = "/etc/pki/consumer"
+			repoCACertificate := rhsmConf.RHSM.RepoCACertificate
+
+			rhsmConf.setRootDirPath(tt.rootDirPath)
+
+			if rhsmConf.RootDirPath() != tt.expectedRootDirPath {
+				t.Errorf("expected root directory: %s, got: %s", tt.expectedRootDirPath, rhsmConf.RootDirPath())
+			}
+			if rhsmConf.RHSM.ConsumerCertDir != tt.expectedConsumerCertDir {
+				t.Errorf("expected consumer cert dir: %s, got: %s",
+					tt.expectedConsumerCertDir, rhsmConf.RHSM.ConsumerCertDir)
+			}
+
+			// Paths written to generated files have to be paths in the managed system
+			certPath := filepath.Join(rhsmConf.RHSM.ConsumerCertDir, "cert.pem")
+			if rhsmConf.pathInRootDir(certPath) != "/etc/pki/consumer/cert.pem" {
+				t.Errorf("expected path in root directory: %s, got: %s",
+					"/etc/pki/consumer/cert.pem", rhsmConf.pathInRootDir(certPath))
+			}
+
+			// Repo CA certificate is only referenced from repo file
+			if rhsmConf.RHSM.RepoCACertificate != repoCACertificate {
+				t.Errorf("repo CA certificate should not be relocated: %s", rhsmConf.RHSM.RepoCACertificate)
+			}
+		})
+	}
+}
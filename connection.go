@@ -2,19 +2,21 @@ package rhsm2
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/henvic/httpretty"
 	"github.com/jeandeaual/go-locale"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -43,6 +45,18 @@ type RHSMConnection struct {
 	ServerHostname *string
 	ServerPort     *string
 	ServerPrefix   *string
+	// RetryPolicy is used for retrying failed requests. Zero value disables retrying
+	RetryPolicy RetryPolicy
+	// logger is used for logging of requests. When it is nil, then global zerolog logger is used
+	logger *zerolog.Logger
+}
+
+// getLogger returns logger used by the connection
+func (connection *RHSMConnection) getLogger() *zerolog.Logger {
+	if connection.logger == nil {
+		return &log.Logger
+	}
+	return connection.logger
 }
 
 // createCorrelationId
@@ -73,8 +87,13 @@ func sanitizeMetadata(metadata *RequestMetadata) *RequestMetadata {
 	return metadata
 }
 
-// request tries to call HTTP request to candlepin server
+// request tries to call HTTP request to candlepin server. The request is
+// canceled, when the given context is canceled or its deadline is exceeded.
+// Failed request is retried according to the retry policy of the connection.
+// All attempts use the same Correlation-ID, but every attempt has its own
+// Request-ID.
 func (connection *RHSMConnection) request(
+	ctx context.Context,
 	userAgent *UserAgentInfo,
 	method string,
 	path string,
@@ -95,27 +114,16 @@ func (connection *RHSMConnection) request(
 
 	requestUrl := requestURL.String()
 
-	var buffer *bytes.Buffer
-	if body != nil {
-		buffer = bytes.NewBuffer(*body)
-	} else {
-		buffer = &bytes.Buffer{}
-	}
-
-	req, err := http.NewRequest(method, requestUrl, buffer)
-	if err != nil {
-		return nil, fmt.Errorf("unable to create http request %s: %s", method, err)
-	}
-
 	// When connection without cert/key auth is used, then it is possible to
 	// use basic authentication username/password
+	var username, password string
+	var useBasicAuth bool
 	if connection.AuthType == NoAuth && headers != nil {
 		// Set username and password for basic authentication
-		username, usernameExist := (*headers)["username"]
-		password, passwordExist := (*headers)["password"]
-		if usernameExist && passwordExist {
-			req.SetBasicAuth(username, password)
-		}
+		var usernameExist, passwordExist bool
+		username, usernameExist = (*headers)["username"]
+		password, passwordExist = (*headers)["password"]
+		useBasicAuth = usernameExist && passwordExist
 		// Remove username and password from map of headers
 		if usernameExist {
 			delete(*headers, "username")
@@ -125,6 +133,77 @@ func (connection *RHSMConnection) request(
 		}
 	}
 
+	retryable := isRetryableRequest(ctx, method)
+
+	for attempt := 1; ; attempt++ {
+		// Body has to be created for every attempt, because previous attempt
+		// could read the whole buffer
+		var buffer *bytes.Buffer
+		if body != nil {
+			buffer = bytes.NewBuffer(*body)
+		} else {
+			buffer = &bytes.Buffer{}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, requestUrl, buffer)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create http request %s: %s", method, err)
+		}
+
+		if useBasicAuth {
+			req.SetBasicAuth(username, password)
+		}
+
+		addRequestHeaders(req, userAgent, headers, metadata)
+
+		connection.getLogger().Debug().Msgf("http request %s %s, attempt: %d, Correlation-ID: %s, Request-ID: %s",
+			method, requestURL.Path, attempt,
+			req.Header.Get("Correlation-ID"), req.Header.Get("Request-ID"))
+
+		res, err := connection.Client.Do(req)
+
+		var delay time.Duration
+		var retry bool
+		// Do not retry, when the context was canceled
+		if retryable && ctx.Err() == nil {
+			delay, retry = connection.RetryPolicy.retryDelay(attempt, res, err)
+		}
+		if !retry {
+			if err != nil {
+				return nil, fmt.Errorf("error making http request %s: %s", method, err)
+			}
+			return res, nil
+		}
+
+		if err != nil {
+			connection.getLogger().Warn().Msgf("http request %s %s, attempt: %d failed: %s, retrying in %v",
+				method, requestURL.Path, attempt, err, delay)
+		} else {
+			connection.getLogger().Warn().Msgf("http request %s %s, attempt: %d failed with status code: %d, retrying in %v",
+				method, requestURL.Path, attempt, res.StatusCode, delay)
+			// Read the rest of the body to be able to reuse the connection
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("error making http request %s: %s", method, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// addRequestHeaders adds all HTTP headers to the request. The Request-ID
+// header is unique for every call of this function.
+func addRequestHeaders(
+	req *http.Request,
+	userAgent *UserAgentInfo,
+	headers *map[string]string,
+	metadata *RequestMetadata,
+) {
 	// Always add HTTP header UserAgent
 	if metadata != nil && metadata.IPCSender != nil {
 		req.Header.Add(
@@ -177,19 +256,18 @@ func (connection *RHSMConnection) request(
 			req.Header.Add(key, value)
 		}
 	}
-
-	res, err := connection.Client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error making http request %s: %s", method, err)
-	}
-
-	return res, nil
 }
 
 // createHTTPsClient tries to create instance of http.Client and configure to use TLS.
 // When certFile and keyFile are not nil, then these two file will be used for client
 // authentication.
 func (rhsmClient *RHSMClient) createHTTPsClient(certFile *string, keyFile *string) (*http.Client, error) {
+	// Transport from options of RHSMClient overrides default transport
+	// including configuration of TLS and proxy server
+	if rhsmClient.transport != nil {
+		return rhsmClient.newHTTPClient(rhsmClient.transport), nil
+	}
+
 	insecure := rhsmClient.RHSMConf.Server.Insecure
 	caDir := rhsmClient.RHSMConf.RHSM.CACertDir
 
@@ -238,42 +316,15 @@ func (rhsmClient *RHSMClient) createHTTPsClient(certFile *string, keyFile *strin
 	transport := http.DefaultTransport.(*http.Transport).Clone()
 	transport.TLSClientConfig = tlsConfig
 
-	if rhsmClient.RHSMConf.Server.ProxyHostname != "" {
-		log.Debug().Msgf("using proxy configuration from rhsm.conf")
-		proxyHostname := rhsmClient.RHSMConf.Server.ProxyHostname
-		proxyPort := rhsmClient.RHSMConf.Server.ProxyPort
-		proxyUser := rhsmClient.RHSMConf.Server.ProxyUser
-		proxyPassword := rhsmClient.RHSMConf.Server.ProxyPassword
-
-		// Detect if proxyHostname is raw IPv6 address and assembly
-		// proxyHostnamePort, because url.URL does not have Port field
-		var proxyHostnamePort string
-		ipAddress := net.ParseIP(proxyHostname)
-		if ipAddress != nil && len(ipAddress) == net.IPv6len {
-			proxyHostnamePort = "[" + proxyHostname + "]:" + proxyPort
-		} else {
-			proxyHostnamePort = proxyHostname + ":" + proxyPort
-		}
-
-		var proxyURL url.URL
-		if proxyUser != "" || proxyPassword != "" {
-			proxyURL = url.URL{
-				Scheme: "https",
-				Host:   proxyHostnamePort,
-				User:   url.UserPassword(proxyUser, proxyPassword),
-			}
-		} else {
-			proxyURL = url.URL{
-				Scheme: "https",
-				Host:   proxyHostnamePort,
-			}
-		}
+	// Note: it is not possible to use http.ProxyFromEnvironment, because we have to
+	// use configuration from rhsm.conf too
+	transport.Proxy = rhsmClient.getProxyFunc()
 
-		log.Debug().Msgf("using proxy: %s", proxyURL.String())
-
-		transport.Proxy = http.ProxyURL(&proxyURL)
-	}
+	return rhsmClient.newHTTPClient(transport), nil
+}
 
+// newHTTPClient creates instance of http.Client using given transport
+func (rhsmClient *RHSMClient) newHTTPClient(transport http.RoundTripper) *http.Client {
 	var client *http.Client
 
 	// If env variables are set, then client will pretty print some
@@ -300,60 +351,89 @@ func (rhsmClient *RHSMClient) createHTTPsClient(certFile *string, keyFile *strin
 		client = &http.Client{Transport: transport}
 	}
 
-	return client, nil
+	// Use server_timeout from rhsm.conf as the time limit for whole request
+	// including reading of response body. Zero or negative value means no timeout.
+	if rhsmClient.RHSMConf.Server.Timeout > 0 {
+		client.Timeout = time.Duration(rhsmClient.RHSMConf.Server.Timeout) * time.Second
+	}
+
+	return client
 }
 
 // getNoAuthConnection establishes or retrieves a no-authentication connection to the RHSM server.
 func (rhsmClient *RHSMClient) getNoAuthConnection() (*RHSMConnection, error) {
+	rhsmClient.connectionMutex.Lock()
+	defer rhsmClient.connectionMutex.Unlock()
+
 	if rhsmClient.noAuthConnection != nil {
 		return rhsmClient.noAuthConnection, nil
 	}
 
-	hostname := &rhsmClient.RHSMConf.Server.Hostname
-	port := &rhsmClient.RHSMConf.Server.Port
-	prefix := &rhsmClient.RHSMConf.Server.Prefix
-
-	err := rhsmClient.createNoAuthConnection(hostname, port, prefix)
+	connection, err := rhsmClient.newNoAuthConnection(
+		&rhsmClient.RHSMConf.Server.Hostname,
+		&rhsmClient.RHSMConf.Server.Port,
+		&rhsmClient.RHSMConf.Server.Prefix,
+	)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create no-auth connection: %v", err)
 	}
+	rhsmClient.noAuthConnection = connection
 
-	return rhsmClient.noAuthConnection, nil
+	return connection, nil
 }
 
 // createNoAuthConnection tries to create connection not using any cert authentication of client
+// and it replaces existing no-auth connection
 func (rhsmClient *RHSMClient) createNoAuthConnection(
 	hostname *string,
 	port *string,
 	prefix *string,
 ) error {
+	rhsmClient.connectionMutex.Lock()
+	defer rhsmClient.connectionMutex.Unlock()
+
+	connection, err := rhsmClient.newNoAuthConnection(hostname, port, prefix)
+	if err != nil {
+		return err
+	}
+	rhsmClient.noAuthConnection = connection
+
+	return nil
+}
+
+// newNoAuthConnection tries to create new connection not using any cert authentication of client
+func (rhsmClient *RHSMClient) newNoAuthConnection(
+	hostname *string,
+	port *string,
+	prefix *string,
+) (*RHSMConnection, error) {
 	client, err := rhsmClient.createHTTPsClient(nil, nil)
 
 	if err != nil {
-		return fmt.Errorf("unable to create no-auth connection: %v", err)
+		return nil, fmt.Errorf("unable to create no-auth connection: %v", err)
 	}
 
-	rhsmClient.noAuthConnection = &RHSMConnection{
+	return &RHSMConnection{
 		AuthType:       NoAuth,
 		Client:         client,
 		ServerHostname: hostname,
 		ServerPort:     port,
 		ServerPrefix:   prefix,
-	}
-
-	return nil
+		RetryPolicy:    rhsmClient.getRetryPolicy(),
+		logger:         rhsmClient.getLogger(),
+	}, nil
 }
 
 // getCertAuthConnection tries to get the current consumer cert auth connection. When the connection
 // does not exist, it creates a new one using the provided configuration.
 func (rhsmClient *RHSMClient) getCertAuthConnection() (*RHSMConnection, error) {
+	rhsmClient.connectionMutex.Lock()
+	defer rhsmClient.connectionMutex.Unlock()
+
 	if rhsmClient.consumerCertAuthConnection != nil {
 		return rhsmClient.consumerCertAuthConnection, nil
 	}
 
-	hostname := &rhsmClient.RHSMConf.Server.Hostname
-	port := &rhsmClient.RHSMConf.Server.Port
-	prefix := &rhsmClient.RHSMConf.Server.Prefix
 	consumerCertFilePath := filepath.Join(rhsmClient.RHSMConf.RHSM.ConsumerCertDir, "cert.pem")
 	if _, err := os.Stat(consumerCertFilePath); err != nil {
 		return nil, fmt.Errorf("consumer certificate %s does not exists", consumerCertFilePath)
@@ -362,17 +442,24 @@ func (rhsmClient *RHSMClient) getCertAuthConnection() (*RHSMConnection, error) {
 	if _, err := os.Stat(consumerKeyFilePath); err != nil {
 		return nil, fmt.Errorf("consumer key %s does not exists", consumerKeyFilePath)
 	}
-	err := rhsmClient.createCertAuthConnection(hostname, port, prefix, &consumerCertFilePath, &consumerKeyFilePath)
+	connection, err := rhsmClient.newCertAuthConnection(
+		&rhsmClient.RHSMConf.Server.Hostname,
+		&rhsmClient.RHSMConf.Server.Port,
+		&rhsmClient.RHSMConf.Server.Prefix,
+		&consumerCertFilePath,
+		&consumerKeyFilePath,
+	)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create consumer cert auth connection: %v", err)
 	}
+	rhsmClient.consumerCertAuthConnection = connection
 
-	return rhsmClient.consumerCertAuthConnection, nil
+	return connection, nil
 }
 
-// createCertAuthConnection tries to create connection using some cert for authentication.
-// Consumer cert/key is used for auth against candlepin server and entitlement
-// cert/key is used
+// createCertAuthConnection tries to create connection using consumer cert/key for authentication
+// against candlepin server, and it replaces existing consumer cert auth connection. It is used,
+// when new consumer cert/key was installed (e.g. during registration).
 func (rhsmClient *RHSMClient) createCertAuthConnection(
 	hostname *string,
 	port *string,
@@ -380,70 +467,99 @@ func (rhsmClient *RHSMClient) createCertAuthConnection(
 	certFilePath *string,
 	keyFilePath *string,
 ) error {
+	rhsmClient.connectionMutex.Lock()
+	defer rhsmClient.connectionMutex.Unlock()
+
+	connection, err := rhsmClient.newCertAuthConnection(hostname, port, prefix, certFilePath, keyFilePath)
+	if err != nil {
+		return err
+	}
+	rhsmClient.consumerCertAuthConnection = connection
+
+	return nil
+}
+
+// newCertAuthConnection tries to create new connection using consumer cert/key for authentication
+func (rhsmClient *RHSMClient) newCertAuthConnection(
+	hostname *string,
+	port *string,
+	prefix *string,
+	certFilePath *string,
+	keyFilePath *string,
+) (*RHSMConnection, error) {
 	client, err := rhsmClient.createHTTPsClient(certFilePath, keyFilePath)
 
 	if err != nil {
-		return fmt.Errorf("unable to create consumer cert auth connection: %v", err)
+		return nil, fmt.Errorf("unable to create consumer cert auth connection: %v", err)
 	}
 
-	rhsmClient.consumerCertAuthConnection = &RHSMConnection{
+	return &RHSMConnection{
 		AuthType:       ConsumerCertAuth,
 		Client:         client,
 		ServerHostname: hostname,
 		ServerPort:     port,
 		ServerPrefix:   prefix,
-	}
-
-	return nil
+		RetryPolicy:    rhsmClient.getRetryPolicy(),
+		logger:         rhsmClient.getLogger(),
+	}, nil
 }
 
-// getEntitlementCertAuthConnection returns the entitlement cert auth connection if it exists
-// and it tries to create it if it doesn't exist
-func (rhsmClient *RHSMClient) getEntitlementCertAuthConnection() (*RHSMConnection, error) {
-	if rhsmClient.entitlementCertAuthConnection != nil {
-		return rhsmClient.entitlementCertAuthConnection, nil
-	}
-
-	cdnHost, cdnPort, cdnPath, err := parseBaseURL(rhsmClient.RHSMConf.RHSM.BaseURL)
-	if err != nil {
-		return nil, err
-	}
-
+// findEntitlementCertKey tries to find installed entitlement cert and key, which could be
+// used for authentication to CDN. When no such pair is installed, then nil paths are returned.
+func (rhsmClient *RHSMClient) findEntitlementCertKey() (*string, *string, error) {
 	certKeys, err := rhsmClient.getInstalledEntitlementCertificateKeys()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	var certPath, keyPath *string
 	for _, certKey := range certKeys {
 		if certKey.CertPath == nil {
-			log.Debug().Msgf("cert path is nil")
+			rhsmClient.getLogger().Debug().Msgf("cert path is nil")
 			continue
 		}
 		if certKey.KeyPath == nil {
-			log.Debug().Msgf("key path is nil")
+			rhsmClient.getLogger().Debug().Msgf("key path is nil")
 			continue
 		}
 		if _, err := os.Stat(*certKey.KeyPath); err != nil {
-			log.Debug().Msgf("key path %s does not exist: %s", *certKey.KeyPath, err)
+			rhsmClient.getLogger().Debug().Msgf("key path %s does not exist: %s", *certKey.KeyPath, err)
 			continue
 		}
 		if _, err := os.Stat(*certKey.CertPath); err != nil {
-			log.Debug().Msgf("cert path %s does not exist: %s", *certKey.CertPath, err)
+			rhsmClient.getLogger().Debug().Msgf("cert path %s does not exist: %s", *certKey.CertPath, err)
 			continue
 		}
-		if certKey.CertPath != nil && certKey.KeyPath != nil {
-			certPath = certKey.CertPath
-			keyPath = certKey.KeyPath
-			break
-		}
+		return certKey.CertPath, certKey.KeyPath, nil
+	}
+
+	return nil, nil, nil
+}
+
+// getEntitlementCertAuthConnection returns the entitlement cert auth connection if it exists
+// and it tries to create it if it doesn't exist
+func (rhsmClient *RHSMClient) getEntitlementCertAuthConnection() (*RHSMConnection, error) {
+	rhsmClient.connectionMutex.Lock()
+	defer rhsmClient.connectionMutex.Unlock()
+
+	if rhsmClient.entitlementCertAuthConnection != nil {
+		return rhsmClient.entitlementCertAuthConnection, nil
+	}
+
+	cdnHost, cdnPort, cdnPath, err := parseBaseURL(rhsmClient.getLogger(), rhsmClient.RHSMConf.RHSM.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	certPath, keyPath, err := rhsmClient.findEntitlementCertKey()
+	if err != nil {
+		return nil, err
 	}
 
 	if keyPath == nil || certPath == nil {
 		return nil, fmt.Errorf("no entitlement certificate and key found")
 	}
 
-	err = rhsmClient.createEntitlementCertAuthConnection(
+	connection, err := rhsmClient.newEntitlementCertAuthConnection(
 		&cdnHost,
 		&cdnPort,
 		&cdnPath,
@@ -453,13 +569,13 @@ func (rhsmClient *RHSMClient) getEntitlementCertAuthConnection() (*RHSMConnectio
 	if err != nil {
 		return nil, fmt.Errorf("entitlement cert auth connection not initialized: %s", err)
 	}
+	rhsmClient.entitlementCertAuthConnection = connection
 
-	return rhsmClient.entitlementCertAuthConnection, nil
+	return connection, nil
 }
 
 // createEntitlementCertAuthConnection tries to create a connection using entitlement cert/key
-// for authentication. It is typically used when we want to communicate with CDN.
-// E.g. when we want to get information about release.
+// for authentication, and it replaces existing entitlement cert auth connection.
 func (rhsmClient *RHSMClient) createEntitlementCertAuthConnection(
 	hostname *string,
 	port *string,
@@ -467,20 +583,149 @@ func (rhsmClient *RHSMClient) createEntitlementCertAuthConnection(
 	certFilePath *string,
 	keyFilePath *string,
 ) error {
+	rhsmClient.connectionMutex.Lock()
+	defer rhsmClient.connectionMutex.Unlock()
+
+	connection, err := rhsmClient.newEntitlementCertAuthConnection(hostname, port, prefix, certFilePath, keyFilePath)
+	if err != nil {
+		return err
+	}
+	rhsmClient.entitlementCertAuthConnection = connection
+
+	return nil
+}
+
+// newEntitlementCertAuthConnection tries to create new connection using entitlement cert/key
+// for authentication. It is typically used when we want to communicate with CDN.
+// E.g. when we want to get information about release.
+func (rhsmClient *RHSMClient) newEntitlementCertAuthConnection(
+	hostname *string,
+	port *string,
+	prefix *string,
+	certFilePath *string,
+	keyFilePath *string,
+) (*RHSMConnection, error) {
 	client, err := rhsmClient.createHTTPsClient(certFilePath, keyFilePath)
 
 	if err != nil {
-		return fmt.Errorf("unable to create entitlement cert auth connection: %v", err)
+		return nil, fmt.Errorf("unable to create entitlement cert auth connection: %v", err)
 	}
 
-	rhsmClient.entitlementCertAuthConnection = &RHSMConnection{
+	return &RHSMConnection{
 		AuthType:       EntitlementCertAuth,
 		Client:         client,
 		ServerHostname: hostname,
 		ServerPort:     port,
 		ServerPrefix:   prefix,
+		RetryPolicy:    rhsmClient.getRetryPolicy(),
+		logger:         rhsmClient.getLogger(),
+	}, nil
+}
+
+// InvalidateConnections drops all connections of RHSMClient. Connections are
+// created again from current configuration and installed certificates, when
+// they are used next time. It is useful, when certificates or configuration
+// were changed by another process. It is safe to call it concurrently with
+// other methods of RHSMClient. Requests already in progress are not affected.
+func (rhsmClient *RHSMClient) InvalidateConnections() {
+	rhsmClient.connectionMutex.Lock()
+	defer rhsmClient.connectionMutex.Unlock()
+
+	rhsmClient.noAuthConnection = nil
+	rhsmClient.consumerCertAuthConnection = nil
+	rhsmClient.entitlementCertAuthConnection = nil
+}
+
+// invalidateCertAuthConnections drops connections using consumer and entitlement
+// cert/key for authentication. It is used, when installed certificates were removed.
+func (rhsmClient *RHSMClient) invalidateCertAuthConnections() {
+	rhsmClient.connectionMutex.Lock()
+	defer rhsmClient.connectionMutex.Unlock()
+
+	rhsmClient.consumerCertAuthConnection = nil
+	rhsmClient.entitlementCertAuthConnection = nil
+}
+
+// invalidateEntitlementCertAuthConnection drops connection using entitlement cert/key
+// for authentication. It is used, when installed entitlement certificates were changed.
+func (rhsmClient *RHSMClient) invalidateEntitlementCertAuthConnection() {
+	rhsmClient.connectionMutex.Lock()
+	defer rhsmClient.connectionMutex.Unlock()
+
+	rhsmClient.entitlementCertAuthConnection = nil
+}
+
+// ReloadConnections tries to create all connections again from current configuration
+// and installed certificates. The no-auth connection is always created. Connections
+// using consumer and entitlement cert/key are created only, when corresponding
+// certificates and keys are installed. Existing connections are replaced only,
+// when all connections were successfully created. It is safe to call it concurrently
+// with other methods of RHSMClient. Requests already in progress are not affected.
+func (rhsmClient *RHSMClient) ReloadConnections() error {
+	rhsmClient.connectionMutex.Lock()
+	defer rhsmClient.connectionMutex.Unlock()
+
+	rhsmConf := rhsmClient.RHSMConf
+
+	// Try to create connection without authentication
+	// Note: It doesn't do any TCP/TLS handshake ATM
+	noAuthConnection, err := rhsmClient.newNoAuthConnection(
+		&rhsmConf.Server.Hostname,
+		&rhsmConf.Server.Port,
+		&rhsmConf.Server.Prefix,
+	)
+	if err != nil {
+		return err
+	}
+
+	// When the consumer key and the certificate exist, then it is possible
+	// to create a connection using consumer cert/key for authentication
+	var consumerCertAuthConnection *RHSMConnection
+	consumerCertFilePath := filepath.Join(rhsmConf.RHSM.ConsumerCertDir, "cert.pem")
+	if _, err := os.Stat(consumerCertFilePath); err == nil {
+		consumerKeyFilePath := filepath.Join(rhsmConf.RHSM.ConsumerCertDir, "key.pem")
+		if _, err := os.Stat(consumerKeyFilePath); err == nil {
+			consumerCertAuthConnection, err = rhsmClient.newCertAuthConnection(
+				&rhsmConf.Server.Hostname,
+				&rhsmConf.Server.Port,
+				&rhsmConf.Server.Prefix,
+				&consumerCertFilePath,
+				&consumerKeyFilePath,
+			)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
+	// When the entitlement key and the certificate exist, then it is possible
+	// to create a connection using entitlement cert/key for authentication to CDN
+	var entitlementCertAuthConnection *RHSMConnection
+	certPath, keyPath, err := rhsmClient.findEntitlementCertKey()
+	if err != nil {
+		return err
+	}
+	if certPath != nil && keyPath != nil {
+		cdnHost, cdnPort, cdnPath, err := parseBaseURL(rhsmClient.getLogger(), rhsmConf.RHSM.BaseURL)
+		if err != nil {
+			return err
+		}
+		entitlementCertAuthConnection, err = rhsmClient.newEntitlementCertAuthConnection(
+			&cdnHost,
+			&cdnPort,
+			&cdnPath,
+			certPath,
+			keyPath,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	rhsmClient.noAuthConnection = noAuthConnection
+	rhsmClient.consumerCertAuthConnection = consumerCertAuthConnection
+	rhsmClient.entitlementCertAuthConnection = entitlementCertAuthConnection
+
 	return nil
 }
 
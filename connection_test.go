@@ -1,10 +1,14 @@
 package rhsm2
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -530,3 +534,357 @@ func TestGetNoAuthConnection(t *testing.T) {
 		t.Fatalf("connection http client should not be nil")
 	}
 }
+
+// TestCreateHTTPsClientTimeout test that server_timeout from rhsm.conf
+// is used as the timeout of http.Client
+func TestCreateHTTPsClientTimeout(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name            string
+		serverTimeout   int64
+		expectedTimeout time.Duration
+	}{
+		{
+			name:            "default server_timeout",
+			serverTimeout:   180,
+			expectedTimeout: 180 * time.Second,
+		},
+		{
+			name:            "custom server_timeout",
+			serverTimeout:   5,
+			expectedTimeout: 5 * time.Second,
+		},
+		{
+			name:            "zero server_timeout means no timeout",
+			serverTimeout:   0,
+			expectedTimeout: 0,
+		},
+		{
+			name:            "negative server_timeout means no timeout",
+			serverTimeout:   -1,
+			expectedTimeout: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tempDirFilePath := t.TempDir()
+
+			testingFiles, err := setupTestingFileSystem(
+				tempDirFilePath, false, false, false, false, false)
+			if err != nil {
+				t.Fatalf("unable to setup testing environment: %s", err)
+			}
+
+			rhsmClient, err := setupTestingRHSMClient(testingFiles, nil, nil)
+			if err != nil {
+				t.Fatalf("unable to setup testing rhsm client: %s", err)
+			}
+			rhsmClient.RHSMConf.Server.Timeout = tt.serverTimeout
+
+			client, err := rhsmClient.createHTTPsClient(nil, nil)
+			if err != nil {
+				t.Fatalf("unable to create http client: %s", err)
+			}
+
+			if client.Timeout != tt.expectedTimeout {
+				t.Errorf("expected timeout: %v, got: %v", tt.expectedTimeout, client.Timeout)
+			}
+		})
+	}
+}
+
+// newTestingHangingServer creates testing server, which never responds.
+// The handler returns, when the client closes the connection or the test ends
+func newTestingHangingServer(t *testing.T, counter *int32) (*httptest.Server, func()) {
+	release := make(chan struct{})
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(counter, 1)
+			select {
+			case <-req.Context().Done():
+			case <-release:
+			}
+		}))
+	return server, func() {
+		close(release)
+		server.Close()
+	}
+}
+
+// TestRequestServerTimeout test that request to hanging server is canceled,
+// when server_timeout is exceeded
+func TestRequestServerTimeout(t *testing.T) {
+	t.Parallel()
+	var handlerCounter int32
+
+	server, closeServer := newTestingHangingServer(t, &handlerCounter)
+	defer closeServer()
+
+	tempDirFilePath := t.TempDir()
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, false, false, false, false, false)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	// Do not use mocked connection, because it does not use server_timeout
+	rhsmClient.noAuthConnection = nil
+	rhsmClient.RHSMConf.Server.Insecure = true
+	rhsmClient.RHSMConf.Server.Timeout = 1
+	// Test only one attempt
+	rhsmClient.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+
+	start := time.Now()
+	_, err = rhsmClient.GetServerStatus(nil)
+	if err == nil {
+		t.Fatalf("getting server status from hanging server did not fail")
+	}
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("request was not canceled after server_timeout, it took: %v", elapsed)
+	}
+
+	if atomic.LoadInt32(&handlerCounter) != 1 {
+		t.Errorf("expected one request, got: %d", atomic.LoadInt32(&handlerCounter))
+	}
+}
+
+// TestGetServerStatusWithContext test that request is canceled, when
+// the context is canceled or its deadline is exceeded
+func TestGetServerStatusWithContext(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name                   string
+		cancelBeforeRequest    bool
+		expectedHandlerCounter int32
+	}{
+		{
+			name:                   "deadline exceeded during request",
+			cancelBeforeRequest:    false,
+			expectedHandlerCounter: 1,
+		},
+		{
+			name:                   "context canceled before request",
+			cancelBeforeRequest:    true,
+			expectedHandlerCounter: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var handlerCounter int32
+
+			server, closeServer := newTestingHangingServer(t, &handlerCounter)
+			defer closeServer()
+
+			tempDirFilePath := t.TempDir()
+			testingFiles, err := setupTestingFileSystem(
+				tempDirFilePath, false, false, false, false, false)
+			if err != nil {
+				t.Fatalf("unable to setup testing environment: %s", err)
+			}
+
+			rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+			if err != nil {
+				t.Fatalf("unable to setup testing rhsm client: %s", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			if tt.cancelBeforeRequest {
+				cancel()
+			}
+
+			_, err = rhsmClient.GetServerStatusWithContext(ctx, nil)
+			if err == nil {
+				t.Fatalf("getting server status with canceled context did not fail")
+			}
+
+			if atomic.LoadInt32(&handlerCounter) != tt.expectedHandlerCounter {
+				t.Errorf("expected %d request(s), got: %d",
+					tt.expectedHandlerCounter, atomic.LoadInt32(&handlerCounter))
+			}
+		})
+	}
+}
+
+// newTestingConsumerServer creates testing server responding to requests
+// used for getting information about registered consumer
+func newTestingConsumerServer(t *testing.T) *httptest.Server {
+	return httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			consumerPath := "/consumers/5e9745d5-624d-4af1-916e-2c17df4eb4e8"
+			var response string
+			switch req.URL.Path {
+			case "/status":
+				response = serverStatusResponse
+			case consumerPath:
+				response = testConsumerResponse
+			case consumerPath + "/owner":
+				response = orgResponse
+			case consumerPath + "/release":
+				response = `{"releaseVer": "9"}`
+			default:
+				t.Errorf("unexpected REST API call: %s %s", req.Method, req.URL.String())
+				rw.WriteHeader(404)
+				return
+			}
+			rw.WriteHeader(200)
+			_, _ = rw.Write([]byte(response))
+		}))
+}
+
+// TestInvalidateReloadConnections test that connections are dropped
+// and created again from installed certificates
+func TestInvalidateReloadConnections(t *testing.T) {
+	t.Parallel()
+	server := newTestingConsumerServer(t)
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, false, true, false, false, false)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+	// Connections created by RHSMClient have to trust testing server
+	rhsmClient.transport = server.Client().Transport
+
+	oldConnection, err := rhsmClient.getCertAuthConnection()
+	if err != nil {
+		t.Fatalf("unable to get consumer cert auth connection: %s", err)
+	}
+
+	rhsmClient.InvalidateConnections()
+	if rhsmClient.noAuthConnection != nil ||
+		rhsmClient.consumerCertAuthConnection != nil ||
+		rhsmClient.entitlementCertAuthConnection != nil {
+		t.Fatalf("connections were not invalidated")
+	}
+
+	// Connection is created again, when it is used
+	_, err = rhsmClient.GetConsumer(nil)
+	if err != nil {
+		t.Fatalf("unable to get consumer after invalidation of connections: %s", err)
+	}
+	newConnection, err := rhsmClient.getCertAuthConnection()
+	if err != nil {
+		t.Fatalf("unable to get consumer cert auth connection: %s", err)
+	}
+	if newConnection == oldConnection {
+		t.Fatalf("consumer cert auth connection was not created again")
+	}
+
+	err = rhsmClient.ReloadConnections()
+	if err != nil {
+		t.Fatalf("unable to reload connections: %s", err)
+	}
+	if rhsmClient.noAuthConnection == nil {
+		t.Fatalf("no-auth connection was not created")
+	}
+	if rhsmClient.consumerCertAuthConnection == nil || rhsmClient.consumerCertAuthConnection == newConnection {
+		t.Fatalf("consumer cert auth connection was not reloaded")
+	}
+	if rhsmClient.entitlementCertAuthConnection != nil {
+		t.Fatalf("entitlement cert auth connection created without entitlement certificate")
+	}
+
+	// Connections using removed certificates are dropped
+	err = rhsmClient.Clean()
+	if err != nil {
+		t.Fatalf("unable to clean installed files: %s", err)
+	}
+	if rhsmClient.consumerCertAuthConnection != nil {
+		t.Fatalf("consumer cert auth connection was not dropped after removing consumer certificate")
+	}
+	_, err = rhsmClient.getCertAuthConnection()
+	if err == nil {
+		t.Fatalf("consumer cert auth connection created without consumer certificate")
+	}
+}
+
+// TestConnectionsConcurrentUse test that public API could be used from
+// more goroutines, while connections are invalidated, reloaded and modified.
+// This test is useful mostly with race detector (go test -race).
+func TestConnectionsConcurrentUse(t *testing.T) {
+	t.Parallel()
+	server := newTestingConsumerServer(t)
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, false, true, false, false, false)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+	rhsmClient.transport = server.Client().Transport
+
+	calls := []func() error{
+		func() error {
+			_, err := rhsmClient.GetServerStatus(nil)
+			return err
+		},
+		func() error {
+			_, err := rhsmClient.GetConsumer(nil)
+			return err
+		},
+		func() error {
+			_, err := rhsmClient.GetOrg(nil)
+			return err
+		},
+		func() error {
+			_, err := rhsmClient.GetReleaseFromServer(nil)
+			return err
+		},
+		func() error {
+			rhsmClient.InvalidateConnections()
+			return nil
+		},
+		func() error {
+			return rhsmClient.ReloadConnections()
+		},
+		func() error {
+			rhsmClient.SetRetryPolicy(testingRetryPolicy(2))
+			return nil
+		},
+	}
+
+	const iterations = 10
+	var waitGroup sync.WaitGroup
+	errs := make(chan error, len(calls)*iterations)
+	for i := 0; i < iterations; i++ {
+		for _, call := range calls {
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+				if err := call(); err != nil {
+					errs <- err
+				}
+			}()
+		}
+	}
+	waitGroup.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("concurrent call failed: %s", err)
+	}
+}
@@ -1,6 +1,7 @@
 package rhsm2
 
 import (
+	"context"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
@@ -80,6 +81,12 @@ type ConsumerData struct {
 // The consumer UUID is read from the installed consumer certificate and
 // consumer cert auth is used for the request.
 func (rhsmClient *RHSMClient) GetConsumer(metadata *RequestMetadata) (*ConsumerData, error) {
+	return rhsmClient.GetConsumerWithContext(context.Background(), metadata)
+}
+
+// GetConsumerWithContext is like GetConsumer, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) GetConsumerWithContext(ctx context.Context, metadata *RequestMetadata) (*ConsumerData, error) {
 	uuid, err := rhsmClient.GetConsumerUUID()
 
 	if err != nil {
@@ -93,6 +100,7 @@ func (rhsmClient *RHSMClient) GetConsumer(metadata *RequestMetadata) (*ConsumerD
 		return nil, fmt.Errorf("unable to get consumer cert auth connection: %v", err)
 	}
 	res, err := connection.request(
+		ctx,
 		rhsmClient.UserAgent,
 		http.MethodGet,
 		"consumers/"+*uuid,
@@ -107,7 +115,7 @@ func (rhsmClient *RHSMClient) GetConsumer(metadata *RequestMetadata) (*ConsumerD
 	}
 
 	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("unable to get consumer: %d", res.StatusCode)
+		return nil, fmt.Errorf("unable to get consumer: %w", newCandlepinError(res))
 	}
 
 	resBody, err := getResponseBody(res)
@@ -3,6 +3,7 @@ package rhsm2
 import (
 	"bytes"
 	"compress/zlib"
+	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
@@ -10,16 +11,28 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 	"gopkg.in/ini.v1"
 )
 
 const DefaultRepoFilePath = "/etc/yum.repos.d/redhat.repo"
 
+// yumContentType is the type of content, which is written to redhat.repo
+const yumContentType = "yum"
+
+// compatibleArches maps architecture of host to other architectures of content,
+// which can be used on the host
+var compatibleArches = map[string][]string{
+	"x86_64": {"i386", "i486", "i586", "i686"},
+	"i686":   {"i386", "i486", "i586"},
+}
+
 // Content is a structure containing information about one content.
 // This structure is unmarshalled from the entitlement certificate
 type Content struct {
@@ -46,48 +59,113 @@ type EngineeringProduct struct {
 	Content       []Content     `json:"content"`
 }
 
+// EntitlementSubscription is structure containing information about subscription
+// (decoded from entitlement certificate)
+type EntitlementSubscription struct {
+	Sku  string `json:"sku"`
+	Name string `json:"name"`
+}
+
+// EntitlementOrder is structure containing information about order
+// (decoded from entitlement certificate)
+type EntitlementOrder struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
 // EntitlementContentJSON is structure containing information about content (decoded from entitlement certificate)
 type EntitlementContentJSON struct {
-	Consumer     string `json:"consumer"`
-	Subscription struct {
-		Sku  string `json:"sku"`
-		Name string `json:"name"`
-	} `json:"subscription"`
-	Order struct {
-		Start time.Time `json:"start"`
-		End   time.Time `json:"end"`
-	} `json:"order"`
-	Products []EngineeringProduct `json:"products"`
-	Pool     struct {
+	Consumer     string                  `json:"consumer"`
+	Subscription EntitlementSubscription `json:"subscription"`
+	Order        EntitlementOrder        `json:"order"`
+	Products     []EngineeringProduct    `json:"products"`
+	Pool         struct {
 	} `json:"pool"`
 }
 
-// writeRepoFile tries to write map of products to repo file
-func (rhsmClient *RHSMClient) writeRepoFile(
+// isArchSupported returns true, when content with given list of architectures can be
+// used on the host with given architecture. Content without any architecture can
+// be used on any host.
+func isArchSupported(contentArches []string, hostArch string) bool {
+	if len(contentArches) == 0 {
+		return true
+	}
+	for _, arch := range contentArches {
+		if arch == hostArch || arch == "noarch" || arch == "ALL" {
+			return true
+		}
+		if slices.Contains(compatibleArches[hostArch], arch) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUsableRepoContent returns true, when the content is yum content supported
+// by the architecture of the host. Other content is never written to redhat.repo.
+func isUsableRepoContent(content *Content, hostArch string) bool {
+	// Empty type is used by old entitlement certificates
+	return (content.Type == "" || content.Type == yumContentType) && isArchSupported(content.Arches, hostArch)
+}
+
+// skipContentReason returns the reason, why the content should not be written to
+// redhat.repo. When the content should be written, then empty string is returned.
+func skipContentReason(logger *zerolog.Logger, content *Content, contentTags []string, hostArch string) string {
+	// Empty type is used by old entitlement certificates
+	if content.Type != "" && content.Type != yumContentType {
+		return "content type is not " + yumContentType
+	}
+	if !isAnyRequiredTagProvided(logger, content.RequiredTags, contentTags) {
+		return "required tags are not provided by installed products"
+	}
+	if !isArchSupported(content.Arches, hostArch) {
+		return "architecture " + hostArch + " is not supported"
+	}
+	return ""
+}
+
+// contentBaseURL tries to create base URL of content from baseurl in rhsm.conf and path of content
+func (rhsmClient *RHSMClient) contentBaseURL(content *Content) (string, error) {
+	baseURL, err := url.Parse(rhsmClient.RHSMConf.RHSM.BaseURL + content.Path)
+	if err != nil {
+		return "", fmt.Errorf("unable to create parse base URL: %s", err)
+	}
+	return baseURL.String(), nil
+}
+
+// createRepoFile tries to create repo file from map of products
+func (rhsmClient *RHSMClient) createRepoFile(
 	productsMap map[int64][]EngineeringProduct,
-) error {
+) (*ini.File, error) {
 	file := ini.Empty()
 
-	ini.PrettyFormat = false
+	contentTags := createListOfContentTags(rhsmClient.getInstalledProducts())
+	hostArch := hostArchitecture()
+	skippedContent := make(map[string][]string)
 
 	for serial, products := range productsMap {
 		for _, product := range products {
 			for _, content := range product.Content {
+				if reason := skipContentReason(rhsmClient.getLogger(), &content, contentTags, hostArch); reason != "" {
+					skippedContent[reason] = append(skippedContent[reason], content.Label)
+					continue
+				}
+
 				// Label of the section. Something like [rhel-9-for-x86_64-baseos-rpms]
 				section, err := file.NewSection(content.Label)
 				if err != nil {
-					return fmt.Errorf("unable to add section: %s: %s", content.Label, err)
+					return nil, fmt.Errorf("unable to add section: %s: %s", content.Label, err)
 				}
 
 				// name
 				_, _ = section.NewKey("name", content.Name)
 
 				// baseurl
-				baseURL, err := url.Parse(rhsmClient.RHSMConf.RHSM.BaseURL + content.Path)
+				baseURL, err := rhsmClient.contentBaseURL(&content)
 				if err != nil {
-					return fmt.Errorf("unable to create parse base URL: %s", err)
+					return nil, err
 				}
-				_, _ = section.NewKey("baseurl", baseURL.String())
+				_, _ = section.NewKey("baseurl", baseURL)
 
 				// enabled
 				var enabled string
@@ -116,8 +194,8 @@ func (rhsmClient *RHSMClient) writeRepoFile(
 				_, _ = section.NewKey("sslcacert", rhsmClient.RHSMConf.RHSM.RepoCACertificate)
 				keyPath := rhsmClient.entKeyPath(serial)
 				certPath := rhsmClient.entCertPath(serial)
-				_, _ = section.NewKey("sslclientkey", *keyPath)
-				_, _ = section.NewKey("sslclientcert", *certPath)
+				_, _ = section.NewKey("sslclientkey", rhsmClient.RHSMConf.pathInRootDir(*keyPath))
+				_, _ = section.NewKey("sslclientcert", rhsmClient.RHSMConf.pathInRootDir(*certPath))
 
 				// metadata
 				_, _ = section.NewKey("metadata_expire", strconv.Itoa(content.MetadataExpire))
@@ -137,12 +215,71 @@ func (rhsmClient *RHSMClient) writeRepoFile(
 		}
 	}
 
-	err := file.SaveTo(rhsmClient.RHSMConf.yumRepoFilePath)
+	for reason, labels := range skippedContent {
+		sort.Strings(labels)
+		rhsmClient.getLogger().Debug().Msgf("skipped %d repositories (%s): %s",
+			len(labels), reason, strings.Join(labels, ", "))
+	}
+
+	return file, nil
+}
+
+// writeRepoFile tries to write map of products to repo file. Generated repositories
+// are merged with existing repo file. Thus, keys modified or added by user are
+// preserved. The report of changes is returned.
+func (rhsmClient *RHSMClient) writeRepoFile(
+	productsMap map[int64][]EngineeringProduct,
+) (*RepoFileDiff, error) {
+	ini.PrettyFormat = false
+
+	generated, err := rhsmClient.createRepoFile(productsMap)
+	if err != nil {
+		return nil, err
+	}
+
+	// dnf4 does not support repo override files. Thus, content overrides have to
+	// be part of generated repositories, and they win over modifications done by user
+	var overriddenKeys map[string]map[string]string
+	if rhsmClient.getDnfVersion() != DnfVersion5 {
+		contentOverrides := rhsmClient.readContentOverridesCache()
+		applyContentOverridesToRepoFile(generated, contentOverrides)
+		overriddenKeys = createMapFromContentOverrides(contentOverrides)
+	}
+
+	existing, err := readExistingRepoFile(rhsmClient.RHSMConf.yumRepoFilePath)
+	if err != nil {
+		rhsmClient.getLogger().Warn().Msgf("%s, generating new repo file", err)
+		existing = ini.Empty()
+	}
+
+	diff := mergeRepoFile(existing, generated, rhsmClient.readRepoFileCache(), overriddenKeys)
+
+	var buffer bytes.Buffer
+	_, err = existing.WriteTo(&buffer)
 	if err != nil {
-		return fmt.Errorf("unable to write to %s: %s",
+		return nil, fmt.Errorf("unable to serialize repo file: %s", err)
+	}
+
+	err = writeFileAtomically(
+		rhsmClient.getLogger(),
+		rhsmClient.RHSMConf.yumRepoFilePath,
+		buffer.Bytes(),
+		rhsmClient.getFilePermissions().Config,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write to %s: %s",
 			rhsmClient.RHSMConf.yumRepoFilePath, err)
 	}
-	return nil
+
+	err = rhsmClient.writeRepoFileCache(iniFileToMap(generated))
+	if err != nil {
+		rhsmClient.getLogger().Warn().Msgf("unable to write repo file cache: %s", err)
+	}
+
+	rhsmClient.getLogger().Debug().Msgf("repo file %s merged, added: %v, removed: %v, modified: %d, preserved: %d",
+		rhsmClient.RHSMConf.yumRepoFilePath, diff.Added, diff.Removed, len(diff.Modified), len(diff.Preserved))
+
+	return diff, nil
 }
 
 // getEngineeringProducts tries to get a list of engineering products from entitlement certificates
@@ -154,6 +291,7 @@ func (rhsmClient *RHSMClient) getEngineeringProducts() (map[int64][]EngineeringP
 	}
 
 	var engineeringProductsMap = make(map[int64][]EngineeringProduct)
+	caCerts := rhsmClient.loadCACertificates()
 
 	for _, file := range entCertsFilePaths {
 		fileName := file.Name()
@@ -166,14 +304,16 @@ func (rhsmClient *RHSMClient) getEngineeringProducts() (map[int64][]EngineeringP
 
 		// Other pem files should be entitlement cert file
 		if strings.HasSuffix(filePath, ".pem") {
-			engineeringProduct, err := getContentFromEntCertFile(&filePath)
-			if err != nil {
-				log.Debug().Msgf("skipping reading content from %s, %s", filePath, err)
+			engineeringProduct, err := getContentFromEntCertFile(&filePath, caCerts)
+			if IsInvalidEntitlementSignature(err) {
+				rhsmClient.getLogger().Error().Msgf("skipping reading content from %s, %s", filePath, err)
+			} else if err != nil {
+				rhsmClient.getLogger().Debug().Msgf("skipping reading content from %s, %s", filePath, err)
 			}
 			serialNumberStr := strings.TrimSuffix(fileName, ".pem")
 			serialNumber, err := strconv.ParseInt(serialNumberStr, 10, 64)
 			if err != nil {
-				log.Debug().Msgf("unable to convert %s to int: %s", fileName, err)
+				rhsmClient.getLogger().Debug().Msgf("unable to convert %s to int: %s", fileName, err)
 				continue
 			}
 			engineeringProductsMap[serialNumber] = engineeringProduct
@@ -185,24 +325,24 @@ func (rhsmClient *RHSMClient) getEngineeringProducts() (map[int64][]EngineeringP
 
 // generateRepoFileFromInstalledEntitlementCerts tries to generate redhat.repo file
 // from installed entitlement certificate(s) and content overrides
-func (rhsmClient *RHSMClient) generateRepoFileFromInstalledEntitlementCerts() error {
+func (rhsmClient *RHSMClient) generateRepoFileFromInstalledEntitlementCerts() (*RepoFileDiff, error) {
 	engineeringProductsMap, err := rhsmClient.getEngineeringProducts()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	return rhsmClient.writeRepoFile(engineeringProductsMap)
 }
 
 // getContentFromEntCertFile tries to load entitlement certificate from given file
-func getContentFromEntCertFile(filePath *string) ([]EngineeringProduct, error) {
+func getContentFromEntCertFile(filePath *string, caCerts []*x509.Certificate) ([]EngineeringProduct, error) {
 	entCertFileContent, err := os.ReadFile(*filePath)
 	if err != nil {
 		return nil, fmt.Errorf("unable to read entitlement certificate: %s, %s", *filePath, err)
 	}
 
 	content := string(entCertFileContent)
-	engineeringProducts, err := getContentFromEntCert(&content)
+	engineeringProducts, err := getContentFromEntCert(&content, caCerts)
 	if err != nil {
 		return nil, err
 	}
@@ -210,11 +350,33 @@ func getContentFromEntCertFile(filePath *string) ([]EngineeringProduct, error) {
 	return engineeringProducts, nil
 }
 
-// getContentFromEntCert tries to get content definition from content of entitlement certificate
-func getContentFromEntCert(entCertContent *string) ([]EngineeringProduct, error) {
+// getContentFromEntCert tries to get content definition from content of entitlement certificate.
+// Each block "ENTITLEMENT DATA" has to be followed by block "RSA SIGNATURE" and the signature
+// has to be valid. Otherwise, EntitlementSignatureError is returned and no content is used.
+func getContentFromEntCert(entCertContent *string, caCerts []*x509.Certificate) ([]EngineeringProduct, error) {
+	entitlementContents, err := getEntitlementContentsFromEntCert(entCertContent, caCerts)
+	if err != nil {
+		return nil, err
+	}
+
+	var engineeringProducts []EngineeringProduct
+	for _, entitlementContent := range entitlementContents {
+		engineeringProducts = append(engineeringProducts, entitlementContent.Products...)
+	}
+
+	return engineeringProducts, nil
+}
+
+// getEntitlementContentsFromEntCert tries to decode all blocks "ENTITLEMENT DATA" from content
+// of entitlement certificate. Signatures of all blocks are verified.
+func getEntitlementContentsFromEntCert(
+	entCertContent *string,
+	caCerts []*x509.Certificate,
+) ([]EntitlementContentJSON, error) {
 	data := []byte(*entCertContent)
 	blockEntitlementDataFound := false
-	var engineeringProducts []EngineeringProduct
+	var entitlementContents []EntitlementContentJSON
+	var entitlementData []byte
 
 	// Go through the entitlement certificate and try to get block "ENTITLEMENT DATA"
 	for data != nil {
@@ -222,29 +384,28 @@ func getContentFromEntCert(entCertContent *string) ([]EngineeringProduct, error)
 		if block == nil {
 			break
 		} else {
+			switch block.Type {
 			// Content is saved in this block type
-			if block.Type == "ENTITLEMENT DATA" {
-				blockEntitlementDataFound = true
-				// The "block.Bytes" is already base64 decoded. We can try to un-compress.
-				b := bytes.NewReader(block.Bytes)
-				zReader, err := zlib.NewReader(b)
-				if err != nil {
-					return nil, fmt.Errorf("unable to create new zlib readed for ENTITLEMENT DATA: %s", err)
+			case "ENTITLEMENT DATA":
+				if entitlementData != nil {
+					return nil, &EntitlementSignatureError{Reason: "no block \"RSA SIGNATURE\" found"}
 				}
-				p, err := io.ReadAll(zReader)
-				if err != nil {
-					return nil, fmt.Errorf("unable to uncompress ENTITLEMENT DATA: %s", err)
-				}
-				_ = zReader.Close()
-
-				// Try to unmarshal string to the list of repo definitions
-				var entitlementContents EntitlementContentJSON
-				err = json.Unmarshal(p, &entitlementContents)
-				if err != nil {
-					return nil, err
+				blockEntitlementDataFound = true
+				entitlementData = block.Bytes
+			// Signature of previous block "ENTITLEMENT DATA"
+			case "RSA SIGNATURE":
+				if entitlementData != nil {
+					err := verifyEntitlementDataSignature(entitlementData, block.Bytes, caCerts)
+					if err != nil {
+						return nil, err
+					}
+					entitlementContent, err := decodeEntitlementData(entitlementData)
+					if err != nil {
+						return nil, err
+					}
+					entitlementContents = append(entitlementContents, *entitlementContent)
+					entitlementData = nil
 				}
-
-				engineeringProducts = append(engineeringProducts, entitlementContents.Products...)
 			}
 		}
 		data = rest
@@ -254,5 +415,33 @@ func getContentFromEntCert(entCertContent *string) ([]EngineeringProduct, error)
 		return nil, fmt.Errorf("unable to get content, because no block \"ENTITLEMENT DATA\" found")
 	}
 
-	return engineeringProducts, nil
+	if entitlementData != nil {
+		return nil, &EntitlementSignatureError{Reason: "no block \"RSA SIGNATURE\" found"}
+	}
+
+	return entitlementContents, nil
+}
+
+// decodeEntitlementData tries to uncompress and unmarshal the content of block "ENTITLEMENT DATA"
+func decodeEntitlementData(entitlementData []byte) (*EntitlementContentJSON, error) {
+	// The entitlement data is already base64 decoded. We can try to un-compress.
+	b := bytes.NewReader(entitlementData)
+	zReader, err := zlib.NewReader(b)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create new zlib readed for ENTITLEMENT DATA: %s", err)
+	}
+	p, err := io.ReadAll(zReader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to uncompress ENTITLEMENT DATA: %s", err)
+	}
+	_ = zReader.Close()
+
+	// Try to unmarshal string to the list of repo definitions
+	var entitlementContents EntitlementContentJSON
+	err = json.Unmarshal(p, &entitlementContents)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entitlementContents, nil
 }
@@ -0,0 +1,331 @@
+package rhsm2
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/ini.v1"
+)
+
+// DefaultContainerCertsDirPath is the directory with certificates used by
+// container tools (podman, buildah, skopeo) for connecting to registries
+const DefaultContainerCertsDirPath = "/etc/containers/certs.d"
+
+// DefaultOstreeRemotesFilePath is the file with configuration of ostree remotes
+// generated from entitlement certificates
+const DefaultOstreeRemotesFilePath = "/etc/ostree/remotes.d/redhat.conf"
+
+// containerCertFileRegexp matches file names of entitlement certificates
+// and keys installed to the directory of container registry
+var containerCertFileRegexp = regexp.MustCompile(`^[0-9]+\.(cert|key)$`)
+
+// containerCACertFileName is the file name of CA certificate installed
+// to the directory of container registry
+const containerCACertFileName = "redhat-uep.crt"
+
+// defaultRegistryHostnames is the list of registries, which use
+// entitlement certificates for authentication
+var defaultRegistryHostnames = []string{
+	"registry.redhat.io",
+	"registry.access.redhat.com",
+	"cdn.redhat.com",
+	"access.redhat.com",
+}
+
+// EntitledContent is the content provided by one installed entitlement
+// certificate together with file paths of the certificate and its key
+type EntitledContent struct {
+	Content
+	// CertFilePath is the file path of entitlement certificate providing the content
+	CertFilePath string
+	// KeyFilePath is the file path of entitlement key
+	KeyFilePath string
+}
+
+// ContentHandler is interface of handler of one type of content provided by
+// entitlement certificates. Content of yum type is always written to redhat.repo
+// by RHSMClient. Other types of content (e.g. containerimage or ostree) are
+// handled by content handlers.
+type ContentHandler interface {
+	// Type returns the type of content handled by the handler. The type
+	// is compared with Content.Type case-insensitively.
+	Type() string
+	// Update tries to update configuration of the system according to the list
+	// of all content of given type. When the list is empty, then the handler
+	// should remove all configuration created by the handler.
+	Update(rhsmClient *RHSMClient, contents []EntitledContent) error
+}
+
+// defaultContentHandlers returns the list of content handlers used by default
+func defaultContentHandlers() []ContentHandler {
+	return []ContentHandler{
+		&ContainerImageContentHandler{},
+		&OstreeContentHandler{},
+	}
+}
+
+// AddContentHandler adds a content handler to the list of handlers used for
+// installing content from entitlement certificates. When there is already handler
+// for the same type of content, then it is replaced by the given handler.
+func (rhsmClient *RHSMClient) AddContentHandler(handler ContentHandler) {
+	if rhsmClient.contentHandlers == nil {
+		rhsmClient.contentHandlers = defaultContentHandlers()
+	}
+	for i, contentHandler := range rhsmClient.contentHandlers {
+		if strings.EqualFold(contentHandler.Type(), handler.Type()) {
+			rhsmClient.contentHandlers[i] = handler
+			return
+		}
+	}
+	rhsmClient.contentHandlers = append(rhsmClient.contentHandlers, handler)
+}
+
+// getContentHandlers returns the list of content handlers
+func (rhsmClient *RHSMClient) getContentHandlers() []ContentHandler {
+	if rhsmClient.contentHandlers == nil {
+		return defaultContentHandlers()
+	}
+	return rhsmClient.contentHandlers
+}
+
+// updateContent tries to update configuration of all types of content except yum content
+// using content handlers. Content not matching installed products and architecture of host
+// is ignored. When some handler fails, then the error is logged and other handlers are still used.
+func (rhsmClient *RHSMClient) updateContent(productsMap map[int64][]EngineeringProduct) {
+	contentTags := createListOfContentTags(rhsmClient.getInstalledProducts())
+	hostArch := hostArchitecture()
+
+	// Serials are sorted to get the same result every time
+	serials := make([]int64, 0, len(productsMap))
+	for serial := range productsMap {
+		serials = append(serials, serial)
+	}
+	sort.Slice(serials, func(i, j int) bool { return serials[i] < serials[j] })
+
+	contentsMap := make(map[string][]EntitledContent)
+	for _, serial := range serials {
+		for _, product := range productsMap[serial] {
+			for _, content := range product.Content {
+				if !isAnyRequiredTagProvided(rhsmClient.getLogger(), content.RequiredTags, contentTags) ||
+					!isArchSupported(content.Arches, hostArch) {
+					continue
+				}
+				contentType := strings.ToLower(content.Type)
+				contentsMap[contentType] = append(contentsMap[contentType], EntitledContent{
+					Content:      content,
+					CertFilePath: *rhsmClient.entCertPath(serial),
+					KeyFilePath:  *rhsmClient.entKeyPath(serial),
+				})
+			}
+		}
+	}
+
+	for _, handler := range rhsmClient.getContentHandlers() {
+		contents := contentsMap[strings.ToLower(handler.Type())]
+		rhsmClient.getLogger().Debug().Msgf("updating %d content of type %s", len(contents), handler.Type())
+		err := handler.Update(rhsmClient, contents)
+		if err != nil {
+			rhsmClient.getLogger().Error().Msgf("unable to update content of type %s: %s", handler.Type(), err)
+		}
+	}
+}
+
+// removeContent tries to remove configuration created by all content handlers
+func (rhsmClient *RHSMClient) removeContent() error {
+	var failedTypes []string
+	for _, handler := range rhsmClient.getContentHandlers() {
+		err := handler.Update(rhsmClient, nil)
+		if err != nil {
+			rhsmClient.getLogger().Error().Msgf("unable to remove content of type %s: %s", handler.Type(), err)
+			failedTypes = append(failedTypes, handler.Type())
+		}
+	}
+	if len(failedTypes) > 0 {
+		return fmt.Errorf("unable to remove content of types: %s", strings.Join(failedTypes, ", "))
+	}
+	return nil
+}
+
+// installFileCopy tries to install the copy of source file to destination file atomically
+func installFileCopy(logger *zerolog.Logger, srcFilePath string, dstFilePath string, mode os.FileMode) error {
+	data, err := os.ReadFile(srcFilePath)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(logger, dstFilePath, data, mode)
+}
+
+// ContainerImageContentHandler installs entitlement certificates and keys to
+// directories of container registries. Container tools use these certificates
+// for authentication, when images are pulled from the registry.
+type ContainerImageContentHandler struct {
+	// CertsDirPath is the directory with certificates of registries. When it is
+	// empty, then the directory from RHSMConf is used (DefaultContainerCertsDirPath)
+	CertsDirPath string
+	// RegistryHostnames is the list of registries using entitlement certificates. When
+	// it is empty, then the default list of Red Hat registries is used
+	RegistryHostnames []string
+}
+
+// Type returns the type of container image content
+func (handler *ContainerImageContentHandler) Type() string {
+	return "containerimage"
+}
+
+// Update tries to install entitlement certificates and keys providing container
+// image content to /etc/containers/certs.d/<registry>/. Certificates are installed
+// as <serial>.cert and keys as <serial>.key. The CA certificate is installed
+// as redhat-uep.crt. Stale certificates and keys are removed. Directories of
+// registries are never removed, because they could be created by the administrator.
+func (handler *ContainerImageContentHandler) Update(rhsmClient *RHSMClient, contents []EntitledContent) error {
+	certsDirPath := handler.CertsDirPath
+	if certsDirPath == "" {
+		certsDirPath = rhsmClient.RHSMConf.containerCertsDirPath
+	}
+	registryHostnames := handler.RegistryHostnames
+	if len(registryHostnames) == 0 {
+		registryHostnames = defaultRegistryHostnames
+	}
+
+	// The same certificate usually provides more container image content. Thus,
+	// the key of the map is serial number of the certificate
+	certKeys := make(map[string]EntitledContent)
+	for _, content := range contents {
+		serial := strings.TrimSuffix(filepath.Base(content.CertFilePath), ".pem")
+		certKeys[serial] = content
+	}
+
+	permissions := rhsmClient.getFilePermissions()
+	for _, registryHostname := range registryHostnames {
+		registryDirPath := filepath.Join(certsDirPath, registryHostname)
+
+		// Remove stale certificates and keys first
+		dirEntries, err := os.ReadDir(registryDirPath)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to read directory %s: %s", registryDirPath, err)
+		}
+		for _, dirEntry := range dirEntries {
+			fileName := dirEntry.Name()
+			if !containerCertFileRegexp.MatchString(fileName) {
+				continue
+			}
+			serial := strings.TrimSuffix(strings.TrimSuffix(fileName, ".cert"), ".key")
+			if _, exists := certKeys[serial]; exists {
+				continue
+			}
+			err = os.Remove(filepath.Join(registryDirPath, fileName))
+			if err != nil {
+				return fmt.Errorf("unable to remove %s: %s", fileName, err)
+			}
+		}
+
+		if len(contents) == 0 {
+			caCertFilePath := filepath.Join(registryDirPath, containerCACertFileName)
+			err = os.Remove(caCertFilePath)
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("unable to remove %s: %s", caCertFilePath, err)
+			}
+			continue
+		}
+
+		err = os.MkdirAll(registryDirPath, permissions.Dir)
+		if err != nil {
+			return fmt.Errorf("unable to create directory %s: %s", registryDirPath, err)
+		}
+
+		for serial, content := range certKeys {
+			err = installFileCopy(rhsmClient.getLogger(), content.CertFilePath, filepath.Join(registryDirPath, serial+".cert"), permissions.Cert)
+			if err != nil {
+				return fmt.Errorf("unable to install entitlement certificate to %s: %s", registryDirPath, err)
+			}
+			err = installFileCopy(rhsmClient.getLogger(), content.KeyFilePath, filepath.Join(registryDirPath, serial+".key"), permissions.Key)
+			if err != nil {
+				return fmt.Errorf("unable to install entitlement key to %s: %s", registryDirPath, err)
+			}
+		}
+
+		repoCACertFilePath := rhsmClient.RHSMConf.RHSM.RepoCACertificate
+		if repoCACertFilePath == "" {
+			continue
+		}
+		repoCACertFilePath = filepath.Join(rhsmClient.RHSMConf.RootDirPath(), repoCACertFilePath)
+		err = installFileCopy(rhsmClient.getLogger(), repoCACertFilePath, filepath.Join(registryDirPath, containerCACertFileName), permissions.Cert)
+		if err != nil {
+			rhsmClient.getLogger().Warn().Msgf("unable to install CA certificate to %s: %s", registryDirPath, err)
+		}
+	}
+
+	return nil
+}
+
+// OstreeContentHandler writes configuration of ostree remotes for ostree content
+type OstreeContentHandler struct {
+	// RemotesFilePath is the file with configuration of ostree remotes. When it is
+	// empty, then the file from RHSMConf is used (DefaultOstreeRemotesFilePath)
+	RemotesFilePath string
+}
+
+// Type returns the type of ostree content
+func (handler *OstreeContentHandler) Type() string {
+	return "ostree"
+}
+
+// Update tries to write one remote for each ostree content to the configuration
+// file of ostree remotes. Remotes use entitlement certificate and key for TLS
+// client authentication. When there is no ostree content, then the file is removed.
+func (handler *OstreeContentHandler) Update(rhsmClient *RHSMClient, contents []EntitledContent) error {
+	remotesFilePath := handler.RemotesFilePath
+	if remotesFilePath == "" {
+		remotesFilePath = rhsmClient.RHSMConf.ostreeRemotesFilePath
+	}
+
+	if len(contents) == 0 {
+		err := os.Remove(remotesFilePath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	ini.PrettyFormat = false
+	file := ini.Empty()
+	for _, content := range contents {
+		section, err := file.NewSection(fmt.Sprintf("remote \"%s\"", content.Label))
+		if err != nil {
+			return fmt.Errorf("unable to add remote: %s: %s", content.Label, err)
+		}
+		remoteURL, err := rhsmClient.contentBaseURL(&content.Content)
+		if err != nil {
+			return err
+		}
+		_, _ = section.NewKey("url", remoteURL)
+		if content.GpgUrl != "" {
+			_, _ = section.NewKey("gpg-verify", "true")
+		} else {
+			_, _ = section.NewKey("gpg-verify", "false")
+		}
+		_, _ = section.NewKey("tls-client-cert-path", rhsmClient.RHSMConf.pathInRootDir(content.CertFilePath))
+		_, _ = section.NewKey("tls-client-key-path", rhsmClient.RHSMConf.pathInRootDir(content.KeyFilePath))
+		if rhsmClient.RHSMConf.RHSM.RepoCACertificate != "" {
+			_, _ = section.NewKey("tls-ca-path", rhsmClient.RHSMConf.RHSM.RepoCACertificate)
+		}
+	}
+
+	var buffer bytes.Buffer
+	_, err := file.WriteTo(&buffer)
+	if err != nil {
+		return fmt.Errorf("unable to serialize ostree remotes: %s", err)
+	}
+	dirPath := filepath.Dir(remotesFilePath)
+	err = os.MkdirAll(dirPath, rhsmClient.getFilePermissions().Dir)
+	if err != nil {
+		return fmt.Errorf("unable to create directory %s: %s", dirPath, err)
+	}
+	return writeFileAtomically(rhsmClient.getLogger(), remotesFilePath, buffer.Bytes(), rhsmClient.getFilePermissions().Config)
+}
@@ -0,0 +1,202 @@
+package rhsm2
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/ini.v1"
+)
+
+// testingEntCertSerial is the serial number of testing entitlement certificate
+const testingEntCertSerial = "4709416649487329566"
+
+// recordingContentHandler is testing content handler, which only records the content
+type recordingContentHandler struct {
+	contentType string
+	contents    []EntitledContent
+}
+
+func (handler *recordingContentHandler) Type() string {
+	return handler.contentType
+}
+
+func (handler *recordingContentHandler) Update(_ *RHSMClient, contents []EntitledContent) error {
+	handler.contents = contents
+	return nil
+}
+
+// setupContentHandlersTest creates testing rhsm client with installed entitlement certificate
+func setupContentHandlersTest(t *testing.T) (*RHSMClient, *TestingFileSystem) {
+	testingFiles, err := setupTestingFileSystem(
+		t.TempDir(), false, true, true, true, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+	rhsmClient.RHSMConf.RHSM.BaseURL = "https://cdn.example.com"
+
+	return rhsmClient, testingFiles
+}
+
+// updateTestingContent tries to update content from installed entitlement certificates
+func updateTestingContent(t *testing.T, rhsmClient *RHSMClient) {
+	engineeringProducts, err := rhsmClient.getEngineeringProducts()
+	if err != nil {
+		t.Fatalf("unable to get engineering products: %s", err)
+	}
+	rhsmClient.updateContent(engineeringProducts)
+}
+
+// TestContainerImageContentHandler test installing entitlement certificates
+// to directories of container registries
+func TestContainerImageContentHandler(t *testing.T) {
+	t.Parallel()
+	rhsmClient, testingFiles := setupContentHandlersTest(t)
+
+	caCertFilePath := filepath.Join(testingFiles.CACertDirPath, "redhat-uep.pem")
+	err := os.WriteFile(caCertFilePath, []byte("CA certificate"), 0644)
+	if err != nil {
+		t.Fatalf("unable to create CA certificate: %s", err)
+	}
+	rhsmClient.RHSMConf.RHSM.RepoCACertificate = caCertFilePath
+
+	// Stale certificate of removed entitlement and file not created by rhsm
+	registryDirPath := filepath.Join(testingFiles.ContainerCertsDirPath, "registry.redhat.io")
+	err = os.MkdirAll(registryDirPath, 0755)
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err)
+	}
+	for _, fileName := range []string{"123.cert", "123.key", "custom.cert"} {
+		err = os.WriteFile(filepath.Join(registryDirPath, fileName), []byte{}, 0644)
+		if err != nil {
+			t.Fatalf("unable to create file: %s", err)
+		}
+	}
+
+	updateTestingContent(t, rhsmClient)
+
+	for _, registryHostname := range defaultRegistryHostnames {
+		for _, fileName := range []string{
+			testingEntCertSerial + ".cert",
+			testingEntCertSerial + ".key",
+			containerCACertFileName,
+		} {
+			filePath := filepath.Join(testingFiles.ContainerCertsDirPath, registryHostname, fileName)
+			if _, err := os.Stat(filePath); err != nil {
+				t.Errorf("file %s was not installed: %s", filePath, err)
+			}
+		}
+	}
+	for fileName, expectedExists := range map[string]bool{"123.cert": false, "123.key": false, "custom.cert": true} {
+		_, err := os.Stat(filepath.Join(registryDirPath, fileName))
+		if exists := err == nil; exists != expectedExists {
+			t.Errorf("file %s exists: %v, expected: %v", fileName, exists, expectedExists)
+		}
+	}
+
+	err = rhsmClient.removeContent()
+	if err != nil {
+		t.Fatalf("unable to remove content: %s", err)
+	}
+	// Directories of registries could be created by the administrator. Thus, they are kept
+	for _, registryHostname := range defaultRegistryHostnames[1:] {
+		dirPath := filepath.Join(testingFiles.ContainerCertsDirPath, registryHostname)
+		entries, err := os.ReadDir(dirPath)
+		if err != nil {
+			t.Errorf("directory %s was removed: %s", dirPath, err)
+			continue
+		}
+		if len(entries) != 0 {
+			t.Errorf("expected empty directory %s, got: %v", dirPath, entries)
+		}
+	}
+	entries, err := os.ReadDir(registryDirPath)
+	if err != nil {
+		t.Fatalf("unable to read directory: %s", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "custom.cert" {
+		t.Errorf("expected only custom.cert in %s, got: %v", registryDirPath, entries)
+	}
+}
+
+// TestOstreeContentHandler test writing configuration of ostree remotes
+func TestOstreeContentHandler(t *testing.T) {
+	t.Parallel()
+	rhsmClient, testingFiles := setupContentHandlersTest(t)
+
+	updateTestingContent(t, rhsmClient)
+
+	remotes, err := ini.Load(testingFiles.OstreeRemotesFilePath)
+	if err != nil {
+		t.Fatalf("unable to load ostree remotes: %s", err)
+	}
+	sectionName := `remote "awesomeos-ostree-37091"`
+	if !remotes.HasSection(sectionName) {
+		t.Fatalf("remote of ostree content is missing, sections: %v", remotes.SectionStrings())
+	}
+	section := remotes.Section(sectionName)
+	if !strings.HasPrefix(section.Key("url").Value(), "https://cdn.example.com/") {
+		t.Errorf("unexpected url of remote: %s", section.Key("url").Value())
+	}
+	expectedCertPath := filepath.Join(testingFiles.EntitlementDirPath, testingEntCertSerial+".pem")
+	if section.Key("tls-client-cert-path").Value() != expectedCertPath {
+		t.Errorf("expected tls-client-cert-path: %s, got: %s",
+			expectedCertPath, section.Key("tls-client-cert-path").Value())
+	}
+	expectedKeyPath := filepath.Join(testingFiles.EntitlementDirPath, testingEntCertSerial+"-key.pem")
+	if section.Key("tls-client-key-path").Value() != expectedKeyPath {
+		t.Errorf("expected tls-client-key-path: %s, got: %s",
+			expectedKeyPath, section.Key("tls-client-key-path").Value())
+	}
+	if len(remotes.Sections()) != 2 {
+		t.Errorf("expected only one remote, got: %v", remotes.SectionStrings())
+	}
+
+	err = rhsmClient.removeContent()
+	if err != nil {
+		t.Fatalf("unable to remove content: %s", err)
+	}
+	if _, err := os.Stat(testingFiles.OstreeRemotesFilePath); !os.IsNotExist(err) {
+		t.Errorf("ostree remotes file was not removed")
+	}
+}
+
+// TestAddContentHandler test adding of custom content handlers
+func TestAddContentHandler(t *testing.T) {
+	t.Parallel()
+	rhsmClient, testingFiles := setupContentHandlersTest(t)
+
+	debHandler := &recordingContentHandler{contentType: "deb"}
+	containerImageHandler := &recordingContentHandler{contentType: "containerImage"}
+	rhsmClient.AddContentHandler(debHandler)
+	rhsmClient.AddContentHandler(containerImageHandler)
+
+	if len(rhsmClient.getContentHandlers()) != 3 {
+		t.Fatalf("expected 3 content handlers, got: %d", len(rhsmClient.getContentHandlers()))
+	}
+
+	updateTestingContent(t, rhsmClient)
+
+	if len(debHandler.contents) != 1 || debHandler.contents[0].Label != "awesomeos-deb-38070" {
+		t.Errorf("unexpected content passed to deb handler: %v", debHandler.contents)
+	}
+	if len(containerImageHandler.contents) != 1 ||
+		containerImageHandler.contents[0].Label != "awesomeos-docker-images-37090" {
+		t.Errorf("unexpected content passed to container image handler: %v", containerImageHandler.contents)
+	}
+
+	// Default handler of container image content was replaced
+	if _, err := os.Stat(testingFiles.ContainerCertsDirPath); !os.IsNotExist(err) {
+		t.Errorf("default container image content handler was used")
+	}
+	// Default handler of ostree content is still used
+	if _, err := os.Stat(testingFiles.OstreeRemotesFilePath); err != nil {
+		t.Errorf("default ostree content handler was not used: %s", err)
+	}
+}
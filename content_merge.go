@@ -0,0 +1,200 @@
+package rhsm2
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"gopkg.in/ini.v1"
+)
+
+// DefaultRepoFileCacheFilePath is the file path of the cache with repositories
+// generated to redhat.repo last time. The cache is used for detecting keys
+// modified by user
+const DefaultRepoFileCacheFilePath = DefaultCacheDirPath + "/repos/redhat.repo.json"
+
+// RepoKeyChange is structure representing the change of one key
+// of one repository in redhat.repo
+type RepoKeyChange struct {
+	Key      string `json:"key"`
+	OldValue string `json:"oldValue"`
+	NewValue string `json:"newValue"`
+}
+
+// RepoFileDiff is machine-readable report of changes done in redhat.repo
+// during its regeneration
+type RepoFileDiff struct {
+	// Added is the list of labels of added repositories
+	Added []string `json:"added"`
+	// Removed is the list of labels of removed repositories, which are not
+	// provided by any entitlement certificate anymore
+	Removed []string `json:"removed"`
+	// Modified contains changed keys of existing repositories. The key of
+	// the map is the label of repository
+	Modified map[string][]RepoKeyChange `json:"modified"`
+	// Preserved contains keys modified by user, which were not updated. The key
+	// of the map is the label of repository
+	Preserved map[string][]string `json:"preserved"`
+}
+
+// Changed returns true, when some repository was added, removed or modified
+func (diff *RepoFileDiff) Changed() bool {
+	return len(diff.Added) > 0 || len(diff.Removed) > 0 || len(diff.Modified) > 0
+}
+
+// repoFileCache is structure representing repositories cached on disk
+type repoFileCache struct {
+	Repos map[string]map[string]string `json:"repos"`
+}
+
+// readRepoFileCache tries to read repositories generated last time. When
+// the cache does not exist or it is not readable, then nil is returned
+func (rhsmClient *RHSMClient) readRepoFileCache() map[string]map[string]string {
+	var cache repoFileCache
+	err := readJSONCache(rhsmClient.RHSMConf.repoFileCacheFilePath, &cache)
+	if err != nil {
+		rhsmClient.getLogger().Debug().Msgf("unable to read repo file cache: %s", err)
+		return nil
+	}
+	return cache.Repos
+}
+
+// writeRepoFileCache tries to write generated repositories to the cache on disk
+func (rhsmClient *RHSMClient) writeRepoFileCache(repos map[string]map[string]string) error {
+	return writeJSONCache(rhsmClient.getLogger(), rhsmClient.RHSMConf.repoFileCacheFilePath, &repoFileCache{Repos: repos}, rhsmClient.getFilePermissions())
+}
+
+// readExistingRepoFile tries to read existing repo file. When the file
+// does not exist, then empty file is returned.
+func readExistingRepoFile(filePath string) (*ini.File, error) {
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return ini.Empty(), nil
+	}
+	file, err := ini.Load(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse repo file %s: %s", filePath, err)
+	}
+	return file, nil
+}
+
+// iniFileToMap converts all sections of ini file (except DEFAULT section) to map
+func iniFileToMap(file *ini.File) map[string]map[string]string {
+	result := make(map[string]map[string]string)
+	for _, section := range file.Sections() {
+		if section.Name() == ini.DefaultSection {
+			continue
+		}
+		result[section.Name()] = section.KeysHash()
+	}
+	return result
+}
+
+// mergeRepoFile merges generated repositories into existing repo file. The existing
+// file is modified. Keys generated from entitlement certificates are updated, unless
+// they were modified by user. The key is considered as modified by user, when its
+// current value differs from the value generated last time (lastGenerated). Protected
+// keys (e.g. baseurl or sslclientcert) and keys set by content overrides (overriddenKeys)
+// are always updated, because modification by user cannot win over them. Keys
+// added by user are kept. Repositories, which were not generated, are removed.
+func mergeRepoFile(
+	existing *ini.File,
+	generated *ini.File,
+	lastGenerated map[string]map[string]string,
+	overriddenKeys map[string]map[string]string,
+) *RepoFileDiff {
+	diff := &RepoFileDiff{
+		Added:     []string{},
+		Removed:   []string{},
+		Modified:  make(map[string][]RepoKeyChange),
+		Preserved: make(map[string][]string),
+	}
+
+	for _, generatedSection := range generated.Sections() {
+		label := generatedSection.Name()
+		if label == ini.DefaultSection {
+			continue
+		}
+
+		// Whole new repository
+		if !existing.HasSection(label) {
+			section, _ := existing.NewSection(label)
+			for _, key := range generatedSection.Keys() {
+				_, _ = section.NewKey(key.Name(), key.Value())
+			}
+			diff.Added = append(diff.Added, label)
+			continue
+		}
+
+		section := existing.Section(label)
+		lastValues := lastGenerated[label]
+
+		for _, generatedKey := range generatedSection.Keys() {
+			name := generatedKey.Name()
+			newValue := generatedKey.Value()
+
+			if !section.HasKey(name) {
+				_, _ = section.NewKey(name, newValue)
+				diff.Modified[label] = append(diff.Modified[label], RepoKeyChange{
+					Key:      name,
+					NewValue: newValue,
+				})
+				continue
+			}
+
+			key := section.Key(name)
+			oldValue := key.Value()
+			if oldValue == newValue {
+				continue
+			}
+
+			// Value was modified by user since the last generation
+			_, overridden := overriddenKeys[label][name]
+			if lastValue, exists := lastValues[name]; exists && lastValue != oldValue &&
+				!overridden && !isProtectedRepoKey(name) {
+				diff.Preserved[label] = append(diff.Preserved[label], name)
+				continue
+			}
+
+			key.SetValue(newValue)
+			diff.Modified[label] = append(diff.Modified[label], RepoKeyChange{
+				Key:      name,
+				OldValue: oldValue,
+				NewValue: newValue,
+			})
+		}
+
+		// Remove keys, which were generated last time, but they are not generated anymore.
+		// Other keys were added by user.
+		for _, key := range section.Keys() {
+			name := key.Name()
+			if generatedSection.HasKey(name) {
+				continue
+			}
+			if _, exists := lastValues[name]; !exists {
+				continue
+			}
+			diff.Modified[label] = append(diff.Modified[label], RepoKeyChange{
+				Key:      name,
+				OldValue: key.Value(),
+			})
+			section.DeleteKey(name)
+		}
+	}
+
+	// Remove repositories, which do not exist anymore
+	for _, section := range existing.Sections() {
+		label := section.Name()
+		if label == ini.DefaultSection || generated.HasSection(label) {
+			continue
+		}
+		diff.Removed = append(diff.Removed, label)
+	}
+	for _, label := range diff.Removed {
+		existing.DeleteSection(label)
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+
+	return diff
+}
@@ -0,0 +1,291 @@
+package rhsm2
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"gopkg.in/ini.v1"
+)
+
+// TestMergeRepoFile test merging of generated repositories into existing repo file
+func TestMergeRepoFile(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name              string
+		existing          string
+		generated         string
+		lastGenerated     map[string]map[string]string
+		overriddenKeys    map[string]map[string]string
+		expected          map[string]map[string]string
+		expectedAdded     []string
+		expectedRemoved   []string
+		expectedModified  map[string][]RepoKeyChange
+		expectedPreserved map[string][]string
+	}{
+		{
+			name:     "new repo file",
+			existing: "",
+			generated: `[foo]
+baseurl=https://cdn.example.com/foo
+enabled=1
+`,
+			expected: map[string]map[string]string{
+				"foo": {"baseurl": "https://cdn.example.com/foo", "enabled": "1"},
+			},
+			expectedAdded:     []string{"foo"},
+			expectedRemoved:   []string{},
+			expectedModified:  map[string][]RepoKeyChange{},
+			expectedPreserved: map[string][]string{},
+		},
+		{
+			name: "user modified and added keys are preserved",
+			existing: `[foo]
+baseurl=https://cdn.example.com/foo
+enabled=0
+priority=10
+`,
+			generated: `[foo]
+baseurl=https://cdn.example.com/foo/v2
+enabled=1
+`,
+			lastGenerated: map[string]map[string]string{
+				"foo": {"baseurl": "https://cdn.example.com/foo", "enabled": "1"},
+			},
+			expected: map[string]map[string]string{
+				"foo": {"baseurl": "https://cdn.example.com/foo/v2", "enabled": "0", "priority": "10"},
+			},
+			expectedAdded:   []string{},
+			expectedRemoved: []string{},
+			expectedModified: map[string][]RepoKeyChange{
+				"foo": {{Key: "baseurl", OldValue: "https://cdn.example.com/foo", NewValue: "https://cdn.example.com/foo/v2"}},
+			},
+			expectedPreserved: map[string][]string{"foo": {"enabled"}},
+		},
+		{
+			name: "server values are used without cache",
+			existing: `[foo]
+enabled=0
+`,
+			generated: `[foo]
+enabled=1
+`,
+			expected: map[string]map[string]string{
+				"foo": {"enabled": "1"},
+			},
+			expectedAdded:   []string{},
+			expectedRemoved: []string{},
+			expectedModified: map[string][]RepoKeyChange{
+				"foo": {{Key: "enabled", OldValue: "0", NewValue: "1"}},
+			},
+			expectedPreserved: map[string][]string{},
+		},
+		{
+			name: "stale repositories and keys are removed",
+			existing: `[foo]
+enabled=1
+gpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-foo
+
+[bar]
+enabled=1
+`,
+			generated: `[foo]
+enabled=1
+`,
+			lastGenerated: map[string]map[string]string{
+				"foo": {"enabled": "1", "gpgkey": "file:///etc/pki/rpm-gpg/RPM-GPG-KEY-foo"},
+				"bar": {"enabled": "1"},
+			},
+			expected: map[string]map[string]string{
+				"foo": {"enabled": "1"},
+			},
+			expectedAdded:   []string{},
+			expectedRemoved: []string{"bar"},
+			expectedModified: map[string][]RepoKeyChange{
+				"foo": {{Key: "gpgkey", OldValue: "file:///etc/pki/rpm-gpg/RPM-GPG-KEY-foo"}},
+			},
+			expectedPreserved: map[string][]string{},
+		},
+		{
+			name: "protected keys modified by user are updated",
+			existing: `[foo]
+baseurl=https://evil.example.com/foo
+sslclientcert=/tmp/cert.pem
+enabled=1
+`,
+			generated: `[foo]
+baseurl=https://cdn.example.com/foo
+sslclientcert=/etc/pki/entitlement/1.pem
+enabled=1
+`,
+			lastGenerated: map[string]map[string]string{
+				"foo": {"baseurl": "https://cdn.example.com/foo", "sslclientcert": "/etc/pki/entitlement/1.pem", "enabled": "1"},
+			},
+			expected: map[string]map[string]string{
+				"foo": {"baseurl": "https://cdn.example.com/foo", "sslclientcert": "/etc/pki/entitlement/1.pem", "enabled": "1"},
+			},
+			expectedAdded:   []string{},
+			expectedRemoved: []string{},
+			expectedModified: map[string][]RepoKeyChange{
+				"foo": {
+					{Key: "baseurl", OldValue: "https://evil.example.com/foo", NewValue: "https://cdn.example.com/foo"},
+					{Key: "sslclientcert", OldValue: "/tmp/cert.pem", NewValue: "/etc/pki/entitlement/1.pem"},
+				},
+			},
+			expectedPreserved: map[string][]string{},
+		},
+		{
+			name: "content overrides win over keys modified by user",
+			existing: `[foo]
+baseurl=https://cdn.example.com/foo
+enabled=1
+`,
+			generated: `[foo]
+baseurl=https://cdn.example.com/foo
+enabled=0
+`,
+			lastGenerated: map[string]map[string]string{
+				"foo": {"baseurl": "https://cdn.example.com/foo", "enabled": "0"},
+			},
+			overriddenKeys: map[string]map[string]string{
+				"foo": {"enabled": "0"},
+			},
+			expected: map[string]map[string]string{
+				"foo": {"baseurl": "https://cdn.example.com/foo", "enabled": "0"},
+			},
+			expectedAdded:   []string{},
+			expectedRemoved: []string{},
+			expectedModified: map[string][]RepoKeyChange{
+				"foo": {{Key: "enabled", OldValue: "1", NewValue: "0"}},
+			},
+			expectedPreserved: map[string][]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			existing, err := ini.Load([]byte(tt.existing))
+			if err != nil {
+				t.Fatalf("unable to parse existing repo file: %s", err)
+			}
+			generated, err := ini.Load([]byte(tt.generated))
+			if err != nil {
+				t.Fatalf("unable to parse generated repo file: %s", err)
+			}
+
+			diff := mergeRepoFile(existing, generated, tt.lastGenerated, tt.overriddenKeys)
+
+			if result := iniFileToMap(existing); !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected repo file: %v, got: %v", tt.expected, result)
+			}
+			if !reflect.DeepEqual(diff.Added, tt.expectedAdded) {
+				t.Errorf("expected added: %v, got: %v", tt.expectedAdded, diff.Added)
+			}
+			if !reflect.DeepEqual(diff.Removed, tt.expectedRemoved) {
+				t.Errorf("expected removed: %v, got: %v", tt.expectedRemoved, diff.Removed)
+			}
+			if !reflect.DeepEqual(diff.Modified, tt.expectedModified) {
+				t.Errorf("expected modified: %v, got: %v", tt.expectedModified, diff.Modified)
+			}
+			if !reflect.DeepEqual(diff.Preserved, tt.expectedPreserved) {
+				t.Errorf("expected preserved: %v, got: %v", tt.expectedPreserved, diff.Preserved)
+			}
+		})
+	}
+}
+
+// TestWriteRepoFilePreservesLocalChanges test that changes done by user
+// in redhat.repo are not lost, when the repo file is regenerated
+func TestWriteRepoFilePreservesLocalChanges(t *testing.T) {
+	t.Parallel()
+	tempDirFilePath := t.TempDir()
+
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, false, true, true, true, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	diff, err := rhsmClient.generateRepoFileFromInstalledEntitlementCerts()
+	if err != nil {
+		t.Fatalf("unable to generate repo file: %s", err)
+	}
+	if len(diff.Added) == 0 {
+		t.Fatalf("no repository added to empty repo file")
+	}
+
+	// Regeneration without any change does not change anything
+	diff, err = rhsmClient.generateRepoFileFromInstalledEntitlementCerts()
+	if err != nil {
+		t.Fatalf("unable to generate repo file: %s", err)
+	}
+	if diff.Changed() {
+		t.Fatalf("repo file changed without any change: %v", diff)
+	}
+
+	// Simulate changes done by user
+	label := "awesomeos-x86_64-only-content-213412341236"
+	repoFile, err := ini.Load(testingFiles.YumRepoFilePath)
+	if err != nil {
+		t.Fatalf("unable to load repo file: %s", err)
+	}
+	repoFile.Section(label).Key("enabled").SetValue("1")
+	_, _ = repoFile.Section(label).NewKey("priority", "10")
+	_, _ = repoFile.Section("stale-repo").NewKey("enabled", "1")
+	err = repoFile.SaveTo(testingFiles.YumRepoFilePath)
+	if err != nil {
+		t.Fatalf("unable to save repo file: %s", err)
+	}
+
+	// Simulate change of server-derived values
+	rhsmClient.RHSMConf.RHSM.BaseURL = "https://cdn.example.com"
+
+	diff, err = rhsmClient.generateRepoFileFromInstalledEntitlementCerts()
+	if err != nil {
+		t.Fatalf("unable to generate repo file: %s", err)
+	}
+
+	repoFile, err = ini.Load(testingFiles.YumRepoFilePath)
+	if err != nil {
+		t.Fatalf("unable to load repo file: %s", err)
+	}
+	section := repoFile.Section(label)
+	if section.Key("enabled").Value() != "1" {
+		t.Errorf("enabled modified by user was not preserved")
+	}
+	if section.Key("priority").Value() != "10" {
+		t.Errorf("priority added by user was not preserved")
+	}
+	expectedBaseURL := "https://cdn.example.com/path/to/awesomeos/x86_64_content/213412341236-6401"
+	if section.Key("baseurl").Value() != expectedBaseURL {
+		t.Errorf("expected baseurl: %s, got: %s", expectedBaseURL, section.Key("baseurl").Value())
+	}
+	if repoFile.HasSection("stale-repo") {
+		t.Errorf("stale repository was not removed")
+	}
+
+	if !reflect.DeepEqual(diff.Removed, []string{"stale-repo"}) {
+		t.Errorf("expected removed: %v, got: %v", []string{"stale-repo"}, diff.Removed)
+	}
+	if !reflect.DeepEqual(diff.Preserved[label], []string{"enabled"}) {
+		t.Errorf("expected preserved: %v, got: %v", []string{"enabled"}, diff.Preserved[label])
+	}
+	if len(diff.Modified[label]) != 1 || diff.Modified[label][0].Key != "baseurl" {
+		t.Errorf("expected modified baseurl, got: %v", diff.Modified[label])
+	}
+
+	// Cache of generated repositories is removed together with other installed files
+	err = rhsmClient.removeInstalledFiles()
+	if err != nil {
+		t.Fatalf("unable to remove installed files: %s", err)
+	}
+	if _, err := os.Stat(rhsmClient.RHSMConf.repoFileCacheFilePath); !os.IsNotExist(err) {
+		t.Errorf("repo file cache was not removed")
+	}
+}
@@ -1,11 +1,17 @@
 package rhsm2
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 	"gopkg.in/ini.v1"
 )
 
@@ -13,6 +19,40 @@ const dnf5ReposOverrideDirPath = "/etc/dnf/repos.override.d"
 const dnf5ReposOverrideFileName = "98-redhat.repo"
 const dnf5RedHatReposOverrideFilePath = dnf5ReposOverrideDirPath + "/" + dnf5ReposOverrideFileName
 
+// dnf5BinaryFilePath is the file path of dnf5 binary. It is used for detection of dnf5
+const dnf5BinaryFilePath = "/usr/bin/dnf5"
+
+// DefaultContentOverridesCacheFilePath is the file path of the cache with content overrides
+// received from the server last time. It is used, when redhat.repo is regenerated for dnf4
+const DefaultContentOverridesCacheFilePath = DefaultCacheDirPath + "/content_overrides/content_overrides.json"
+
+// DnfVersion is the generation of dnf package manager used on the managed system
+type DnfVersion int
+
+const (
+	// DnfVersionAuto means that the generation of dnf is detected automatically
+	DnfVersionAuto DnfVersion = iota
+	// DnfVersion4 is dnf4 (RHEL 8, RHEL 9). It does not support repo override
+	// drop-in files. Thus, content overrides are written directly to redhat.repo
+	DnfVersion4
+	// DnfVersion5 is dnf5. Content overrides are written to the repo override
+	// file in /etc/dnf/repos.override.d
+	DnfVersion5
+)
+
+// protectedRepoKeys is the list of repository keys, which cannot be changed by
+// content overrides. These keys are always generated from entitlement certificates
+// and rhsm.conf
+var protectedRepoKeys = []string{
+	"name",
+	"baseurl",
+	"sslverify",
+	"sslverifystatus",
+	"sslcacert",
+	"sslclientkey",
+	"sslclientcert",
+}
+
 // ContentOverride is a structure containing information about content
 // override for a given repository
 type ContentOverride struct {
@@ -24,7 +64,7 @@ type ContentOverride struct {
 }
 
 // getContentOverrides tries to get content overrides from server
-func (rhsmClient *RHSMClient) getContentOverrides(info *RequestMetadata) ([]ContentOverride, error) {
+func (rhsmClient *RHSMClient) getContentOverrides(ctx context.Context, info *RequestMetadata) ([]ContentOverride, error) {
 	var contentOverrides []ContentOverride
 
 	consumerUuid, err := rhsmClient.GetConsumerUUID()
@@ -40,6 +80,7 @@ func (rhsmClient *RHSMClient) getContentOverrides(info *RequestMetadata) ([]Cont
 		return nil, fmt.Errorf("unable to get consumer cert auth connection: %v", err)
 	}
 	res, err := connection.request(
+		ctx,
 		rhsmClient.UserAgent,
 		http.MethodGet,
 		"consumers/"+*consumerUuid+"/content_overrides",
@@ -64,19 +105,152 @@ func (rhsmClient *RHSMClient) getContentOverrides(info *RequestMetadata) ([]Cont
 			return nil, err
 		}
 	case 403:
-		log.Error().Msgf("insufficient permissions")
-		return nil, fmt.Errorf("unable to get content overrides")
+		rhsmClient.getLogger().Error().Msgf("insufficient permissions")
+		return nil, fmt.Errorf("unable to get content overrides: %w", newCandlepinError(res))
 	case 404:
-		log.Error().Msgf("consumer with UUID: %s could no be found", *consumerUuid)
-		return nil, fmt.Errorf("unable to get content overrides")
+		rhsmClient.getLogger().Error().Msgf("consumer with UUID: %s could no be found", *consumerUuid)
+		return nil, fmt.Errorf("unable to get content overrides: %w", newCandlepinError(res))
 	case 500:
-		log.Error().Msgf("an unexpected exception has occurred")
-		return nil, fmt.Errorf("unable to get content overrides")
+		rhsmClient.getLogger().Error().Msgf("an unexpected exception has occurred")
+		return nil, fmt.Errorf("unable to get content overrides: %w", newCandlepinError(res))
+	default:
+		return nil, fmt.Errorf("unable to get content overrides: %w", newCandlepinError(res))
 	}
 
 	return contentOverrides, nil
 }
 
+// SetDnfVersion sets the generation of dnf used on the managed system. It determines,
+// where content overrides are written. DnfVersionAuto is used by default.
+func (rhsmClient *RHSMClient) SetDnfVersion(dnfVersion DnfVersion) {
+	rhsmClient.RHSMConf.dnfVersion = dnfVersion
+}
+
+// getDnfVersion returns the generation of dnf used on the managed system. When
+// it was not set, then it is detected in the root directory
+func (rhsmClient *RHSMClient) getDnfVersion() DnfVersion {
+	if rhsmClient.RHSMConf.dnfVersion != DnfVersionAuto {
+		return rhsmClient.RHSMConf.dnfVersion
+	}
+	return detectDnfVersion(rhsmClient.RHSMConf.RootDirPath())
+}
+
+// detectDnfVersion tries to detect the generation of dnf installed in the given
+// root directory. When dnf5 binary is not installed, then dnf4 is assumed.
+func detectDnfVersion(rootDirPath string) DnfVersion {
+	_, err := os.Stat(filepath.Join(rootDirPath, dnf5BinaryFilePath))
+	if err != nil {
+		return DnfVersion4
+	}
+	return DnfVersion5
+}
+
+// isProtectedRepoKey returns true, when the key of repository cannot be
+// changed by content override
+func isProtectedRepoKey(name string) bool {
+	return slices.Contains(protectedRepoKeys, strings.ToLower(name))
+}
+
+// filterContentOverrides returns only content overrides, which do not try
+// to change protected keys of repositories
+func (rhsmClient *RHSMClient) filterContentOverrides(contentOverrides []ContentOverride) []ContentOverride {
+	var result []ContentOverride
+	for _, contentOverride := range contentOverrides {
+		if isProtectedRepoKey(contentOverride.Name) {
+			rhsmClient.getLogger().Warn().Msgf("ignoring content override of protected key %s of repository %s",
+				contentOverride.Name, contentOverride.ContentLabel)
+			continue
+		}
+		result = append(result, contentOverride)
+	}
+	return result
+}
+
+// contentOverridesCache is structure representing content overrides cached on disk
+type contentOverridesCache struct {
+	ContentOverrides []ContentOverride `json:"contentOverrides"`
+}
+
+// readContentOverridesCache tries to read content overrides received from the server
+// last time. When the cache does not exist or it is not readable, then nil is returned
+func (rhsmClient *RHSMClient) readContentOverridesCache() []ContentOverride {
+	var cache contentOverridesCache
+	err := readJSONCache(rhsmClient.RHSMConf.contentOverridesCacheFilePath, &cache)
+	if err != nil {
+		rhsmClient.getLogger().Debug().Msgf("unable to read content overrides cache: %s", err)
+		return nil
+	}
+	return cache.ContentOverrides
+}
+
+// saveContentOverrides tries to store content overrides received from the server.
+// Content overrides are always written to the cache. When dnf5 is used, then they
+// are written to dnf5 repo override file too. When dnf4 is used, then content
+// overrides from the cache are merged into redhat.repo during its generation.
+func (rhsmClient *RHSMClient) saveContentOverrides(contentOverrides []ContentOverride) error {
+	contentOverrides = rhsmClient.filterContentOverrides(contentOverrides)
+
+	err := writeJSONCache(
+		rhsmClient.getLogger(),
+		rhsmClient.RHSMConf.contentOverridesCacheFilePath,
+		&contentOverridesCache{ContentOverrides: contentOverrides},
+		rhsmClient.getFilePermissions(),
+	)
+	if err != nil {
+		rhsmClient.getLogger().Warn().Msgf("unable to write content overrides cache: %s", err)
+	}
+
+	if rhsmClient.getDnfVersion() != DnfVersion5 {
+		rhsmClient.getLogger().Debug().Msgf("dnf5 not detected, content overrides will be written to %s",
+			rhsmClient.RHSMConf.yumRepoFilePath)
+		return nil
+	}
+
+	return rhsmClient.updateDnf5RepoOverrideFile(contentOverrides)
+}
+
+// updateDnf5RepoOverrideFile tries to write content overrides to dnf5 repo override file.
+// When there is no content override, then the file is removed.
+func (rhsmClient *RHSMClient) updateDnf5RepoOverrideFile(contentOverrides []ContentOverride) error {
+	filePath := rhsmClient.RHSMConf.dnf5ReposOverrideFilePath
+	if len(contentOverrides) == 0 {
+		err := os.Remove(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return writeContentOverridesToDnf5RepoOverride(
+		rhsmClient.getLogger(),
+		contentOverrides,
+		filePath,
+		rhsmClient.getFilePermissions(),
+	)
+}
+
+// applyContentOverridesToRepoFile tries to merge content overrides into sections
+// of generated repo file. It is used, when dnf does not support repo override
+// files. Content overrides of repositories not present in the repo file and
+// content overrides of protected keys are ignored.
+func applyContentOverridesToRepoFile(file *ini.File, contentOverrides []ContentOverride) {
+	for contentLabel, contentOverrideMap := range createMapFromContentOverrides(contentOverrides) {
+		if !file.HasSection(contentLabel) {
+			continue
+		}
+		section := file.Section(contentLabel)
+		for name, value := range contentOverrideMap {
+			if isProtectedRepoKey(name) {
+				continue
+			}
+			if section.HasKey(name) {
+				section.Key(name).SetValue(value)
+			} else {
+				_, _ = section.NewKey(name, value)
+			}
+		}
+	}
+}
+
 // readContentOverridesFromDnf5RepoOverride tries to read content overrides from dnf5 repo override file
 // We try to read repo overrides using ini package. Hopefully, it will work without any issue.
 func readContentOverridesFromDnf5RepoOverride(filePath string) (map[string]map[string]string, error) {
@@ -101,7 +275,12 @@ func readContentOverridesFromDnf5RepoOverride(filePath string) (map[string]map[s
 }
 
 // writeContentOverridesToDnf5RepoOverride tries to write content overrides to dnf5 repo override file
-func writeContentOverridesToDnf5RepoOverride(contentOverrides []ContentOverride, filePath string) error {
+func writeContentOverridesToDnf5RepoOverride(
+	logger *zerolog.Logger,
+	contentOverrides []ContentOverride,
+	filePath string,
+	permissions FilePermissions,
+) error {
 	// First, create empty ini file object
 	repo := ini.Empty()
 
@@ -136,7 +315,17 @@ func writeContentOverridesToDnf5RepoOverride(contentOverrides []ContentOverride,
 	}
 
 	// Write repo override to the file
-	err := repo.SaveTo(filePath)
+	var buffer bytes.Buffer
+	_, err := repo.WriteTo(&buffer)
+	if err != nil {
+		return err
+	}
+	dirPath := filepath.Dir(filePath)
+	err = os.MkdirAll(dirPath, permissions.Dir)
+	if err != nil {
+		return fmt.Errorf("unable to create directory %s: %s", dirPath, err)
+	}
+	err = writeFileAtomically(logger, filePath, buffer.Bytes(), permissions.Config)
 	if err != nil {
 		return err
 	}
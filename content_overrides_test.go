@@ -1,13 +1,16 @@
 package rhsm2
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/rs/zerolog/log"
 	"gopkg.in/ini.v1"
 )
 
@@ -76,7 +79,7 @@ func TestGetContentOverrides(t *testing.T) {
 		t.Fatalf("unable to setup testing rhsm client: %s", err)
 	}
 
-	contentOverrides, err := rhsmClient.getContentOverrides(nil)
+	contentOverrides, err := rhsmClient.getContentOverrides(context.Background(), nil)
 	if err != nil {
 		t.Fatalf("unable to get list of content overrides: %s", err)
 	}
@@ -140,7 +143,7 @@ func TestGetContentOverridesInsufficientPermissions(t *testing.T) {
 		t.Fatalf("unable to setup testing rhsm client: %s", err)
 	}
 
-	_, err = rhsmClient.getContentOverrides(nil)
+	_, err = rhsmClient.getContentOverrides(context.Background(), nil)
 	if err == nil {
 		t.Fatalf("no error raised, when server responses with 403 status code")
 	}
@@ -196,7 +199,7 @@ func TestGetContentOverridesWrongConsumer(t *testing.T) {
 		t.Fatalf("unable to setup testing rhsm client: %s", err)
 	}
 
-	_, err = rhsmClient.getContentOverrides(nil)
+	_, err = rhsmClient.getContentOverrides(context.Background(), nil)
 	if err == nil {
 		t.Fatalf("no error raised, when server responses with 404 status code")
 	}
@@ -252,7 +255,7 @@ func TestGetContentOverridesInternalServerError(t *testing.T) {
 		t.Fatalf("unable to setup testing rhsm client: %s", err)
 	}
 
-	_, err = rhsmClient.getContentOverrides(nil)
+	_, err = rhsmClient.getContentOverrides(context.Background(), nil)
 	if err == nil {
 		t.Fatalf("no error raised, when server responses with 500 status code")
 	}
@@ -418,7 +421,7 @@ func Test_writeContentOverridesToDnf5RepoOverride(t *testing.T) {
 			tempDir := t.TempDir()
 			filePath := tempDir + "/repo_overrides.repo"
 
-			err := writeContentOverridesToDnf5RepoOverride(tt.args.contentOverrides, filePath)
+			err := writeContentOverridesToDnf5RepoOverride(&log.Logger, tt.args.contentOverrides, filePath, DefaultFilePermissions())
 			if (err != nil) != tt.wantErr {
 				t.Errorf("writeContentOverridesToDnf5RepoOverride() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -556,3 +559,54 @@ func Test_readContentOverridesFromDnf5RepoOverride(t *testing.T) {
 		})
 	}
 }
+
+// Test_detectDnfVersion tests detection of dnf generation in root directory
+func Test_detectDnfVersion(t *testing.T) {
+	t.Parallel()
+	rootDirPath := t.TempDir()
+
+	if dnfVersion := detectDnfVersion(rootDirPath); dnfVersion != DnfVersion4 {
+		t.Errorf("expected dnf4 without dnf5 binary, got: %d", dnfVersion)
+	}
+
+	dnf5FilePath := filepath.Join(rootDirPath, dnf5BinaryFilePath)
+	err := os.MkdirAll(filepath.Dir(dnf5FilePath), 0755)
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err)
+	}
+	err = os.WriteFile(dnf5FilePath, []byte{}, 0755)
+	if err != nil {
+		t.Fatalf("unable to create dnf5 binary: %s", err)
+	}
+
+	if dnfVersion := detectDnfVersion(rootDirPath); dnfVersion != DnfVersion5 {
+		t.Errorf("expected dnf5 with dnf5 binary, got: %d", dnfVersion)
+	}
+}
+
+// Test_applyContentOverridesToRepoFile tests merging of content overrides into repo file
+func Test_applyContentOverridesToRepoFile(t *testing.T) {
+	t.Parallel()
+	file, err := ini.Load([]byte(`[foo]
+baseurl=https://cdn.example.com/foo
+enabled=0
+`))
+	if err != nil {
+		t.Fatalf("unable to parse repo file: %s", err)
+	}
+
+	applyContentOverridesToRepoFile(file, []ContentOverride{
+		{ContentLabel: "foo", Name: "enabled", Value: "1"},
+		{ContentLabel: "foo", Name: "priority", Value: "10"},
+		{ContentLabel: "foo", Name: "baseurl", Value: "https://evil.example.com/foo"},
+		{ContentLabel: "foo", Name: "SSLClientKey", Value: "/tmp/key.pem"},
+		{ContentLabel: "bar", Name: "enabled", Value: "1"},
+	})
+
+	expected := map[string]map[string]string{
+		"foo": {"baseurl": "https://cdn.example.com/foo", "enabled": "1", "priority": "10"},
+	}
+	if result := iniFileToMap(file); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected repo file: %v, got: %v", expected, result)
+	}
+}
@@ -1,10 +1,14 @@
 package rhsm2
 
 import (
+	"crypto/x509"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strconv"
 	"testing"
+
+	"gopkg.in/ini.v1"
 )
 
 // TestGetEngineeringProducts tests the case, when engineering products are
@@ -101,8 +105,16 @@ func TestGetEngineeringProductsError(t *testing.T) {
 // from entitlement certificate
 func TestGetContentFromEntCert(t *testing.T) {
 	t.Parallel()
-	filePath := "testdata/etc/pki/entitlement/" + testEntCertSerialNumber + ".pem"
-	engineeringProducts, err := getContentFromEntCertFile(&filePath)
+	testingFiles, err := setupTestingFileSystem(t.TempDir(), false, false, true, false, false)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+	ca, err := getTestingSigningCA()
+	if err != nil {
+		t.Fatalf("unable to get testing CA: %s", err)
+	}
+	filePath := filepath.Join(testingFiles.EntitlementDirPath, testEntCertSerialNumber+".pem")
+	engineeringProducts, err := getContentFromEntCertFile(&filePath, []*x509.Certificate{ca.cert})
 	if err != nil {
 		t.Fatalf("unable to get engineering products from file: %s: %s", filePath, err)
 	}
@@ -191,7 +203,7 @@ func TestWriteRepoFile(t *testing.T) {
 		t.Fatalf("unable to setup testing rhsm client: %s", err)
 	}
 
-	err = rhsmClient.generateRepoFileFromInstalledEntitlementCerts()
+	_, err = rhsmClient.generateRepoFileFromInstalledEntitlementCerts()
 
 	if err != nil {
 		t.Fatalf("unable to generate '%s': %s", testingFiles.YumRepoFilePath, err)
@@ -225,9 +237,87 @@ func TestWriteRepoFileNoEntCert(t *testing.T) {
 		t.Fatalf("unable to setup testing rhsm client: %s", err)
 	}
 
-	err = rhsmClient.generateRepoFileFromInstalledEntitlementCerts()
+	_, err = rhsmClient.generateRepoFileFromInstalledEntitlementCerts()
 
 	if err != nil {
 		t.Fatalf("when no entitlement certificate installed, error returned: %s", err)
 	}
 }
+
+// TestIsArchSupported test matching of content architectures with host architecture
+func TestIsArchSupported(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name          string
+		contentArches []string
+		hostArch      string
+		expected      bool
+	}{
+		{"no arches", nil, "x86_64", true},
+		{"same arch", []string{"x86_64"}, "x86_64", true},
+		{"different arch", []string{"x86_64"}, "aarch64", false},
+		{"one of arches", []string{"ppc64le", "s390x"}, "s390x", true},
+		{"compatible arch", []string{"i386"}, "x86_64", true},
+		{"not compatible arch", []string{"x86_64"}, "i686", false},
+		{"noarch", []string{"noarch"}, "aarch64", true},
+		{"all arches", []string{"ALL"}, "ppc64le", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if result := isArchSupported(tt.contentArches, tt.hostArch); result != tt.expected {
+				t.Errorf("isArchSupported(%v, %s) = %v, want %v", tt.contentArches, tt.hostArch, result, tt.expected)
+			}
+		})
+	}
+}
+
+// TestWriteRepoFileFiltered test that only yum content matching installed
+// products and architecture of host is written to repo file
+func TestWriteRepoFileFiltered(t *testing.T) {
+	t.Parallel()
+	tempDirFilePath := t.TempDir()
+
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, false, true, true, true, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	_, err = rhsmClient.generateRepoFileFromInstalledEntitlementCerts()
+	if err != nil {
+		t.Fatalf("unable to generate '%s': %s", testingFiles.YumRepoFilePath, err)
+	}
+
+	repoFile, err := ini.Load(testingFiles.YumRepoFilePath)
+	if err != nil {
+		t.Fatalf("unable to load repo file: %s", err)
+	}
+
+	// Installed products provide tag rhel-10
+	if !repoFile.HasSection("release-content-38090") {
+		t.Errorf("repository with required tag provided by installed product is missing")
+	}
+	for _, repoLabel := range []string{
+		// Content requires tags TAG1 and TAG2
+		"tagged-content-32060",
+		// Content of other types than yum
+		"awesomeos-deb-38070",
+		"awesomeos-docker-images-37090",
+		"awesomeos-ostree-37091",
+	} {
+		if repoFile.HasSection(repoLabel) {
+			t.Errorf("repository %s should not be written to repo file", repoLabel)
+		}
+	}
+
+	expected := hostArchitecture() == "x86_64"
+	if result := repoFile.HasSection("awesomeos-x86_64-only-content-213412341235"); result != expected {
+		t.Errorf("repository for x86_64 written to repo file: %v, expected: %v", result, expected)
+	}
+}
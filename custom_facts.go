@@ -0,0 +1,86 @@
+package rhsm2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultCustomFactsDirPath is the directory with custom facts. Every file
+// with the .facts suffix should contain JSON object with custom facts
+const DefaultCustomFactsDirPath = "/etc/rhsm/facts"
+
+// customFactsFileSuffix is the suffix of files with custom facts
+const customFactsFileSuffix = ".facts"
+
+// readCustomFactsFile tries to read one file with custom facts. The file has to
+// contain JSON object. Values, which are not strings, are converted to strings
+// using their JSON representation (e.g. 42, true or ["a","b"])
+func readCustomFactsFile(filePath string) (map[string]string, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]json.RawMessage
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	err = decoder.Decode(&values)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse JSON object: %s", err)
+	}
+	if values == nil {
+		return nil, fmt.Errorf("file does not contain JSON object")
+	}
+
+	facts := make(map[string]string, len(values))
+	for key, rawValue := range values {
+		var strValue string
+		err = json.Unmarshal(rawValue, &strValue)
+		if err != nil {
+			strValue = string(bytes.TrimSpace(rawValue))
+		}
+		facts[key] = strValue
+	}
+
+	return facts, nil
+}
+
+// readCustomFacts tries to read all files with custom facts from the given
+// directory. Files are read in lexical order and facts from later files
+// override facts from earlier files. Invalid files are skipped with warning.
+func readCustomFacts(logger *zerolog.Logger, dirPath string) map[string]string {
+	facts := make(map[string]string)
+
+	filePaths, err := filepath.Glob(filepath.Join(dirPath, "*"+customFactsFileSuffix))
+	if err != nil {
+		logger.Warn().Msgf("unable to list custom facts in %s: %s", dirPath, err)
+		return facts
+	}
+	sort.Strings(filePaths)
+
+	for _, filePath := range filePaths {
+		customFacts, err := readCustomFactsFile(filePath)
+		if err != nil {
+			logger.Warn().Msgf("skipping custom facts file %s: %s", filePath, err)
+			continue
+		}
+		logger.Debug().Msgf("loaded %d custom facts from %s", len(customFacts), filePath)
+		for key, value := range customFacts {
+			facts[key] = value
+		}
+	}
+
+	return facts
+}
+
+// SetCustomFactsDirPath sets the directory with custom facts (*.facts files). When
+// the directory is empty string, then custom facts are not used. DefaultCustomFactsDirPath
+// in RootDirPath is used by default.
+func (rhsmClient *RHSMClient) SetCustomFactsDirPath(dirPath string) {
+	rhsmClient.RHSMConf.customFactsDirPath = dirPath
+}
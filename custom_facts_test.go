@@ -0,0 +1,158 @@
+package rhsm2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+)
+
+// TestReadCustomFactsFile test parsing of one file with custom facts
+func TestReadCustomFactsFile(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name          string
+		filePath      string
+		expectedFacts map[string]string
+		wantErr       bool
+	}{
+		{
+			name:     "valid file with various types of values",
+			filePath: "./testdata/etc/rhsm/facts/10-site.facts",
+			expectedFacts: map[string]string{
+				"site.name":      "brno",
+				"site.rack":      "R12",
+				"cost_center":    "4711",
+				"uname.nodename": "node1.brno.example.com",
+			},
+		},
+		{
+			name:     "valid file with boolean value",
+			filePath: "./testdata/etc/rhsm/facts/20-cost-center.facts",
+			expectedFacts: map[string]string{
+				"cost_center":                "CC-1234",
+				"site.managed":               "true",
+				"system.certificate_version": "1.0",
+			},
+		},
+		{
+			name:     "corrupted JSON",
+			filePath: "./testdata/etc/rhsm/facts/30-invalid.facts",
+			wantErr:  true,
+		},
+		{
+			name:     "JSON array instead of object",
+			filePath: "./testdata/etc/rhsm/facts/40-not-object.facts",
+			wantErr:  true,
+		},
+		{
+			name:     "missing file",
+			filePath: "./testdata/etc/rhsm/facts/99-missing.facts",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			facts, err := readCustomFactsFile(tt.filePath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("%s: readCustomFactsFile() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(facts) != len(tt.expectedFacts) {
+				t.Errorf("%s: readCustomFactsFile() returned %d facts, expected %d: %v",
+					tt.name, len(facts), len(tt.expectedFacts), facts)
+			}
+			for key, expectedValue := range tt.expectedFacts {
+				if facts[key] != expectedValue {
+					t.Errorf("%s: fact %s = %s, expected %s", tt.name, key, facts[key], expectedValue)
+				}
+			}
+		})
+	}
+}
+
+// TestReadCustomFacts test that all valid *.facts files are merged in lexical
+// order and invalid files are skipped
+func TestReadCustomFacts(t *testing.T) {
+	t.Parallel()
+	facts := readCustomFacts(&log.Logger, "./testdata/etc/rhsm/facts")
+
+	expectedFacts := map[string]string{
+		"site.name":                  "brno",
+		"site.rack":                  "R12",
+		"site.managed":               "true",
+		"cost_center":                "CC-1234",
+		"uname.nodename":             "node1.brno.example.com",
+		"system.certificate_version": "1.0",
+	}
+	if len(facts) != len(expectedFacts) {
+		t.Errorf("readCustomFacts() returned %d facts, expected %d: %v",
+			len(facts), len(expectedFacts), facts)
+	}
+	for key, expectedValue := range expectedFacts {
+		if facts[key] != expectedValue {
+			t.Errorf("fact %s = %s, expected %s", key, facts[key], expectedValue)
+		}
+	}
+}
+
+// TestReadCustomFactsMissingDir test that missing directory with custom
+// facts is not an error
+func TestReadCustomFactsMissingDir(t *testing.T) {
+	t.Parallel()
+	facts := readCustomFacts(&log.Logger, filepath.Join(t.TempDir(), "missing"))
+	if len(facts) != 0 {
+		t.Errorf("readCustomFacts() returned facts for missing directory: %v", facts)
+	}
+}
+
+// TestCollectFactsCustomFactsPrecedence test that custom facts override collected
+// facts, but system.certificate_version cannot be overridden
+func TestCollectFactsCustomFactsPrecedence(t *testing.T) {
+	t.Parallel()
+	tempDirFilePath := t.TempDir()
+
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, true, false, false, false, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	customFactsDirPath := filepath.Join(tempDirFilePath, "etc/rhsm/facts")
+	err = os.MkdirAll(customFactsDirPath, 0755)
+	if err != nil {
+		t.Fatalf("unable to create directory for custom facts: %s", err)
+	}
+	err = os.WriteFile(
+		filepath.Join(customFactsDirPath, "memory.facts"),
+		[]byte(`{"memory.memtotal": "1024", "system.certificate_version": "1.0"}`),
+		0644,
+	)
+	if err != nil {
+		t.Fatalf("unable to write custom facts: %s", err)
+	}
+	rhsmClient.SetCustomFactsDirPath(customFactsDirPath)
+
+	facts := rhsmClient.collectFacts()
+
+	if facts["memory.memtotal"] != "1024" {
+		t.Errorf("custom fact memory.memtotal should override collected fact, got: %s",
+			facts["memory.memtotal"])
+	}
+	if facts["cpu.cpu(s)"] != "4" {
+		t.Errorf("collected fact cpu.cpu(s) should be kept, got: %s", facts["cpu.cpu(s)"])
+	}
+	if facts["system.certificate_version"] != systemCertificateVersion {
+		t.Errorf("system.certificate_version cannot be overridden by custom facts, got: %s",
+			facts["system.certificate_version"])
+	}
+}
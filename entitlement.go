@@ -1,15 +1,19 @@
 package rhsm2
 
 import (
+	"context"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
 // EntitlementCertificateKeyJSON is structure used for un-marshaling of JSON returned from candlepin server.
@@ -37,6 +41,29 @@ type EntitlementCertificateKey struct {
 
 // getInstalledEntitlementCertificateKeys retrieves a map of installed entitlement certificate keys and paths or an error.
 func (rhsmClient *RHSMClient) getInstalledEntitlementCertificateKeys() (map[int64]EntitlementCertificateKey, error) {
+	installedCertKeys, err := rhsmClient.getEntitlementCertificateKeyFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove entries without a certificate or key
+	for serial, certKey := range installedCertKeys {
+		if certKey.KeyPath == nil {
+			rhsmClient.getLogger().Debug().Msgf("key is missing, removing serial: %d from the list", serial)
+			delete(installedCertKeys, serial)
+		}
+		if certKey.CertPath == nil {
+			rhsmClient.getLogger().Debug().Msgf("cert is missing, removing serial: %d from the list", serial)
+			delete(installedCertKeys, serial)
+		}
+	}
+
+	return installedCertKeys, nil
+}
+
+// getEntitlementCertificateKeyFiles retrieves a map of all entitlement certificate and key files
+// in the entitlement certificate directory. Entries could contain only a certificate or only a key.
+func (rhsmClient *RHSMClient) getEntitlementCertificateKeyFiles() (map[int64]EntitlementCertificateKey, error) {
 	var installedCertKeys = make(map[int64]EntitlementCertificateKey)
 
 	entCertDirPath := rhsmClient.RHSMConf.RHSM.EntitlementCertDir
@@ -54,7 +81,7 @@ func (rhsmClient *RHSMClient) getInstalledEntitlementCertificateKeys() (map[int6
 			serialNumberStr := strings.TrimSuffix(fileName, "-key.pem")
 			serialNumber, err := strconv.ParseInt(serialNumberStr, 10, 64)
 			if err != nil {
-				log.Debug().Msgf("failed to parse serial number from file name: %s", fileName)
+				rhsmClient.getLogger().Debug().Msgf("failed to parse serial number from file name: %s", fileName)
 				continue
 			}
 			if entry, exist := installedCertKeys[serialNumber]; exist {
@@ -70,7 +97,7 @@ func (rhsmClient *RHSMClient) getInstalledEntitlementCertificateKeys() (map[int6
 			serialNumberStr := strings.TrimSuffix(fileName, ".pem")
 			serialNumber, err := strconv.ParseInt(serialNumberStr, 10, 64)
 			if err != nil {
-				log.Debug().Msgf("failed to parse serial number from file name: %s", fileName)
+				rhsmClient.getLogger().Debug().Msgf("failed to parse serial number from file name: %s", fileName)
 				continue
 			}
 			if entry, exist := installedCertKeys[serialNumber]; exist {
@@ -85,18 +112,6 @@ func (rhsmClient *RHSMClient) getInstalledEntitlementCertificateKeys() (map[int6
 		}
 	}
 
-	// Remove entries without a certificate or key
-	for serial, certKey := range installedCertKeys {
-		if certKey.KeyPath == nil {
-			log.Debug().Msgf("key is missing, removing serial: %d from the list", serial)
-			delete(installedCertKeys, serial)
-		}
-		if certKey.CertPath == nil {
-			log.Debug().Msgf("cert is missing, removing serial: %d from the list", serial)
-			delete(installedCertKeys, serial)
-		}
-	}
-
 	return installedCertKeys, nil
 }
 
@@ -104,7 +119,10 @@ func (rhsmClient *RHSMClient) getInstalledEntitlementCertificateKeys() (map[int6
 // When it is possible to get entitlement certificate(s), then write these certificate(s) to file.
 // Note: candlepin server returns only one SCA entitlement certificate ATM, but REST API allows to
 // return more entitlement certificates.
-func (rhsmClient *RHSMClient) getSCAEntitlementCertificates(metadata *RequestMetadata) ([]EntitlementCertificateKeyJSON, error) {
+func (rhsmClient *RHSMClient) getSCAEntitlementCertificates(
+	ctx context.Context,
+	metadata *RequestMetadata,
+) ([]EntitlementCertificateKeyJSON, error) {
 	consumerUuid, err := rhsmClient.GetConsumerUUID()
 
 	if err != nil {
@@ -118,6 +136,7 @@ func (rhsmClient *RHSMClient) getSCAEntitlementCertificates(metadata *RequestMet
 		return nil, fmt.Errorf("unable to get consumer cert auth connection: %v", err)
 	}
 	res, err := connection.request(
+		ctx,
 		rhsmClient.UserAgent,
 		http.MethodGet,
 		"consumers/"+*consumerUuid+"/certificates",
@@ -131,6 +150,10 @@ func (rhsmClient *RHSMClient) getSCAEntitlementCertificates(metadata *RequestMet
 		return nil, fmt.Errorf("getting entitlement certificates failed: %s", err)
 	}
 
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("getting entitlement certificates failed: %w", newCandlepinError(res))
+	}
+
 	resBody, err := getResponseBody(res)
 	if err != nil {
 		return nil, err
@@ -152,43 +175,446 @@ func (rhsmClient *RHSMClient) getSCAEntitlementCertificates(metadata *RequestMet
 			return nil, fmt.Errorf("no SCA entitlement certificate returned from server")
 		}
 		if l > 0 {
-			log.Warn().Msgf("more than one SCA (%d) entitlement certificates installed", l)
+			rhsmClient.getLogger().Warn().Msgf("more than one SCA (%d) entitlement certificates installed", l)
 		}
 	}
 
 	// Write certificate(s) and key(s) to file(s)
+	rhsmClient.writeEntitlementCertificateKeys(entCertKeys)
+
+	return entCertKeys, nil
+}
+
+// writeEntitlementCertificateKeys tries to write entitlement certificates and keys
+// to files. It returns the list of serial numbers of installed certificates.
+func (rhsmClient *RHSMClient) writeEntitlementCertificateKeys(entCertKeys []EntitlementCertificateKeyJSON) []int64 {
+	var installedSerials []int64
 	for _, entCertKey := range entCertKeys {
 		entCertFilePath, err := rhsmClient.writeEntitlementCert(&entCertKey.Cert, entCertKey.Serial.Serial)
 		if err != nil {
-			log.Error().Msgf("unable to install entitlement certificate: %s", err)
+			rhsmClient.getLogger().Error().Msgf("unable to install entitlement certificate: %s", err)
 			continue
 		}
 		_, err = rhsmClient.writeEntitlementKey(&entCertKey.Key, entCertKey.Serial.Serial)
 		if err != nil {
-			log.Error().Msgf("unable to write entitlement key: %s", err)
+			rhsmClient.getLogger().Error().Msgf("unable to write entitlement key: %s", err)
 
 			// When it is not possible to install key, then remove certificate file, because
 			// certificate is useless without key
 			err = os.Remove(*entCertFilePath)
 			if err != nil {
-				log.Error().Msgf("unable to remove entitlement certificate: %s", err)
+				rhsmClient.getLogger().Error().Msgf("unable to remove entitlement certificate: %s", err)
 			}
+			continue
 		}
+		installedSerials = append(installedSerials, entCertKey.Serial.Serial)
 	}
-
-	return entCertKeys, nil
+	return installedSerials
 }
 
 // writeEntitlementCert tries to write entitlement certificate. It is
 // typically /etc/pki/entitlement/<serial_number>.pem
 func (rhsmClient *RHSMClient) writeEntitlementCert(entCert *string, serialNum int64) (*string, error) {
 	entCertFilePath := rhsmClient.entCertPath(serialNum)
-	return entCertFilePath, writePemFile(entCertFilePath, entCert, nil)
+	return entCertFilePath, writePemFile(rhsmClient.getLogger(), entCertFilePath, entCert, rhsmClient.getFilePermissions().Cert)
 }
 
 // writeEntitlementCert tries to write entitlement certificate. It is
 // typically /etc/pki/entitlement/<serial_number>-key.pem
 func (rhsmClient *RHSMClient) writeEntitlementKey(entKey *string, serialNum int64) (*string, error) {
 	entKeyFilePath := rhsmClient.entKeyPath(serialNum)
-	return entKeyFilePath, writePemFile(entKeyFilePath, entKey, nil)
+	return entKeyFilePath, writePemFile(rhsmClient.getLogger(), entKeyFilePath, entKey, rhsmClient.getFilePermissions().Key)
+}
+
+// EntitlementSerialJSON is structure used for un-marshaling of JSON document with
+// serial numbers of entitlement certificates returned from candlepin server
+type EntitlementSerialJSON struct {
+	Id         int64  `json:"id"`
+	Serial     int64  `json:"serial"`
+	Expiration string `json:"expiration"`
+	Revoked    bool   `json:"revoked"`
+}
+
+// EntitlementCertificatesReport is structure with the result of refreshing
+// entitlement certificates
+type EntitlementCertificatesReport struct {
+	// Added is the list of serial numbers of newly installed certificates
+	Added []int64 `json:"added"`
+	// Removed is the list of serial numbers of removed certificates
+	Removed []int64 `json:"removed"`
+	// RepoFileRegenerated is true, when redhat.repo was regenerated
+	RepoFileRegenerated bool `json:"repoFileRegenerated"`
+	// RepoFileDiff is the report of changes in redhat.repo, when it was regenerated
+	RepoFileDiff *RepoFileDiff `json:"repoFileDiff,omitempty"`
+}
+
+// Changed returns true, when the set of installed entitlement certificates changed
+func (report *EntitlementCertificatesReport) Changed() bool {
+	return len(report.Added) > 0 || len(report.Removed) > 0
+}
+
+// getEntitlementCertificateSerials tries to get serial numbers of entitlement
+// certificates, which the candlepin server considers as valid for the consumer.
+// Revoked certificates are not included in the list.
+func (rhsmClient *RHSMClient) getEntitlementCertificateSerials(
+	ctx context.Context,
+	consumerUuid *string,
+	metadata *RequestMetadata,
+) ([]int64, error) {
+	var headers = make(map[string]string)
+
+	connection, err := rhsmClient.getCertAuthConnection()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get consumer cert auth connection: %v", err)
+	}
+	res, err := connection.request(
+		ctx,
+		rhsmClient.UserAgent,
+		http.MethodGet,
+		"consumers/"+*consumerUuid+"/certificates/serials",
+		"",
+		"",
+		&headers,
+		nil,
+		metadata)
+	if err != nil {
+		return nil, fmt.Errorf("getting serials of entitlement certificates failed: %s", err)
+	}
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("getting serials of entitlement certificates failed: %w", newCandlepinError(res))
+	}
+
+	resBody, err := getResponseBody(res)
+	if err != nil {
+		return nil, err
+	}
+
+	var entSerials []EntitlementSerialJSON
+	err = json.Unmarshal([]byte(*resBody), &entSerials)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse serials of entitlement certificates: %s", err)
+	}
+
+	var serials []int64
+	for _, entSerial := range entSerials {
+		if entSerial.Revoked {
+			rhsmClient.getLogger().Debug().Msgf("skipping revoked entitlement certificate: %d", entSerial.Serial)
+			continue
+		}
+		serials = append(serials, entSerial.Serial)
+	}
+
+	return serials, nil
+}
+
+// getEntitlementCertificatesBySerials tries to get entitlement certificates and keys
+// with given serial numbers from candlepin server
+func (rhsmClient *RHSMClient) getEntitlementCertificatesBySerials(
+	ctx context.Context,
+	consumerUuid *string,
+	serials []int64,
+	metadata *RequestMetadata,
+) ([]EntitlementCertificateKeyJSON, error) {
+	var headers = make(map[string]string)
+
+	strSerials := make([]string, 0, len(serials))
+	for _, serial := range serials {
+		strSerials = append(strSerials, strconv.FormatInt(serial, 10))
+	}
+	query := "serials=" + strings.Join(strSerials, ",")
+
+	connection, err := rhsmClient.getCertAuthConnection()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get consumer cert auth connection: %v", err)
+	}
+	res, err := connection.request(
+		ctx,
+		rhsmClient.UserAgent,
+		http.MethodGet,
+		"consumers/"+*consumerUuid+"/certificates",
+		query,
+		"",
+		&headers,
+		nil,
+		metadata)
+	if err != nil {
+		return nil, fmt.Errorf("getting entitlement certificates failed: %s", err)
+	}
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("getting entitlement certificates failed: %w", newCandlepinError(res))
+	}
+
+	resBody, err := getResponseBody(res)
+	if err != nil {
+		return nil, err
+	}
+
+	var entCertKeys []EntitlementCertificateKeyJSON
+	err = json.Unmarshal([]byte(*resBody), &entCertKeys)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse entitlement certificates: %s", err)
+	}
+
+	return entCertKeys, nil
+}
+
+// removeEntitlementCertificateKey tries to remove installed entitlement certificate and key
+func removeEntitlementCertificateKey(logger *zerolog.Logger, certKey *EntitlementCertificateKey) error {
+	var removeErr error
+	for _, filePath := range []*string{certKey.CertPath, certKey.KeyPath} {
+		if filePath == nil {
+			continue
+		}
+		logger.Debug().Msgf("removing %s", *filePath)
+		err := os.Remove(*filePath)
+		if err != nil && !os.IsNotExist(err) {
+			removeErr = fmt.Errorf("unable to remove %s: %s", *filePath, err)
+		}
+	}
+	return removeErr
+}
+
+// RefreshEntitlementCertificates tries to synchronize installed entitlement certificates
+// with the candlepin server. Only missing certificates are downloaded and certificates
+// that are not listed by the server anymore are removed. The redhat.repo file is
+// regenerated only, when the set of installed certificates changed.
+func (rhsmClient *RHSMClient) RefreshEntitlementCertificates(
+	metadata *RequestMetadata,
+) (*EntitlementCertificatesReport, error) {
+	return rhsmClient.RefreshEntitlementCertificatesWithContext(context.Background(), metadata)
+}
+
+// RefreshEntitlementCertificatesWithContext is like RefreshEntitlementCertificates, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) RefreshEntitlementCertificatesWithContext(
+	ctx context.Context,
+	metadata *RequestMetadata,
+) (*EntitlementCertificatesReport, error) {
+	report := &EntitlementCertificatesReport{
+		Added:   []int64{},
+		Removed: []int64{},
+	}
+
+	consumerUuid, err := rhsmClient.GetConsumerUUID()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get consumer uuid: %v", err)
+	}
+
+	metadata = sanitizeMetadata(metadata)
+
+	// Incomplete pairs of certificate and key are not considered as installed,
+	// but their files are removed, when the serial is not listed by the server
+	entCertKeyFiles, err := rhsmClient.getEntitlementCertificateKeyFiles()
+	if err != nil {
+		return nil, err
+	}
+	installedCertKeys := make(map[int64]EntitlementCertificateKey, len(entCertKeyFiles))
+	for serial, certKey := range entCertKeyFiles {
+		if certKey.CertPath != nil && certKey.KeyPath != nil {
+			installedCertKeys[serial] = certKey
+		}
+	}
+
+	serverSerials, err := rhsmClient.getEntitlementCertificateSerials(ctx, consumerUuid, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find serials of missing certificates
+	var missingSerials []int64
+	serverSerialsSet := make(map[int64]struct{}, len(serverSerials))
+	for _, serial := range serverSerials {
+		serverSerialsSet[serial] = struct{}{}
+		if _, exists := installedCertKeys[serial]; !exists {
+			missingSerials = append(missingSerials, serial)
+		}
+	}
+
+	// Download and install missing certificates
+	if len(missingSerials) > 0 {
+		entCertKeys, err := rhsmClient.getEntitlementCertificatesBySerials(ctx, consumerUuid, missingSerials, metadata)
+		if err != nil {
+			return nil, err
+		}
+		report.Added = append(report.Added, rhsmClient.writeEntitlementCertificateKeys(entCertKeys)...)
+	}
+
+	// Remove stale certificates and keys
+	for serial, certKey := range entCertKeyFiles {
+		if _, exists := serverSerialsSet[serial]; exists {
+			continue
+		}
+		err = removeEntitlementCertificateKey(rhsmClient.getLogger(), &certKey)
+		if err != nil {
+			rhsmClient.getLogger().Error().Msgf("unable to remove entitlement certificate %d: %s", serial, err)
+			continue
+		}
+		// Incomplete pair was not installed entitlement certificate
+		if _, installed := installedCertKeys[serial]; !installed {
+			rhsmClient.getLogger().Debug().Msgf("incomplete pair of entitlement certificate and key %d removed", serial)
+			continue
+		}
+		report.Removed = append(report.Removed, serial)
+	}
+
+	sort.Slice(report.Added, func(i, j int) bool { return report.Added[i] < report.Added[j] })
+	sort.Slice(report.Removed, func(i, j int) bool { return report.Removed[i] < report.Removed[j] })
+
+	if !report.Changed() {
+		rhsmClient.getLogger().Debug().Msg("entitlement certificates not changed, skipping regeneration of repo file")
+		return report, nil
+	}
+
+	rhsmClient.getLogger().Info().Msgf("entitlement certificates refreshed, added: %v, removed: %v",
+		report.Added, report.Removed)
+
+	// Connection to CDN could use removed entitlement certificate
+	rhsmClient.invalidateEntitlementCertAuthConnection()
+
+	repoFileDiff, err := rhsmClient.generateRepoFileFromInstalledEntitlementCerts()
+	if err != nil {
+		return report, fmt.Errorf("unable to write repo file: %s: %s",
+			rhsmClient.RHSMConf.yumRepoFilePath, err)
+	}
+	report.RepoFileRegenerated = true
+	report.RepoFileDiff = repoFileDiff
+
+	// Install other types of content. The redhat.repo file is already regenerated.
+	// Thus, the error is only logged like errors of content handlers
+	engineeringProductsMap, err := rhsmClient.getEngineeringProducts()
+	if err != nil {
+		rhsmClient.getLogger().Error().Msgf("unable to update content: %s", err)
+		return report, nil
+	}
+	rhsmClient.updateContent(engineeringProductsMap)
+
+	return report, nil
+}
+
+// EntitlementCertificate is structure with information about one installed entitlement
+// certificate. It contains information from X.509 certificate and data decoded from
+// the block "ENTITLEMENT DATA"
+type EntitlementCertificate struct {
+	// Serial is the serial number of the certificate
+	Serial int64 `json:"serial"`
+	// FilePath is the file path of the certificate
+	FilePath string `json:"filePath"`
+	// KeyFilePath is the file path of the matching key
+	KeyFilePath string `json:"keyFilePath"`
+	// KeyExists is true, when the matching key is installed
+	KeyExists bool `json:"keyExists"`
+	// Subject is the subject of the certificate
+	Subject string `json:"subject"`
+	// Issuer is the issuer of the certificate
+	Issuer string `json:"issuer"`
+	// NotBefore is the start of validity of the certificate
+	NotBefore time.Time `json:"notBefore"`
+	// NotAfter is the end of validity of the certificate
+	NotAfter time.Time `json:"notAfter"`
+	// SignatureValid is true, when the signature of entitlement data is valid. When
+	// it is not valid, then the entitlement data are not decoded, because they could be tampered
+	SignatureValid bool `json:"signatureValid"`
+	// Consumer is the UUID of consumer
+	Consumer string `json:"consumer"`
+	// Subscription is information about subscription
+	Subscription EntitlementSubscription `json:"subscription"`
+	// Order is information about order
+	Order EntitlementOrder `json:"order"`
+	// Products is the list of engineering products including their content
+	Products []EngineeringProduct `json:"products"`
+}
+
+// readEntitlementCertificate tries to read information about entitlement certificate
+// from given file. The signature of entitlement data is verified using given CA certificates.
+func readEntitlementCertificate(
+	logger *zerolog.Logger,
+	filePath string,
+	caCerts []*x509.Certificate,
+) (*EntitlementCertificate, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read entitlement certificate: %s, %s", filePath, err)
+	}
+
+	cert, err := parsePEMCertificate(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse entitlement certificate: %s, %s", filePath, err)
+	}
+
+	entCert := &EntitlementCertificate{
+		Serial:    cert.SerialNumber.Int64(),
+		FilePath:  filePath,
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		Products:  []EngineeringProduct{},
+	}
+
+	content := string(data)
+	entitlementContents, err := getEntitlementContentsFromEntCert(&content, caCerts)
+	if err != nil {
+		if IsInvalidEntitlementSignature(err) {
+			logger.Warn().Msgf("%s: %s", filePath, err)
+			return entCert, nil
+		}
+		return nil, err
+	}
+
+	entCert.SignatureValid = true
+	for i, entitlementContent := range entitlementContents {
+		if i == 0 {
+			entCert.Consumer = entitlementContent.Consumer
+			entCert.Subscription = entitlementContent.Subscription
+			entCert.Order = entitlementContent.Order
+		}
+		entCert.Products = append(entCert.Products, entitlementContent.Products...)
+	}
+
+	return entCert, nil
+}
+
+// ListEntitlementCertificates tries to list all installed entitlement certificates
+// (typically /etc/pki/entitlement/<serial>.pem). The list is sorted by serial
+// numbers. Files, which cannot be parsed, are skipped.
+func (rhsmClient *RHSMClient) ListEntitlementCertificates() ([]EntitlementCertificate, error) {
+	entCertDirPath := rhsmClient.RHSMConf.RHSM.EntitlementCertDir
+	entCertFiles, err := os.ReadDir(entCertDirPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read content of %s: %s", entCertDirPath, err)
+	}
+
+	caCerts := rhsmClient.loadCACertificates()
+
+	entCerts := make([]EntitlementCertificate, 0)
+	for _, file := range entCertFiles {
+		fileName := file.Name()
+		if !strings.HasSuffix(fileName, ".pem") || strings.HasSuffix(fileName, "-key.pem") {
+			continue
+		}
+		serialNumber, err := strconv.ParseInt(strings.TrimSuffix(fileName, ".pem"), 10, 64)
+		if err != nil {
+			rhsmClient.getLogger().Debug().Msgf("failed to parse serial number from file name: %s", fileName)
+			continue
+		}
+
+		entCert, err := readEntitlementCertificate(rhsmClient.getLogger(), filepath.Join(entCertDirPath, fileName), caCerts)
+		if err != nil {
+			rhsmClient.getLogger().Warn().Msgf("skipping entitlement certificate: %s", err)
+			continue
+		}
+
+		entCert.KeyFilePath = *rhsmClient.entKeyPath(serialNumber)
+		if _, err := os.Stat(entCert.KeyFilePath); err == nil {
+			entCert.KeyExists = true
+		}
+
+		entCerts = append(entCerts, *entCert)
+	}
+
+	sort.Slice(entCerts, func(i, j int) bool { return entCerts[i].Serial < entCerts[j].Serial })
+
+	return entCerts, nil
 }
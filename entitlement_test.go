@@ -1,10 +1,12 @@
 package rhsm2
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 )
 
@@ -232,7 +234,7 @@ func TestGetEntitlementCertificate(t *testing.T) {
 		t.Fatalf("unable to setup testing rhsm client: %s", err)
 	}
 
-	entCertKeys, err := rhsmClient.getSCAEntitlementCertificates(nil)
+	entCertKeys, err := rhsmClient.getSCAEntitlementCertificates(context.Background(), nil)
 	if err != nil {
 		t.Fatalf("failed to get SCA entitlement cert and key: %s", err)
 	}
@@ -324,7 +326,7 @@ func TestGetEntitlementCertificateWrongConsumerUUID(t *testing.T) {
 		t.Fatalf("unable to setup testing rhsm client: %s", err)
 	}
 
-	_, err = rhsmClient.getSCAEntitlementCertificates(nil)
+	_, err = rhsmClient.getSCAEntitlementCertificates(context.Background(), nil)
 	if err == nil {
 		t.Fatalf("no error raised, when server responses with 404 status code")
 	}
@@ -381,7 +383,7 @@ func TestGetEntitlementCertificateDeletedConsumerUUID(t *testing.T) {
 		t.Fatalf("unable to setup testing rhsm client: %s", err)
 	}
 
-	_, err = rhsmClient.getSCAEntitlementCertificates(nil)
+	_, err = rhsmClient.getSCAEntitlementCertificates(context.Background(), nil)
 	if err == nil {
 		t.Fatalf("no error raised, when server responses with 410 status code")
 	}
@@ -438,8 +440,204 @@ func TestGetEntitlementCertificateInternalServerError(t *testing.T) {
 		t.Fatalf("unable to setup testing rhsm client: %s", err)
 	}
 
-	_, err = rhsmClient.getSCAEntitlementCertificates(nil)
+	_, err = rhsmClient.getSCAEntitlementCertificates(context.Background(), nil)
 	if err == nil {
 		t.Fatalf("no error raised, when server responses with 410 status code")
 	}
 }
+
+// TestRefreshEntitlementCertificates test that only missing entitlement certificates
+// are downloaded, stale certificates are removed and redhat.repo is regenerated
+// only, when the set of certificates changed
+func TestRefreshEntitlementCertificates(t *testing.T) {
+	t.Parallel()
+	var expectedClientUUID = "5e9745d5-624d-4af1-916e-2c17df4eb4e8"
+	handlerCounterSerials := 0
+	handlerCounterCertificates := 0
+	signedEntCertKeyList, err := signEntCertKeyList(entCertKeyList)
+	if err != nil {
+		t.Fatalf("unable to sign entitlement certificates: %s", err)
+	}
+
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodGet {
+				t.Fatalf("extepected request method: %s, got: %s", http.MethodGet, req.Method)
+			}
+
+			reqURL := req.URL.String()
+			switch reqURL {
+			case "/consumers/" + expectedClientUUID + "/certificates/serials":
+				handlerCounterSerials += 1
+				rw.WriteHeader(200)
+				_, _ = rw.Write([]byte(`[
+  {"id": 2150990815908364188, "serial": 2150990815908364188, "revoked": false},
+  {"id": 1234, "serial": 1234, "revoked": true}
+]`))
+			case "/consumers/" + expectedClientUUID + "/certificates?serials=2150990815908364188":
+				handlerCounterCertificates += 1
+				rw.WriteHeader(200)
+				_, _ = rw.Write(signedEntCertKeyList)
+			default:
+				t.Fatalf("unexpected REST API call: %s %s", req.Method, reqURL)
+			}
+		}))
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+
+	// Old entitlement certificate is installed
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, false, true, true, false, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	// Certificate without key and key without certificate are not listed by the server
+	orphanFilePaths := []string{
+		filepath.Join(testingFiles.EntitlementDirPath, "111.pem"),
+		filepath.Join(testingFiles.EntitlementDirPath, "222-key.pem"),
+	}
+	for _, orphanFilePath := range orphanFilePaths {
+		err = os.WriteFile(orphanFilePath, []byte("orphan"), 0644)
+		if err != nil {
+			t.Fatalf("unable to write %s: %s", orphanFilePath, err)
+		}
+	}
+
+	report, err := rhsmClient.RefreshEntitlementCertificates(nil)
+	if err != nil {
+		t.Fatalf("RefreshEntitlementCertificates() returned error: %s", err)
+	}
+	for _, orphanFilePath := range orphanFilePaths {
+		if _, err := os.Stat(orphanFilePath); !os.IsNotExist(err) {
+			t.Errorf("incomplete pair of entitlement certificate and key was not removed: %s", orphanFilePath)
+		}
+	}
+
+	if len(report.Added) != 1 || report.Added[0] != 2150990815908364188 {
+		t.Errorf("expected added serials: [2150990815908364188], got: %v", report.Added)
+	}
+	if len(report.Removed) != 1 || report.Removed[0] != 4709416649487329566 {
+		t.Errorf("expected removed serials: [4709416649487329566], got: %v", report.Removed)
+	}
+	if !report.RepoFileRegenerated {
+		t.Errorf("redhat.repo should be regenerated, when certificates changed")
+	}
+	if handlerCounterSerials != 1 || handlerCounterCertificates != 1 {
+		t.Fatalf("unexpected number of REST API calls: serials: %d, certificates: %d",
+			handlerCounterSerials, handlerCounterCertificates)
+	}
+
+	installedCertKeys, err := rhsmClient.getInstalledEntitlementCertificateKeys()
+	if err != nil {
+		t.Fatalf("unable to get installed entitlement certificates: %s", err)
+	}
+	if len(installedCertKeys) != 1 {
+		t.Fatalf("expected one installed entitlement certificate, got: %d", len(installedCertKeys))
+	}
+	if _, exists := installedCertKeys[2150990815908364188]; !exists {
+		t.Errorf("new entitlement certificate is not installed")
+	}
+
+	repoFileContent, err := os.ReadFile(testingFiles.YumRepoFilePath)
+	if err != nil {
+		t.Fatalf("unable to read %s: %s", testingFiles.YumRepoFilePath, err)
+	}
+	if len(repoFileContent) == 0 {
+		t.Errorf("redhat.repo was not regenerated")
+	}
+
+	// Nothing changed on the server. Thus, no certificate should be downloaded
+	report, err = rhsmClient.RefreshEntitlementCertificates(nil)
+	if err != nil {
+		t.Fatalf("RefreshEntitlementCertificates() returned error: %s", err)
+	}
+	if report.Changed() || report.RepoFileRegenerated {
+		t.Errorf("no change expected, got: %+v", report)
+	}
+	if handlerCounterSerials != 2 || handlerCounterCertificates != 1 {
+		t.Fatalf("unexpected number of REST API calls: serials: %d, certificates: %d",
+			handlerCounterSerials, handlerCounterCertificates)
+	}
+}
+
+// TestListEntitlementCertificates test listing of installed entitlement certificates
+func TestListEntitlementCertificates(t *testing.T) {
+	t.Parallel()
+	testingFiles, err := setupTestingFileSystem(t.TempDir(), false, true, true, true, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	// Tampered entitlement certificate without key and file, which is not a certificate
+	blocks := readTestingEntCertBlocks(t)
+	tamperedData := tamperEntitlementData(t, blocks["ENTITLEMENT DATA"].Bytes)
+	tamperedEntCert := encodeEntCert(blocks["CERTIFICATE"], tamperedData, blocks["RSA SIGNATURE"].Bytes)
+	err = os.WriteFile(filepath.Join(testingFiles.EntitlementDirPath, "1.pem"), []byte(tamperedEntCert), 0644)
+	if err != nil {
+		t.Fatalf("unable to write entitlement certificate: %s", err)
+	}
+	err = os.WriteFile(filepath.Join(testingFiles.EntitlementDirPath, "2.pem"), []byte("not a certificate"), 0644)
+	if err != nil {
+		t.Fatalf("unable to write entitlement certificate: %s", err)
+	}
+
+	entCerts, err := rhsmClient.ListEntitlementCertificates()
+	if err != nil {
+		t.Fatalf("unable to list entitlement certificates: %s", err)
+	}
+	if len(entCerts) != 2 {
+		t.Fatalf("expected 2 entitlement certificates, got: %d", len(entCerts))
+	}
+
+	var validEntCert, tamperedEntCertInfo *EntitlementCertificate
+	for i := range entCerts {
+		if entCerts[i].FilePath == filepath.Join(testingFiles.EntitlementDirPath, "1.pem") {
+			tamperedEntCertInfo = &entCerts[i]
+		} else {
+			validEntCert = &entCerts[i]
+		}
+	}
+	if validEntCert == nil || tamperedEntCertInfo == nil {
+		t.Fatalf("unexpected entitlement certificates: %v", entCerts)
+	}
+
+	if strconv.FormatInt(validEntCert.Serial, 10) != testEntCertSerialNumber {
+		t.Errorf("expected serial: %s, got: %d", testEntCertSerialNumber, validEntCert.Serial)
+	}
+	if !validEntCert.KeyExists {
+		t.Errorf("key of entitlement certificate %s not found", validEntCert.FilePath)
+	}
+	if !validEntCert.SignatureValid {
+		t.Errorf("signature of entitlement certificate %s is not valid", validEntCert.FilePath)
+	}
+	if validEntCert.Subscription.Sku != "content_access" {
+		t.Errorf("unexpected subscription: %v", validEntCert.Subscription)
+	}
+	if validEntCert.Order.Start.IsZero() || validEntCert.NotAfter.IsZero() {
+		t.Errorf("validity of entitlement certificate not decoded")
+	}
+	if len(validEntCert.Products) == 0 || len(validEntCert.Products[0].Content) == 0 {
+		t.Errorf("products of entitlement certificate not decoded")
+	}
+
+	if tamperedEntCertInfo.KeyExists {
+		t.Errorf("key of entitlement certificate %s should not exist", tamperedEntCertInfo.FilePath)
+	}
+	if tamperedEntCertInfo.SignatureValid {
+		t.Errorf("signature of tampered entitlement certificate is valid")
+	}
+	if len(tamperedEntCertInfo.Products) != 0 {
+		t.Errorf("products of tampered entitlement certificate were decoded")
+	}
+}
@@ -1,6 +1,7 @@
 package rhsm2
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -35,6 +36,18 @@ func (rhsmClient *RHSMClient) GetEnvironments(
 	password string,
 	organization string,
 	metadata *RequestMetadata,
+) ([]Environment, error) {
+	return rhsmClient.GetEnvironmentsWithContext(context.Background(), username, password, organization, metadata)
+}
+
+// GetEnvironmentsWithContext is like GetEnvironments, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) GetEnvironmentsWithContext(
+	ctx context.Context,
+	username string,
+	password string,
+	organization string,
+	metadata *RequestMetadata,
 ) ([]Environment, error) {
 	var environments []Environment
 	var headers = make(map[string]string)
@@ -49,6 +62,7 @@ func (rhsmClient *RHSMClient) GetEnvironments(
 		return environments, fmt.Errorf("unable to get no-auth connection: %v", err)
 	}
 	res, err := connection.request(
+		ctx,
 		rhsmClient.UserAgent,
 		http.MethodGet,
 		"owners/"+organization+"/environments",
@@ -62,6 +76,10 @@ func (rhsmClient *RHSMClient) GetEnvironments(
 		return environments, fmt.Errorf("unable to get list of environments: %s", err)
 	}
 
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("unable to get list of environments: %w", newCandlepinError(res))
+	}
+
 	resBody, err := getResponseBody(res)
 	if err != nil {
 		return nil, err
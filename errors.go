@@ -0,0 +1,117 @@
+package rhsm2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// CandlepinError is error returned, when candlepin server responds with
+// unexpected HTTP status code. Candlepin server usually returns JSON document
+// with details about the error. When the response does not contain such document,
+// then only StatusCode and CorrelationId are set.
+type CandlepinError struct {
+	// StatusCode is HTTP status code of the response
+	StatusCode int `json:"-"`
+	// DisplayMessage is human-readable error message returned by candlepin server
+	DisplayMessage string `json:"displayMessage"`
+	// RequestUuid is unique identifier of the request assigned by candlepin server
+	RequestUuid string `json:"requestUuid"`
+	// DeletedId is the UUID of deleted consumer. It is returned, when
+	// the consumer has been already deleted (410 Gone)
+	DeletedId string `json:"deletedId"`
+	// CorrelationId is the Correlation-ID used for the request
+	CorrelationId string `json:"-"`
+}
+
+// Error interface
+func (candlepinError *CandlepinError) Error() string {
+	if candlepinError.DisplayMessage != "" {
+		return fmt.Sprintf("%s (status code: %d)", candlepinError.DisplayMessage, candlepinError.StatusCode)
+	}
+	return fmt.Sprintf("unexpected status code: %d", candlepinError.StatusCode)
+}
+
+// newCandlepinError creates CandlepinError from the response with unexpected
+// status code. The body of the response is consumed. When it is not possible
+// to parse the body, then only the status code is set.
+func newCandlepinError(res *http.Response) *CandlepinError {
+	candlepinError := &CandlepinError{}
+
+	resBody, err := io.ReadAll(res.Body)
+	if err == nil && len(resBody) > 0 {
+		_ = json.Unmarshal(resBody, candlepinError)
+	}
+
+	candlepinError.StatusCode = res.StatusCode
+	if res.Request != nil {
+		candlepinError.CorrelationId = res.Request.Header.Get("Correlation-ID")
+	}
+	if candlepinError.RequestUuid == "" {
+		candlepinError.RequestUuid = res.Header.Get("x-candlepin-request-uuid")
+	}
+
+	return candlepinError
+}
+
+// hasStatusCode returns true, when the error is CandlepinError
+// with given status code
+func hasStatusCode(err error, statusCode int) bool {
+	var candlepinError *CandlepinError
+	if errors.As(err, &candlepinError) {
+		return candlepinError.StatusCode == statusCode
+	}
+	return false
+}
+
+// IsUnauthorized returns true, when candlepin server refused the request,
+// because the client is not authenticated (401 Unauthorized)
+func IsUnauthorized(err error) bool {
+	return hasStatusCode(err, http.StatusUnauthorized)
+}
+
+// IsForbidden returns true, when candlepin server refused the request,
+// because the client has not enough permissions (403 Forbidden)
+func IsForbidden(err error) bool {
+	return hasStatusCode(err, http.StatusForbidden)
+}
+
+// IsNotFound returns true, when the requested object does not exist
+// on candlepin server (404 Not Found)
+func IsNotFound(err error) bool {
+	return hasStatusCode(err, http.StatusNotFound)
+}
+
+// IsGone returns true, when the consumer has been already deleted
+// on candlepin server (410 Gone)
+func IsGone(err error) bool {
+	return hasStatusCode(err, http.StatusGone)
+}
+
+// IsRateLimited returns true, when candlepin server refused the request,
+// because the client sent too many requests (429 Too Many Requests)
+func IsRateLimited(err error) bool {
+	return hasStatusCode(err, http.StatusTooManyRequests)
+}
+
+// EntitlementSignatureError is error returned, when the signature of entitlement
+// data (content definitions) in entitlement certificate is missing or it is not
+// valid. Content of such certificate is not used, because it could be tampered.
+type EntitlementSignatureError struct {
+	// Reason describes why the signature was not accepted
+	Reason string
+}
+
+// Error interface
+func (signatureError *EntitlementSignatureError) Error() string {
+	return fmt.Sprintf("invalid signature of entitlement data: %s", signatureError.Reason)
+}
+
+// IsInvalidEntitlementSignature returns true, when the signature of entitlement
+// data in entitlement certificate is missing or it is not valid
+func IsInvalidEntitlementSignature(err error) bool {
+	var signatureError *EntitlementSignatureError
+	return errors.As(err, &signatureError)
+}
@@ -0,0 +1,332 @@
+package rhsm2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestNewCandlepinError test creating CandlepinError from responses of candlepin server
+func TestNewCandlepinError(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name                   string
+		statusCode             int
+		body                   string
+		expectedDisplayMessage string
+		expectedRequestUuid    string
+		expectedDeletedId      string
+		expectedError          string
+	}{
+		{
+			name:                   "consumer already deleted",
+			statusCode:             410,
+			body:                   response410,
+			expectedDisplayMessage: "Consumer with 5e9745d5-624d-4af1-916e-2c17df4eb4e8 is already deleted.",
+			expectedRequestUuid:    "c4347004-8792-41fe-a4d8-fccaa0d3898a",
+			expectedDeletedId:      "5e9745d5-624d-4af1-916e-2c17df4eb4e8",
+			expectedError:          "Consumer with 5e9745d5-624d-4af1-916e-2c17df4eb4e8 is already deleted. (status code: 410)",
+		},
+		{
+			name:                   "insufficient permissions",
+			statusCode:             403,
+			body:                   response403,
+			expectedDisplayMessage: "Consumer could not be deleted due to insufficient permissions.",
+			expectedRequestUuid:    "c4347004-8792-41fe-a4d8-fccaa0d3898a",
+			expectedError:          "Consumer could not be deleted due to insufficient permissions. (status code: 403)",
+		},
+		{
+			name:                "empty body",
+			statusCode:          503,
+			body:                "",
+			expectedRequestUuid: "168e3687-8498-46b2-af0a-272583d4d4ba",
+			expectedError:       "unexpected status code: 503",
+		},
+		{
+			name:                "body is not JSON document",
+			statusCode:          502,
+			body:                "<html>Bad Gateway</html>",
+			expectedRequestUuid: "168e3687-8498-46b2-af0a-272583d4d4ba",
+			expectedError:       "unexpected status code: 502",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req, _ := http.NewRequest(http.MethodGet, "https://localhost/candlepin/status", nil)
+			req.Header.Set("Correlation-ID", "test-correlation-id")
+			res := &http.Response{
+				StatusCode: tt.statusCode,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+				Request:    req,
+			}
+			res.Header.Set("x-candlepin-request-uuid", "168e3687-8498-46b2-af0a-272583d4d4ba")
+
+			candlepinError := newCandlepinError(res)
+
+			if candlepinError.StatusCode != tt.statusCode {
+				t.Errorf("expected status code: %d, got: %d", tt.statusCode, candlepinError.StatusCode)
+			}
+			if candlepinError.DisplayMessage != tt.expectedDisplayMessage {
+				t.Errorf("expected display message: %s, got: %s",
+					tt.expectedDisplayMessage, candlepinError.DisplayMessage)
+			}
+			if candlepinError.RequestUuid != tt.expectedRequestUuid {
+				t.Errorf("expected request uuid: %s, got: %s",
+					tt.expectedRequestUuid, candlepinError.RequestUuid)
+			}
+			if candlepinError.DeletedId != tt.expectedDeletedId {
+				t.Errorf("expected deleted id: %s, got: %s",
+					tt.expectedDeletedId, candlepinError.DeletedId)
+			}
+			if candlepinError.CorrelationId != "test-correlation-id" {
+				t.Errorf("expected correlation id: %s, got: %s",
+					"test-correlation-id", candlepinError.CorrelationId)
+			}
+			if candlepinError.Error() != tt.expectedError {
+				t.Errorf("expected error: %s, got: %s", tt.expectedError, candlepinError.Error())
+			}
+		})
+	}
+}
+
+// TestCandlepinErrorChecks test checking of status code of (wrapped) CandlepinError
+func TestCandlepinErrorChecks(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name         string
+		err          error
+		unauthorized bool
+		forbidden    bool
+		notFound     bool
+		gone         bool
+		rateLimited  bool
+	}{
+		{
+			name:         "unauthorized",
+			err:          &CandlepinError{StatusCode: 401},
+			unauthorized: true,
+		},
+		{
+			name:      "wrapped forbidden",
+			err:       fmt.Errorf("unable to get consumer: %w", &CandlepinError{StatusCode: 403}),
+			forbidden: true,
+		},
+		{
+			name:     "not found",
+			err:      &CandlepinError{StatusCode: 404},
+			notFound: true,
+		},
+		{
+			name: "wrapped gone",
+			err:  fmt.Errorf("unable to get consumer: %w", &CandlepinError{StatusCode: 410}),
+			gone: true,
+		},
+		{
+			name:        "rate limited",
+			err:         &CandlepinError{StatusCode: 429},
+			rateLimited: true,
+		},
+		{
+			name: "internal server error",
+			err:  &CandlepinError{StatusCode: 500},
+		},
+		{
+			name: "other error",
+			err:  errors.New("410"),
+		},
+		{
+			name: "nil error",
+			err:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if IsUnauthorized(tt.err) != tt.unauthorized {
+				t.Errorf("IsUnauthorized() expected: %v", tt.unauthorized)
+			}
+			if IsForbidden(tt.err) != tt.forbidden {
+				t.Errorf("IsForbidden() expected: %v", tt.forbidden)
+			}
+			if IsNotFound(tt.err) != tt.notFound {
+				t.Errorf("IsNotFound() expected: %v", tt.notFound)
+			}
+			if IsGone(tt.err) != tt.gone {
+				t.Errorf("IsGone() expected: %v", tt.gone)
+			}
+			if IsRateLimited(tt.err) != tt.rateLimited {
+				t.Errorf("IsRateLimited() expected: %v", tt.rateLimited)
+			}
+		})
+	}
+}
+
+// TestDeprecatedErrors test that deprecated RegisterError and UnregisterServerError
+// wrap CandlepinError, and it is possible to use errors.As for both types
+func TestDeprecatedErrors(t *testing.T) {
+	t.Parallel()
+	candlepinError := &CandlepinError{StatusCode: 410, DisplayMessage: "Consumer has been deleted"}
+
+	err := fmt.Errorf("unable to register: %w", newRegisterError(candlepinError))
+	var registerError RegisterError
+	if !errors.As(err, &registerError) {
+		t.Fatalf("expected RegisterError, got: %T", err)
+	}
+	if registerError.DisplayMessage != candlepinError.DisplayMessage {
+		t.Errorf("expected display message: %s, got: %s", candlepinError.DisplayMessage, registerError.DisplayMessage)
+	}
+	var wrappedError *CandlepinError
+	if !errors.As(err, &wrappedError) || wrappedError != candlepinError {
+		t.Errorf("RegisterError does not wrap CandlepinError")
+	}
+
+	err = newUnregisterServerError(candlepinError)
+	var unregisterServerError UnregisterServerError
+	if !errors.As(err, &unregisterServerError) {
+		t.Fatalf("expected UnregisterServerError, got: %T", err)
+	}
+	if unregisterServerError.StatusCode != 410 {
+		t.Errorf("expected status code: 410, got: %d", unregisterServerError.StatusCode)
+	}
+	if !errors.As(err, &wrappedError) || wrappedError != candlepinError {
+		t.Errorf("UnregisterServerError does not wrap CandlepinError")
+	}
+	if !IsGone(err) {
+		t.Errorf("IsGone() expected: true")
+	}
+}
+
+// TestEndpointsReturnCandlepinError test that endpoints return CandlepinError,
+// when candlepin server responds with unexpected status code
+func TestEndpointsReturnCandlepinError(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		registered bool
+		statusCode int
+		body       string
+		call       func(rhsmClient *RHSMClient, metadata *RequestMetadata) error
+	}{
+		{
+			name:       "GetOrgs",
+			registered: false,
+			statusCode: 401,
+			body:       `{"displayMessage": "Invalid Credentials"}`,
+			call: func(rhsmClient *RHSMClient, metadata *RequestMetadata) error {
+				_, err := rhsmClient.GetOrgs("admin", "wrong", metadata)
+				return err
+			},
+		},
+		{
+			name:       "GetEnvironments",
+			registered: false,
+			statusCode: 403,
+			body:       response403,
+			call: func(rhsmClient *RHSMClient, metadata *RequestMetadata) error {
+				_, err := rhsmClient.GetEnvironments("admin", "admin", "donaldduck", metadata)
+				return err
+			},
+		},
+		{
+			name:       "GetOrg",
+			registered: true,
+			statusCode: 404,
+			body:       response404,
+			call: func(rhsmClient *RHSMClient, metadata *RequestMetadata) error {
+				_, err := rhsmClient.GetOrg(metadata)
+				return err
+			},
+		},
+		{
+			name:       "GetConsumer",
+			registered: true,
+			statusCode: 410,
+			body:       response410,
+			call: func(rhsmClient *RHSMClient, metadata *RequestMetadata) error {
+				_, err := rhsmClient.GetConsumer(metadata)
+				return err
+			},
+		},
+		{
+			name:       "GetServerEndpoints",
+			registered: true,
+			statusCode: 500,
+			body:       response500,
+			call: func(rhsmClient *RHSMClient, metadata *RequestMetadata) error {
+				_, err := rhsmClient.GetServerEndpoints(metadata)
+				return err
+			},
+		},
+		{
+			name:       "GetServerStatus",
+			registered: false,
+			statusCode: 500,
+			body:       response500,
+			call: func(rhsmClient *RHSMClient, metadata *RequestMetadata) error {
+				_, err := rhsmClient.GetServerStatus(metadata)
+				return err
+			},
+		},
+		{
+			name:       "GetReleaseFromServer",
+			registered: true,
+			statusCode: 429,
+			body:       "",
+			call: func(rhsmClient *RHSMClient, metadata *RequestMetadata) error {
+				_, err := rhsmClient.GetReleaseFromServer(metadata)
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			server := httptest.NewTLSServer(
+				http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+					rw.WriteHeader(tt.statusCode)
+					_, _ = rw.Write([]byte(tt.body))
+				}))
+			defer server.Close()
+
+			tempDirFilePath := t.TempDir()
+			testingFiles, err := setupTestingFileSystem(
+				tempDirFilePath, false, tt.registered, false, false, false)
+			if err != nil {
+				t.Fatalf("unable to setup testing environment: %s", err)
+			}
+
+			rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+			if err != nil {
+				t.Fatalf("unable to setup testing rhsm client: %s", err)
+			}
+
+			correlationId := "test-correlation-id"
+			metadata := RequestMetadata{CorrelationId: &correlationId}
+
+			err = tt.call(rhsmClient, &metadata)
+			if err == nil {
+				t.Fatalf("no error returned for status code: %d", tt.statusCode)
+			}
+
+			var candlepinError *CandlepinError
+			if !errors.As(err, &candlepinError) {
+				t.Fatalf("expected CandlepinError, got: %T: %s", err, err)
+			}
+			if candlepinError.StatusCode != tt.statusCode {
+				t.Errorf("expected status code: %d, got: %d", tt.statusCode, candlepinError.StatusCode)
+			}
+			if candlepinError.CorrelationId != correlationId {
+				t.Errorf("expected correlation id: %s, got: %s", correlationId, candlepinError.CorrelationId)
+			}
+		})
+	}
+}
@@ -0,0 +1,622 @@
+package rhsm2
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultFactsRootDirPath is the root directory used by fact collectors,
+// when they try to read files like /proc/cpuinfo or /sys/class/dmi/id/*
+const DefaultFactsRootDirPath = "/"
+
+// systemCertificateVersion is the version of certificates supported by this
+// client. It is necessary to report version 3.0 or higher to candlepin server
+const systemCertificateVersion = "3.2"
+
+// FactCollector is interface of one collector of system facts. Each collector
+// reads files relative to the given root directory (e.g. proc/meminfo). Thus, it
+// is possible to collect facts from fake /sys and /proc tree.
+type FactCollector interface {
+	// Name returns the name of the collector. It is used only in log messages.
+	Name() string
+	// Collect tries to collect facts from files in the root directory
+	Collect(rootDirPath string) (map[string]string, error)
+}
+
+// defaultFactCollectors returns the list of fact collectors used by default
+func defaultFactCollectors(logger *zerolog.Logger) []FactCollector {
+	return []FactCollector{
+		&dmiFactCollector{},
+		&cpuFactCollector{logger: logger},
+		&memoryFactCollector{},
+		&networkFactCollector{},
+		&unameFactCollector{},
+		&distributionFactCollector{},
+		&virtFactCollector{},
+	}
+}
+
+// AddFactCollector adds a custom fact collector to the list of collectors used for
+// gathering system facts. Facts returned by collectors added later have higher
+// priority than facts returned by default collectors.
+func (rhsmClient *RHSMClient) AddFactCollector(collector FactCollector) {
+	if rhsmClient.factCollectors == nil {
+		rhsmClient.factCollectors = defaultFactCollectors(rhsmClient.getLogger())
+	}
+	rhsmClient.factCollectors = append(rhsmClient.factCollectors, collector)
+}
+
+// SetFactsRootDirPath sets the root directory used by fact collectors, when they
+// read files like /proc/cpuinfo or /sys/class/dmi/id/*. It is possible to collect
+// facts from fake /sys and /proc tree this way. DefaultFactsRootDirPath in RootDirPath
+// is used by default.
+func (rhsmClient *RHSMClient) SetFactsRootDirPath(dirPath string) {
+	rhsmClient.RHSMConf.factsRootDirPath = dirPath
+}
+
+// collectFacts tries to collect system facts using all fact collectors. When some
+// collector fails, then the error is only logged and facts from other collectors
+// are still used. Custom facts are merged over collected facts. Only the
+// system.certificate_version cannot be overridden by custom facts.
+func (rhsmClient *RHSMClient) collectFacts() map[string]string {
+	collectors := rhsmClient.factCollectors
+	if collectors == nil {
+		collectors = defaultFactCollectors(rhsmClient.getLogger())
+	}
+
+	rootDirPath := rhsmClient.RHSMConf.factsRootDirPath
+	if rootDirPath == "" {
+		rootDirPath = DefaultFactsRootDirPath
+	}
+
+	facts := make(map[string]string)
+	for _, collector := range collectors {
+		collectedFacts, err := collector.Collect(rootDirPath)
+		if err != nil {
+			rhsmClient.getLogger().Warn().Msgf("unable to collect %s facts: %s", collector.Name(), err)
+			continue
+		}
+		for key, value := range collectedFacts {
+			facts[key] = value
+		}
+	}
+
+	if rhsmClient.RHSMConf.customFactsDirPath != "" {
+		customFacts := readCustomFacts(rhsmClient.getLogger(), rhsmClient.RHSMConf.customFactsDirPath)
+		for key, value := range customFacts {
+			facts[key] = value
+		}
+	}
+
+	// It is necessary to set system certificate version to value 3.0 or higher
+	facts["system.certificate_version"] = systemCertificateVersion
+
+	return facts
+}
+
+// etcHostnameFilePath is the file with static hostname
+const etcHostnameFilePath = "etc/hostname"
+
+// fallbackHostname is used, when it is not possible to get hostname from the root directory
+const fallbackHostname = "localhost"
+
+// getHostname tries to get hostname of the managed system. When the root directory
+// is not used, then hostname of the running system is returned. Otherwise, the hostname
+// is read from /etc/hostname (or /proc/sys/kernel/hostname) in the root directory.
+// Offline images usually do not contain any hostname. Thus, "localhost" is returned
+// in this case.
+func (rhsmClient *RHSMClient) getHostname() (string, error) {
+	if rhsmClient.RHSMConf.rootDirPath == "" {
+		return os.Hostname()
+	}
+
+	for _, filePath := range []string{etcHostnameFilePath, hostnameFilePath} {
+		hostname, err := readFactFile(rhsmClient.RHSMConf.rootDirPath, filePath)
+		if err == nil && hostname != "" {
+			return hostname, nil
+		}
+	}
+
+	rhsmClient.getLogger().Warn().Msgf("unable to get hostname from root directory %s, using: %s",
+		rhsmClient.RHSMConf.rootDirPath, fallbackHostname)
+	return fallbackHostname, nil
+}
+
+// readFactFile tries to read the content of the file with one value (typically
+// files in /sys or /proc/sys). Leading and trailing white spaces are removed.
+func readFactFile(rootDirPath string, filePath string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(rootDirPath, filePath))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// hostArchitecture returns the architecture of the host in the format used
+// by uname and RPM (e.g. x86_64 instead of amd64)
+func hostArchitecture() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "386":
+		return "i686"
+	case "arm64":
+		return "aarch64"
+	case "arm":
+		return "armv7l"
+	default:
+		// Names of ppc64, ppc64le, s390x, riscv64 are the same
+		return runtime.GOARCH
+	}
+}
+
+// dmiFactCollector collects facts from DMI table exported by the kernel
+// in /sys/class/dmi/id
+type dmiFactCollector struct{}
+
+// dmiFactFiles maps files in /sys/class/dmi/id to names of facts. Note: fact
+// "dmi.bios.relase_date" is misspelled, but this is the name reported by
+// subscription-manager for ages, and we want to be compatible.
+var dmiFactFiles = map[string]string{
+	"bios_vendor":       "dmi.bios.vendor",
+	"bios_version":      "dmi.bios.version",
+	"bios_date":         "dmi.bios.relase_date",
+	"sys_vendor":        "dmi.system.manufacturer",
+	"product_name":      "dmi.system.product_name",
+	"product_version":   "dmi.system.version",
+	"product_serial":    "dmi.system.serial_number",
+	"product_uuid":      "dmi.system.uuid",
+	"product_family":    "dmi.system.family",
+	"product_sku":       "dmi.system.sku_number",
+	"board_vendor":      "dmi.baseboard.manufacturer",
+	"board_name":        "dmi.baseboard.product_name",
+	"board_version":     "dmi.baseboard.version",
+	"board_serial":      "dmi.baseboard.serial_number",
+	"chassis_vendor":    "dmi.chassis.manufacturer",
+	"chassis_type":      "dmi.chassis.type",
+	"chassis_serial":    "dmi.chassis.serial_number",
+	"chassis_asset_tag": "dmi.chassis.asset_tag",
+}
+
+const dmiDirPath = "sys/class/dmi/id"
+
+func (collector *dmiFactCollector) Name() string {
+	return "dmi"
+}
+
+// Collect tries to read all known files from /sys/class/dmi/id. Some files
+// are readable only by root user. Such files are silently skipped.
+func (collector *dmiFactCollector) Collect(rootDirPath string) (map[string]string, error) {
+	if _, err := os.Stat(filepath.Join(rootDirPath, dmiDirPath)); err != nil {
+		return nil, fmt.Errorf("DMI information is not available: %s", err)
+	}
+
+	facts := make(map[string]string)
+	for fileName, factName := range dmiFactFiles {
+		value, err := readFactFile(rootDirPath, filepath.Join(dmiDirPath, fileName))
+		if err != nil || value == "" {
+			continue
+		}
+		facts[factName] = value
+	}
+	return facts, nil
+}
+
+// cpuFactCollector collects facts about CPU topology
+type cpuFactCollector struct {
+	// logger is used for logging. When it is nil, then global zerolog logger is used
+	logger *zerolog.Logger
+}
+
+// getLogger returns logger used by the collector
+func (collector *cpuFactCollector) getLogger() *zerolog.Logger {
+	if collector.logger == nil {
+		return &log.Logger
+	}
+	return collector.logger
+}
+
+const cpuSysDirPath = "sys/devices/system/cpu"
+const cpuInfoFilePath = "proc/cpuinfo"
+
+var cpuDirNameRegexp = regexp.MustCompile(`^cpu[0-9]+$`)
+
+func (collector *cpuFactCollector) Name() string {
+	return "cpu"
+}
+
+// Collect tries to get CPU topology from /sys/devices/system/cpu/cpu*/topology.
+// When the topology is not available in sysfs, then it tries to count processors
+// in /proc/cpuinfo and every processor is considered as one socket.
+func (collector *cpuFactCollector) Collect(rootDirPath string) (map[string]string, error) {
+	numCPUs, numSockets, numCores, err := readCPUTopologyFromSysfs(rootDirPath)
+	if err != nil {
+		collector.getLogger().Debug().Msgf("unable to read CPU topology from sysfs: %s", err)
+		numCPUs, err = countProcessorsInCPUInfo(rootDirPath)
+		if err != nil {
+			return nil, err
+		}
+		numSockets = numCPUs
+		numCores = numCPUs
+	}
+
+	facts := map[string]string{
+		"cpu.cpu(s)":             strconv.Itoa(numCPUs),
+		"cpu.cpu_socket(s)":      strconv.Itoa(numSockets),
+		"cpu.core(s)_per_socket": strconv.Itoa(numCores / numSockets),
+		"cpu.thread(s)_per_core": strconv.Itoa(numCPUs / numCores),
+	}
+	return facts, nil
+}
+
+// readCPUTopologyFromSysfs tries to get the number of CPUs, sockets and cores
+// from /sys/devices/system/cpu/cpu*/topology
+func readCPUTopologyFromSysfs(rootDirPath string) (int, int, int, error) {
+	cpuDirPath := filepath.Join(rootDirPath, cpuSysDirPath)
+	entries, err := os.ReadDir(cpuDirPath)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	numCPUs := 0
+	sockets := make(map[string]struct{})
+	cores := make(map[string]struct{})
+	for _, entry := range entries {
+		if !cpuDirNameRegexp.MatchString(entry.Name()) {
+			continue
+		}
+		topologyDirPath := filepath.Join(cpuSysDirPath, entry.Name(), "topology")
+		packageId, err := readFactFile(rootDirPath, filepath.Join(topologyDirPath, "physical_package_id"))
+		if err != nil {
+			continue
+		}
+		coreId, err := readFactFile(rootDirPath, filepath.Join(topologyDirPath, "core_id"))
+		if err != nil {
+			continue
+		}
+		numCPUs += 1
+		sockets[packageId] = struct{}{}
+		cores[packageId+":"+coreId] = struct{}{}
+	}
+
+	if numCPUs == 0 {
+		return 0, 0, 0, fmt.Errorf("no CPU topology found in %s", cpuDirPath)
+	}
+
+	return numCPUs, len(sockets), len(cores), nil
+}
+
+// countProcessorsInCPUInfo tries to count processors listed in /proc/cpuinfo
+func countProcessorsInCPUInfo(rootDirPath string) (int, error) {
+	variables, err := parseCPUInfo(rootDirPath)
+	if err != nil {
+		return 0, err
+	}
+	if len(variables) == 0 {
+		return 0, fmt.Errorf("no processor found in %s", cpuInfoFilePath)
+	}
+	return len(variables), nil
+}
+
+// parseCPUInfo tries to parse /proc/cpuinfo. It returns one map of
+// variables for each processor
+func parseCPUInfo(rootDirPath string) ([]map[string]string, error) {
+	file, err := os.Open(filepath.Join(rootDirPath, cpuInfoFilePath))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// We only read the file. Thus, error could be ignored
+		_ = file.Close()
+	}()
+
+	var processors []map[string]string
+	var processor map[string]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		if key == "processor" {
+			processor = make(map[string]string)
+			processors = append(processors, processor)
+		}
+		if processor != nil {
+			processor[key] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return processors, nil
+}
+
+// memoryFactCollector collects facts about memory from /proc/meminfo
+type memoryFactCollector struct{}
+
+const memInfoFilePath = "proc/meminfo"
+
+func (collector *memoryFactCollector) Name() string {
+	return "memory"
+}
+
+// Collect tries to get total size of memory and swap from /proc/meminfo.
+// Values are reported in kB.
+func (collector *memoryFactCollector) Collect(rootDirPath string) (map[string]string, error) {
+	content, err := os.ReadFile(filepath.Join(rootDirPath, memInfoFilePath))
+	if err != nil {
+		return nil, err
+	}
+
+	facts := make(map[string]string)
+	for _, line := range strings.Split(string(content), "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case "MemTotal":
+			facts["memory.memtotal"] = fields[0]
+		case "SwapTotal":
+			facts["memory.swaptotal"] = fields[0]
+		}
+	}
+
+	if _, exists := facts["memory.memtotal"]; !exists {
+		return nil, fmt.Errorf("MemTotal not found in %s", memInfoFilePath)
+	}
+
+	return facts, nil
+}
+
+// networkFactCollector collects facts about network interfaces
+type networkFactCollector struct{}
+
+const netSysDirPath = "sys/class/net"
+const hostnameFilePath = "proc/sys/kernel/hostname"
+
+func (collector *networkFactCollector) Name() string {
+	return "network"
+}
+
+// Collect tries to get MAC addresses of network interfaces from /sys/class/net.
+// IP addresses cannot be read from sysfs. Thus, IP addresses are reported only,
+// when facts are collected on the running host (root directory is "/").
+func (collector *networkFactCollector) Collect(rootDirPath string) (map[string]string, error) {
+	entries, err := os.ReadDir(filepath.Join(rootDirPath, netSysDirPath))
+	if err != nil {
+		return nil, err
+	}
+
+	facts := make(map[string]string)
+
+	hostname, err := readFactFile(rootDirPath, hostnameFilePath)
+	if err == nil && hostname != "" {
+		facts["network.hostname"] = hostname
+	}
+
+	for _, entry := range entries {
+		interfaceName := entry.Name()
+		// Loopback is not interesting for candlepin server
+		if interfaceName == "lo" {
+			continue
+		}
+		macAddress, err := readFactFile(rootDirPath, filepath.Join(netSysDirPath, interfaceName, "address"))
+		if err == nil && macAddress != "" {
+			facts["net.interface."+interfaceName+".mac_address"] = macAddress
+		}
+
+		if rootDirPath != DefaultFactsRootDirPath {
+			continue
+		}
+		ipv4Addresses, ipv6Addresses := getInterfaceIPAddresses(interfaceName)
+		if len(ipv4Addresses) > 0 {
+			facts["net.interface."+interfaceName+".ipv4_address"] = ipv4Addresses[0]
+			facts["net.interface."+interfaceName+".ipv4_address_list"] = strings.Join(ipv4Addresses, ", ")
+		}
+		if len(ipv6Addresses) > 0 {
+			facts["net.interface."+interfaceName+".ipv6_address_list"] = strings.Join(ipv6Addresses, ", ")
+		}
+	}
+
+	return facts, nil
+}
+
+// getInterfaceIPAddresses tries to get lists of IPv4 and IPv6 addresses
+// of the given network interface
+func getInterfaceIPAddresses(interfaceName string) ([]string, []string) {
+	var ipv4Addresses, ipv6Addresses []string
+	netInterface, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return nil, nil
+	}
+	addresses, err := netInterface.Addrs()
+	if err != nil {
+		return nil, nil
+	}
+	for _, address := range addresses {
+		ipNet, ok := address.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			ipv4Addresses = append(ipv4Addresses, ipNet.IP.String())
+		} else {
+			ipv6Addresses = append(ipv6Addresses, ipNet.IP.String())
+		}
+	}
+	return ipv4Addresses, ipv6Addresses
+}
+
+// unameFactCollector collects facts usually reported by uname
+type unameFactCollector struct{}
+
+// unameFactFiles maps files in /proc/sys/kernel to names of facts
+var unameFactFiles = map[string]string{
+	"ostype":    "uname.sysname",
+	"osrelease": "uname.release",
+	"version":   "uname.version",
+	"hostname":  "uname.nodename",
+}
+
+func (collector *unameFactCollector) Name() string {
+	return "uname"
+}
+
+// Collect tries to read facts from /proc/sys/kernel. The machine is
+// architecture of the running host, because it cannot be read from any file.
+func (collector *unameFactCollector) Collect(rootDirPath string) (map[string]string, error) {
+	facts := make(map[string]string)
+	for fileName, factName := range unameFactFiles {
+		value, err := readFactFile(rootDirPath, filepath.Join("proc/sys/kernel", fileName))
+		if err != nil {
+			return nil, err
+		}
+		facts[factName] = value
+	}
+	facts["uname.machine"] = hostArchitecture()
+	return facts, nil
+}
+
+// distributionFactCollector collects facts about Linux distribution
+// from /etc/os-release
+type distributionFactCollector struct{}
+
+const osReleaseFactFilePath = "etc/os-release"
+
+var distributionCodeNameRegexp = regexp.MustCompile(`\((.+)\)`)
+
+func (collector *distributionFactCollector) Name() string {
+	return "distribution"
+}
+
+// Collect tries to parse /etc/os-release
+func (collector *distributionFactCollector) Collect(rootDirPath string) (map[string]string, error) {
+	content, err := os.ReadFile(filepath.Join(rootDirPath, osReleaseFactFilePath))
+	if err != nil {
+		return nil, err
+	}
+
+	variables := parseOSReleaseVariables(&content)
+	if variables["NAME"] == "" {
+		return nil, fmt.Errorf("no NAME found in %s", osReleaseFactFilePath)
+	}
+
+	facts := map[string]string{
+		"distribution.name":    variables["NAME"],
+		"distribution.version": variables["VERSION_ID"],
+		"distribution.id":      variables["VERSION_CODENAME"],
+	}
+
+	// Code name is usually part of VERSION like: VERSION="10.0 (Coughlan)"
+	if facts["distribution.id"] == "" {
+		match := distributionCodeNameRegexp.FindStringSubmatch(variables["VERSION"])
+		if len(match) == 2 {
+			facts["distribution.id"] = match[1]
+		}
+	}
+
+	facts["distribution.version.modifier"] = "Unknown"
+	if variables["VARIANT_ID"] != "" {
+		facts["distribution.version.modifier"] = variables["VARIANT_ID"]
+	}
+
+	return facts, nil
+}
+
+// virtFactCollector tries to detect, if the system is running as virtual guest
+type virtFactCollector struct{}
+
+const hypervisorTypeFilePath = "sys/hypervisor/type"
+
+// virtDMIVendors maps DMI system manufacturer or product name to the type
+// of hypervisor
+var virtDMIVendors = []struct {
+	pattern  string
+	hostType string
+}{
+	{"QEMU", "kvm"},
+	{"KVM", "kvm"},
+	{"Amazon EC2", "kvm"},
+	{"Google", "kvm"},
+	{"VMware", "vmware"},
+	{"VirtualBox", "virtualbox"},
+	{"innotek", "virtualbox"},
+	{"Microsoft Corporation", "hyperv"},
+	{"Xen", "xen"},
+	{"Parallels", "parallels"},
+	{"Bochs", "bochs"},
+}
+
+func (collector *virtFactCollector) Name() string {
+	return "virt"
+}
+
+// Collect tries to detect the type of hypervisor using /sys/hypervisor/type,
+// DMI information and the "hypervisor" CPU flag in /proc/cpuinfo
+func (collector *virtFactCollector) Collect(rootDirPath string) (map[string]string, error) {
+	hostType, _ := readFactFile(rootDirPath, hypervisorTypeFilePath)
+
+	if hostType == "" {
+		for _, fileName := range []string{"sys_vendor", "product_name", "bios_vendor"} {
+			value, err := readFactFile(rootDirPath, filepath.Join(dmiDirPath, fileName))
+			if err != nil || value == "" {
+				continue
+			}
+			for _, vendor := range virtDMIVendors {
+				if strings.Contains(value, vendor.pattern) {
+					hostType = vendor.hostType
+					break
+				}
+			}
+			if hostType != "" {
+				break
+			}
+		}
+	}
+
+	isGuest := hostType != ""
+	if !isGuest {
+		processors, err := parseCPUInfo(rootDirPath)
+		if err == nil && len(processors) > 0 {
+			for _, flag := range strings.Fields(processors[0]["flags"]) {
+				if flag == "hypervisor" {
+					isGuest = true
+					hostType = "Unknown"
+					break
+				}
+			}
+		}
+	}
+
+	facts := map[string]string{
+		"virt.is_guest":  strconv.FormatBool(isGuest),
+		"virt.host_type": "Not Applicable",
+	}
+	if isGuest {
+		facts["virt.host_type"] = hostType
+		uuid, err := readFactFile(rootDirPath, filepath.Join(dmiDirPath, "product_uuid"))
+		if err == nil && uuid != "" {
+			facts["virt.uuid"] = uuid
+		}
+	}
+
+	return facts, nil
+}
@@ -0,0 +1,267 @@
+package rhsm2
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFactCollectors test that every default fact collector is able to
+// collect facts from testing /sys and /proc tree
+func TestFactCollectors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name          string
+		collector     FactCollector
+		expectedFacts map[string]string
+	}{
+		{
+			name:      "dmi",
+			collector: &dmiFactCollector{},
+			expectedFacts: map[string]string{
+				"dmi.bios.vendor":            "SeaBIOS",
+				"dmi.bios.version":           "1.16.3-2.el10",
+				"dmi.bios.relase_date":       "04/01/2014",
+				"dmi.system.manufacturer":    "QEMU",
+				"dmi.system.product_name":    "Standard PC (Q35 + ICH9, 2009)",
+				"dmi.system.version":         "pc-q35-rhel10.0.0",
+				"dmi.system.uuid":            "8c7bb7c4-5d0d-4f59-a3e5-5d2b0f0e8a41",
+				"dmi.baseboard.manufacturer": "Red Hat",
+				"dmi.baseboard.product_name": "RHEL",
+				"dmi.chassis.manufacturer":   "QEMU",
+				"dmi.chassis.type":           "1",
+			},
+		},
+		{
+			name:      "cpu",
+			collector: &cpuFactCollector{},
+			expectedFacts: map[string]string{
+				"cpu.cpu(s)":             "4",
+				"cpu.cpu_socket(s)":      "1",
+				"cpu.core(s)_per_socket": "2",
+				"cpu.thread(s)_per_core": "2",
+			},
+		},
+		{
+			name:      "memory",
+			collector: &memoryFactCollector{},
+			expectedFacts: map[string]string{
+				"memory.memtotal":  "8024668",
+				"memory.swaptotal": "4194300",
+			},
+		},
+		{
+			name:      "network",
+			collector: &networkFactCollector{},
+			expectedFacts: map[string]string{
+				"network.hostname":               "rhel10.example.com",
+				"net.interface.eth0.mac_address": "52:54:00:12:34:56",
+			},
+		},
+		{
+			name:      "uname",
+			collector: &unameFactCollector{},
+			expectedFacts: map[string]string{
+				"uname.sysname":  "Linux",
+				"uname.release":  "6.12.0-55.el10.x86_64",
+				"uname.version":  "#1 SMP PREEMPT_DYNAMIC Fri Mar 14 15:36:34 EDT 2025",
+				"uname.nodename": "rhel10.example.com",
+				"uname.machine":  hostArchitecture(),
+			},
+		},
+		{
+			name:      "distribution",
+			collector: &distributionFactCollector{},
+			expectedFacts: map[string]string{
+				"distribution.name":             "Red Hat Enterprise Linux",
+				"distribution.version":          "10.0",
+				"distribution.id":               "Coughlan",
+				"distribution.version.modifier": "Unknown",
+			},
+		},
+		{
+			name:      "virt",
+			collector: &virtFactCollector{},
+			expectedFacts: map[string]string{
+				"virt.is_guest":  "true",
+				"virt.host_type": "kvm",
+				"virt.uuid":      "8c7bb7c4-5d0d-4f59-a3e5-5d2b0f0e8a41",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			facts, err := tt.collector.Collect("./testdata")
+			if err != nil {
+				t.Fatalf("%s: Collect() returned error: %s", tt.name, err)
+			}
+			if len(facts) != len(tt.expectedFacts) {
+				t.Errorf("%s: Collect() returned %d facts, expected %d: %v",
+					tt.name, len(facts), len(tt.expectedFacts), facts)
+			}
+			for key, expectedValue := range tt.expectedFacts {
+				value, exists := facts[key]
+				if !exists {
+					t.Errorf("%s: fact %s not collected", tt.name, key)
+					continue
+				}
+				if value != expectedValue {
+					t.Errorf("%s: fact %s = %s, expected %s", tt.name, key, value, expectedValue)
+				}
+			}
+			if tt.name == "network" {
+				if _, exists := facts["net.interface.lo.mac_address"]; exists {
+					t.Errorf("%s: loopback interface should not be reported", tt.name)
+				}
+			}
+		})
+	}
+}
+
+// TestFactCollectorsMissingRoot test that collectors return error, when
+// the root directory does not contain expected files
+func TestFactCollectorsMissingRoot(t *testing.T) {
+	t.Parallel()
+	rootDirPath := t.TempDir()
+
+	for _, collector := range []FactCollector{
+		&dmiFactCollector{},
+		&cpuFactCollector{},
+		&memoryFactCollector{},
+		&networkFactCollector{},
+		&unameFactCollector{},
+		&distributionFactCollector{},
+	} {
+		_, err := collector.Collect(rootDirPath)
+		if err == nil {
+			t.Errorf("%s: Collect() should return error for empty root directory", collector.Name())
+		}
+	}
+
+	// Virt collector cannot fail. It only says that it is not a guest
+	facts, err := (&virtFactCollector{}).Collect(rootDirPath)
+	if err != nil {
+		t.Fatalf("virt: Collect() returned error: %s", err)
+	}
+	if facts["virt.is_guest"] != "false" {
+		t.Errorf("virt: expected virt.is_guest = false, got %s", facts["virt.is_guest"])
+	}
+}
+
+// fakeFactCollector is fact collector used for testing pluggable collectors
+type fakeFactCollector struct {
+	facts map[string]string
+	err   error
+}
+
+func (collector *fakeFactCollector) Name() string {
+	return "fake"
+}
+
+func (collector *fakeFactCollector) Collect(_ string) (map[string]string, error) {
+	return collector.facts, collector.err
+}
+
+// TestCollectFacts test that facts from all collectors are merged together,
+// that failing collectors are skipped and that system.certificate_version
+// cannot be overridden
+func TestCollectFacts(t *testing.T) {
+	t.Parallel()
+	tempDirFilePath := t.TempDir()
+
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, true, false, false, false, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	// Facts are collected from fake /sys and /proc tree
+	rhsmClient.SetFactsRootDirPath("./testdata")
+
+	rhsmClient.AddFactCollector(&fakeFactCollector{
+		facts: map[string]string{
+			"memory.memtotal":            "1024",
+			"custom.fact":                "foo",
+			"system.certificate_version": "1.0",
+		},
+	})
+	rhsmClient.AddFactCollector(&fakeFactCollector{
+		facts: map[string]string{"custom.broken": "bar"},
+		err:   fmt.Errorf("broken collector"),
+	})
+
+	facts := rhsmClient.collectFacts()
+
+	expectedFacts := map[string]string{
+		"memory.memtotal":            "1024",
+		"custom.fact":                "foo",
+		"system.certificate_version": systemCertificateVersion,
+		"cpu.cpu(s)":                 "4",
+		"dmi.system.manufacturer":    "QEMU",
+	}
+	for key, expectedValue := range expectedFacts {
+		if facts[key] != expectedValue {
+			t.Errorf("fact %s = %s, expected %s", key, facts[key], expectedValue)
+		}
+	}
+
+	if _, exists := facts["custom.broken"]; exists {
+		t.Errorf("facts from failing collector should not be used")
+	}
+}
+
+// TestGetHostnameRootDir test that hostname is read from root directory
+// and that "localhost" is used, when the root directory contains no hostname
+func TestGetHostnameRootDir(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name             string
+		etcHostname      string
+		expectedHostname string
+	}{
+		{
+			name:             "static hostname",
+			etcHostname:      "  image.company.com\n",
+			expectedHostname: "image.company.com",
+		},
+		{
+			name:             "no hostname",
+			expectedHostname: fallbackHostname,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			rootDirPath := t.TempDir()
+			if tt.etcHostname != "" {
+				err := os.MkdirAll(filepath.Join(rootDirPath, "etc"), 0755)
+				if err != nil {
+					t.Fatalf("unable to create etc directory: %s", err)
+				}
+				err = os.WriteFile(filepath.Join(rootDirPath, etcHostnameFilePath), []byte(tt.etcHostname), 0644)
+				if err != nil {
+					t.Fatalf("unable to write hostname: %s", err)
+				}
+			}
+
+			rhsmClient := RHSMClient{RHSMConf: &RHSMConf{}}
+			rhsmClient.RHSMConf.setRootDirPath(rootDirPath)
+
+			hostname, err := rhsmClient.getHostname()
+			if err != nil {
+				t.Fatalf("unable to get hostname: %s", err)
+			}
+			if hostname != tt.expectedHostname {
+				t.Errorf("expected hostname: %s, got: %s", tt.expectedHostname, hostname)
+			}
+		})
+	}
+}
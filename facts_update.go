@@ -0,0 +1,141 @@
+package rhsm2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// DefaultFactsCacheFilePath is the file path of the cache with facts
+// reported to the candlepin server last time
+const DefaultFactsCacheFilePath = DefaultCacheDirPath + "/facts/facts.json"
+
+// FactsCache is structure representing facts cached on disk
+type FactsCache struct {
+	Hash  string            `json:"hash"`
+	Facts map[string]string `json:"facts"`
+}
+
+// updateFactsData is structure representing JSON data used for updating facts
+type updateFactsData struct {
+	Facts map[string]string `json:"facts"`
+}
+
+// newFactsCache tries to create facts cache from given facts
+func newFactsCache(facts map[string]string) (*FactsCache, error) {
+	hash, err := computeHash(facts)
+	if err != nil {
+		return nil, err
+	}
+	return &FactsCache{Hash: hash, Facts: facts}, nil
+}
+
+// ReadFactsCache tries to read facts cached on disk. These facts were
+// reported to the candlepin server last time.
+func (rhsmClient *RHSMClient) ReadFactsCache() (*FactsCache, error) {
+	var factsCache FactsCache
+	err := readJSONCache(rhsmClient.RHSMConf.factsCacheFilePath, &factsCache)
+	if err != nil {
+		return nil, err
+	}
+	return &factsCache, nil
+}
+
+// writeFactsCache tries to write given facts to the cache on disk
+func (rhsmClient *RHSMClient) writeFactsCache(factsCache *FactsCache) error {
+	return writeJSONCache(rhsmClient.getLogger(), rhsmClient.RHSMConf.factsCacheFilePath, factsCache, rhsmClient.getFilePermissions())
+}
+
+// FactsChanged tries to collect current facts and compare their hash with
+// the hash of cached facts. When there is no cache or the cache is not
+// readable, then facts are considered as changed. Current facts are
+// returned too, because collecting facts is not cheap.
+func (rhsmClient *RHSMClient) FactsChanged() (bool, *FactsCache, error) {
+	currentFacts, err := newFactsCache(rhsmClient.collectFacts())
+	if err != nil {
+		return false, nil, fmt.Errorf("unable to compute hash of facts: %s", err)
+	}
+
+	cachedFacts, err := rhsmClient.ReadFactsCache()
+	if err != nil {
+		rhsmClient.getLogger().Debug().Msgf("unable to read facts cache: %s", err)
+		return true, currentFacts, nil
+	}
+
+	return cachedFacts.Hash != currentFacts.Hash, currentFacts, nil
+}
+
+// UpdateFacts tries to collect facts and send them to the candlepin server,
+// when facts were changed since the last update. It returns true, when
+// facts were sent to the server.
+func (rhsmClient *RHSMClient) UpdateFacts(metadata *RequestMetadata) (bool, error) {
+	return rhsmClient.UpdateFactsWithContext(context.Background(), metadata)
+}
+
+// UpdateFactsWithContext is like UpdateFacts, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) UpdateFactsWithContext(ctx context.Context, metadata *RequestMetadata) (bool, error) {
+	changed, currentFacts, err := rhsmClient.FactsChanged()
+	if err != nil {
+		return false, err
+	}
+
+	if !changed {
+		rhsmClient.getLogger().Debug().Msg("facts not changed, skipping update of facts")
+		return false, nil
+	}
+
+	consumerUuid, err := rhsmClient.GetConsumerUUID()
+	if err != nil {
+		return false, fmt.Errorf("unable to get consumer uuid: %v", err)
+	}
+
+	body, err := json.Marshal(updateFactsData{Facts: currentFacts.Facts})
+	if err != nil {
+		return false, err
+	}
+
+	var headers = make(map[string]string)
+	headers["Content-type"] = "application/json"
+
+	metadata = sanitizeMetadata(metadata)
+
+	connection, err := rhsmClient.getCertAuthConnection()
+	if err != nil {
+		return false, fmt.Errorf("unable to get consumer cert auth connection: %v", err)
+	}
+	res, err := connection.request(
+		ctx,
+		rhsmClient.UserAgent,
+		http.MethodPut,
+		"consumers/"+*consumerUuid,
+		"",
+		"",
+		&headers,
+		&body,
+		metadata,
+	)
+	if err != nil {
+		return false, fmt.Errorf("unable to update facts: %s", err)
+	}
+
+	defer func() {
+		// We can ignore error returning, by Close(), because we only
+		// read content of body
+		_ = res.Body.Close()
+	}()
+
+	if res.StatusCode != 200 && res.StatusCode != 204 {
+		return false, fmt.Errorf("unable to update facts: %w", newCandlepinError(res))
+	}
+
+	err = rhsmClient.writeFactsCache(currentFacts)
+	if err != nil {
+		rhsmClient.getLogger().Warn().Msgf("unable to write facts cache: %s", err)
+	}
+
+	rhsmClient.getLogger().Info().Msg("facts updated")
+
+	return true, nil
+}
@@ -0,0 +1,156 @@
+package rhsm2
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestUpdateFacts test that facts are sent to the server only, when
+// facts were changed since the last update
+func TestUpdateFacts(t *testing.T) {
+	t.Parallel()
+	expectedConsumerUUID := "5e9745d5-624d-4af1-916e-2c17df4eb4e8"
+	handlerCounter := 0
+	var updateData updateFactsData
+
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			expectedURL := "/consumers/" + expectedConsumerUUID
+			if req.Method != http.MethodPut || req.URL.String() != expectedURL {
+				t.Fatalf("unexpected REST API call: %s %s", req.Method, req.URL.String())
+			}
+			handlerCounter += 1
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatalf("unable to read body of request: %s", err)
+			}
+			err = json.Unmarshal(body, &updateData)
+			if err != nil {
+				t.Fatalf("unable to unmarshal body of request: %s", err)
+			}
+			rw.WriteHeader(204)
+		}))
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, true, true, true, false, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	// Use custom facts in writable directory to be able to change facts
+	customFactsDirPath := filepath.Join(tempDirFilePath, "etc/rhsm/facts")
+	err = os.MkdirAll(customFactsDirPath, 0755)
+	if err != nil {
+		t.Fatalf("unable to create directory for custom facts: %s", err)
+	}
+	rhsmClient.SetCustomFactsDirPath(customFactsDirPath)
+
+	// There is no cache. Thus, facts have to be sent
+	updated, err := rhsmClient.UpdateFacts(nil)
+	if err != nil {
+		t.Fatalf("UpdateFacts() returned error: %s", err)
+	}
+	if !updated || handlerCounter != 1 {
+		t.Fatalf("facts not sent to the server, when there is no cache")
+	}
+	if updateData.Facts["cpu.cpu(s)"] != "4" {
+		t.Errorf("unexpected facts sent to the server: %v", updateData.Facts)
+	}
+
+	factsCache, err := rhsmClient.ReadFactsCache()
+	if err != nil {
+		t.Fatalf("unable to read facts cache: %s", err)
+	}
+	if factsCache.Facts["cpu.cpu(s)"] != "4" {
+		t.Errorf("unexpected facts in cache: %v", factsCache.Facts)
+	}
+
+	// Nothing changed. Thus, facts should not be sent
+	updated, err = rhsmClient.UpdateFacts(nil)
+	if err != nil {
+		t.Fatalf("UpdateFacts() returned error: %s", err)
+	}
+	if updated || handlerCounter != 1 {
+		t.Fatalf("facts sent to the server, when facts were not changed")
+	}
+
+	// Change custom facts. Thus, facts should be sent again
+	err = os.WriteFile(
+		filepath.Join(customFactsDirPath, "site.facts"),
+		[]byte(`{"site.name": "brno"}`),
+		0644,
+	)
+	if err != nil {
+		t.Fatalf("unable to write custom facts: %s", err)
+	}
+
+	changed, _, err := rhsmClient.FactsChanged()
+	if err != nil {
+		t.Fatalf("FactsChanged() returned error: %s", err)
+	}
+	if !changed {
+		t.Fatalf("FactsChanged() did not detect change of custom facts")
+	}
+
+	updated, err = rhsmClient.UpdateFacts(nil)
+	if err != nil {
+		t.Fatalf("UpdateFacts() returned error: %s", err)
+	}
+	if !updated || handlerCounter != 2 {
+		t.Fatalf("facts not sent to the server, when facts were changed")
+	}
+	if updateData.Facts["site.name"] != "brno" {
+		t.Errorf("changed custom fact not sent to the server: %v", updateData.Facts)
+	}
+}
+
+// TestUpdateFactsServerError test that cache is not written, when
+// server refuses update of facts
+func TestUpdateFactsServerError(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.WriteHeader(500)
+			_, _ = rw.Write([]byte(response500))
+		}))
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, true, true, true, false, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	updated, err := rhsmClient.UpdateFacts(nil)
+	if err == nil {
+		t.Fatalf("UpdateFacts() should return error, when server returns 500")
+	}
+	if updated {
+		t.Errorf("UpdateFacts() should not report updated facts")
+	}
+
+	_, err = rhsmClient.ReadFactsCache()
+	if err == nil {
+		t.Errorf("facts cache should not be written, when update failed")
+	}
+}
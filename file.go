@@ -0,0 +1,117 @@
+package rhsm2
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+)
+
+// FilePermissions is the policy of access permissions used for files
+// written by RHSMClient
+type FilePermissions struct {
+	// Cert is the mode of consumer and entitlement certificates
+	Cert os.FileMode
+	// Key is the mode of consumer and entitlement keys
+	Key os.FileMode
+	// Config is the mode of repo files, dnf variables, cache files and other configuration files
+	Config os.FileMode
+	// Dir is the mode of directories created for written files. When it is
+	// not set, then the mode of directories from DefaultFilePermissions is used
+	Dir os.FileMode
+}
+
+// DefaultFilePermissions returns the default policy of access permissions.
+// Keys are readable only by the owner.
+func DefaultFilePermissions() FilePermissions {
+	return FilePermissions{
+		Cert:   0644,
+		Key:    0600,
+		Config: 0644,
+		Dir:    0755,
+	}
+}
+
+// SetFilePermissions sets the policy of access permissions used for files
+// written by RHSMClient
+func (rhsmClient *RHSMClient) SetFilePermissions(filePermissions FilePermissions) {
+	rhsmClient.RHSMConf.filePermissions = &filePermissions
+}
+
+// getFilePermissions returns the current policy of access permissions
+func (rhsmClient *RHSMClient) getFilePermissions() FilePermissions {
+	if rhsmClient.RHSMConf.filePermissions == nil {
+		return DefaultFilePermissions()
+	}
+	filePermissions := *rhsmClient.RHSMConf.filePermissions
+	if filePermissions.Dir == 0 {
+		filePermissions.Dir = DefaultFilePermissions().Dir
+	}
+	return filePermissions
+}
+
+// writeFileAtomically tries to write content to the file atomically. The content
+// is written to temporary file in the same directory first. The temporary
+// file is synced to the disk, the access permissions are set and then the
+// temporary file is renamed to the final file path. Thus, the file is never
+// half-written, even when the process crashes during writing.
+func writeFileAtomically(logger *zerolog.Logger, filePath string, content []byte, mode os.FileMode) error {
+	dirPath := filepath.Dir(filePath)
+
+	tempFile, err := os.CreateTemp(dirPath, "."+filepath.Base(filePath)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file in %s: %s", dirPath, err)
+	}
+	tempFilePath := tempFile.Name()
+
+	// Remove temporary file, when something goes wrong
+	renamed := false
+	defer func() {
+		if !renamed {
+			_ = os.Remove(tempFilePath)
+		}
+	}()
+
+	_, err = tempFile.Write(content)
+	if err != nil {
+		_ = tempFile.Close()
+		return fmt.Errorf("unable to write to %s: %s", tempFilePath, err)
+	}
+
+	err = tempFile.Sync()
+	if err != nil {
+		_ = tempFile.Close()
+		return fmt.Errorf("unable to sync %s: %s", tempFilePath, err)
+	}
+
+	// Set access permission explicitly, because umask is applied to temporary file
+	err = tempFile.Chmod(mode)
+	if err != nil {
+		_ = tempFile.Close()
+		return fmt.Errorf("unable to change access permission of %s to (%v): %v", tempFilePath, mode, err)
+	}
+
+	err = tempFile.Close()
+	if err != nil {
+		return fmt.Errorf("unable to close %s: %s", tempFilePath, err)
+	}
+
+	err = os.Rename(tempFilePath, filePath)
+	if err != nil {
+		return fmt.Errorf("unable to rename %s to %s: %s", tempFilePath, filePath, err)
+	}
+	renamed = true
+
+	// Try to sync directory to be sure that renaming is persistent
+	dir, err := os.Open(dirPath)
+	if err == nil {
+		err = dir.Sync()
+		if err != nil {
+			logger.Debug().Msgf("unable to sync directory %s: %s", dirPath, err)
+		}
+		_ = dir.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package rhsm2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+)
+
+// TestWriteFileAtomically test that file is written with given access permissions,
+// existing file is replaced and no temporary file is left in the directory
+func TestWriteFileAtomically(t *testing.T) {
+	t.Parallel()
+	tempDirFilePath := t.TempDir()
+	filePath := filepath.Join(tempDirFilePath, "foo.pem")
+
+	err := os.WriteFile(filePath, []byte("old content"), 0666)
+	if err != nil {
+		t.Fatalf("unable to create testing file: %s", err)
+	}
+
+	err = writeFileAtomically(&log.Logger, filePath, []byte("new content"), 0600)
+	if err != nil {
+		t.Fatalf("writeFileAtomically() returned error: %s", err)
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("unable to read %s: %s", filePath, err)
+	}
+	if string(content) != "new content" {
+		t.Errorf("unexpected content of file: %s", content)
+	}
+
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("unable to stat %s: %s", filePath, err)
+	}
+	if fileInfo.Mode().Perm() != 0600 {
+		t.Errorf("expected mode: %v, got: %v", os.FileMode(0600), fileInfo.Mode().Perm())
+	}
+
+	entries, err := os.ReadDir(tempDirFilePath)
+	if err != nil {
+		t.Fatalf("unable to read %s: %s", tempDirFilePath, err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary file was not removed from %s", tempDirFilePath)
+	}
+}
+
+// TestWriteFileAtomicallyMissingDir test that error is returned, when
+// the directory does not exist
+func TestWriteFileAtomicallyMissingDir(t *testing.T) {
+	t.Parallel()
+	filePath := filepath.Join(t.TempDir(), "missing", "foo.pem")
+
+	err := writeFileAtomically(&log.Logger, filePath, []byte("content"), 0644)
+	if err == nil {
+		t.Fatalf("writeFileAtomically() should return error, when directory does not exist")
+	}
+}
+
+// TestEntitlementKeyPermissions test that entitlement keys are written with
+// access permissions given by the policy of access permissions
+func TestEntitlementKeyPermissions(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name            string
+		filePermissions *FilePermissions
+		expectedCert    os.FileMode
+		expectedKey     os.FileMode
+	}{
+		{
+			name:         "default permissions",
+			expectedCert: 0644,
+			expectedKey:  0600,
+		},
+		{
+			name:            "custom permissions",
+			filePermissions: &FilePermissions{Cert: 0640, Key: 0640, Config: 0640},
+			expectedCert:    0640,
+			expectedKey:     0640,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDirFilePath := t.TempDir()
+			testingFiles, err := setupTestingFileSystem(tempDirFilePath, false, true, false, false, true)
+			if err != nil {
+				t.Fatalf("unable to setup testing environment: %s", err)
+			}
+
+			rhsmClient, err := setupTestingRHSMClient(testingFiles, nil, nil)
+			if err != nil {
+				t.Fatalf("unable to setup testing rhsm client: %s", err)
+			}
+			if tt.filePermissions != nil {
+				rhsmClient.SetFilePermissions(*tt.filePermissions)
+			}
+
+			content := "-----BEGIN FOO-----\nfoo\n-----END FOO-----\n"
+			certFilePath, err := rhsmClient.writeEntitlementCert(&content, 1234)
+			if err != nil {
+				t.Fatalf("%s: writeEntitlementCert() returned error: %s", tt.name, err)
+			}
+			keyFilePath, err := rhsmClient.writeEntitlementKey(&content, 1234)
+			if err != nil {
+				t.Fatalf("%s: writeEntitlementKey() returned error: %s", tt.name, err)
+			}
+
+			for filePath, expectedMode := range map[string]os.FileMode{
+				*certFilePath: tt.expectedCert,
+				*keyFilePath:  tt.expectedKey,
+			} {
+				fileInfo, err := os.Stat(filePath)
+				if err != nil {
+					t.Fatalf("unable to stat %s: %s", filePath, err)
+				}
+				if fileInfo.Mode().Perm() != expectedMode {
+					t.Errorf("%s: expected mode of %s: %v, got: %v",
+						tt.name, filePath, expectedMode, fileInfo.Mode().Perm())
+				}
+			}
+		})
+	}
+}
+
+// TestDirPermissions test that directories are created with access permissions
+// given by the policy of access permissions and that the default mode of directories
+// is used, when the policy does not set it
+func TestDirPermissions(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name            string
+		filePermissions *FilePermissions
+		expectedDir     os.FileMode
+		expectedConfig  os.FileMode
+	}{
+		{
+			name:           "default permissions",
+			expectedDir:    0755,
+			expectedConfig: 0644,
+		},
+		{
+			name:            "custom permissions",
+			filePermissions: &FilePermissions{Cert: 0640, Key: 0600, Config: 0640, Dir: 0750},
+			expectedDir:     0750,
+			expectedConfig:  0640,
+		},
+		{
+			name:            "custom permissions without mode of directories",
+			filePermissions: &FilePermissions{Cert: 0640, Key: 0600, Config: 0640},
+			expectedDir:     0755,
+			expectedConfig:  0640,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			testingFiles, err := setupTestingFileSystem(t.TempDir(), false, true, false, false, true)
+			if err != nil {
+				t.Fatalf("unable to setup testing environment: %s", err)
+			}
+
+			rhsmClient, err := setupTestingRHSMClient(testingFiles, nil, nil)
+			if err != nil {
+				t.Fatalf("unable to setup testing rhsm client: %s", err)
+			}
+			if tt.filePermissions != nil {
+				rhsmClient.SetFilePermissions(*tt.filePermissions)
+			}
+
+			cacheFilePath := filepath.Join(t.TempDir(), "cache", "foo.json")
+			err = writeJSONCache(rhsmClient.getLogger(), cacheFilePath, map[string]string{"foo": "bar"}, rhsmClient.getFilePermissions())
+			if err != nil {
+				t.Fatalf("writeJSONCache() returned error: %s", err)
+			}
+
+			for filePath, expectedMode := range map[string]os.FileMode{
+				filepath.Dir(cacheFilePath): tt.expectedDir,
+				cacheFilePath:               tt.expectedConfig,
+			} {
+				fileInfo, err := os.Stat(filePath)
+				if err != nil {
+					t.Fatalf("unable to stat %s: %s", filePath, err)
+				}
+				if fileInfo.Mode().Perm() != expectedMode {
+					t.Errorf("expected mode of %s: %v, got: %v", filePath, expectedMode, fileInfo.Mode().Perm())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package rhsm2
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultIdentityRenewalThreshold is the time before expiration of consumer
+// certificate, when the consumer certificate should be renewed
+const DefaultIdentityRenewalThreshold = 90 * 24 * time.Hour
+
+// readConsumerCertificate tries to read and parse installed consumer certificate
+func (rhsmClient *RHSMClient) readConsumerCertificate() (*x509.Certificate, error) {
+	consumerCertFilePath := rhsmClient.consumerCertPath()
+	consumerCert, err := os.ReadFile(*consumerCertFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read consumer certificate: %v", err)
+	}
+
+	block, _ := pem.Decode(consumerCert)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("file %s does not contain CERTIFICATE block", *consumerCertFilePath)
+	}
+
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PEM certificate: %s: %v", *consumerCertFilePath, err)
+	}
+
+	return certificate, nil
+}
+
+// identityNeedsRenewal returns true, when the certificate is not valid at the
+// given time or it expires in less than the given threshold
+func identityNeedsRenewal(certificate *x509.Certificate, now time.Time, threshold time.Duration) bool {
+	if now.Before(certificate.NotBefore) {
+		return true
+	}
+	return certificate.NotAfter.Sub(now) < threshold
+}
+
+// IdentityNeedsRenewal tries to check validity window of installed consumer
+// certificate. It returns true, when the certificate expires in less than
+// DefaultIdentityRenewalThreshold or it is not valid anymore.
+func (rhsmClient *RHSMClient) IdentityNeedsRenewal() (bool, error) {
+	certificate, err := rhsmClient.readConsumerCertificate()
+	if err != nil {
+		return false, err
+	}
+	return identityNeedsRenewal(certificate, time.Now(), DefaultIdentityRenewalThreshold), nil
+}
+
+// replaceConsumerCertKey tries to replace installed consumer certificate and key
+// atomically. New certificate and key are written to temporary files in the same
+// directory first and then these files are renamed. Thus, the consumer cert and
+// key are never half-written. Installed certificate and key are backed up before
+// renaming, and both files are restored, when it is not possible to replace any
+// of them, because consumer key has to always match consumer certificate.
+func (rhsmClient *RHSMClient) replaceConsumerCertKey(consumerCert *string, consumerKey *string) error {
+	consumerCertFilePath := rhsmClient.consumerCertPath()
+	consumerKeyFilePath := rhsmClient.consumerKeyPath()
+	newConsumerCertFilePath := *consumerCertFilePath + ".new"
+	newConsumerKeyFilePath := *consumerKeyFilePath + ".new"
+	oldConsumerCertFilePath := *consumerCertFilePath + ".old"
+	oldConsumerKeyFilePath := *consumerKeyFilePath + ".old"
+
+	removeNewFiles := func() {
+		_ = os.Remove(newConsumerCertFilePath)
+		_ = os.Remove(newConsumerKeyFilePath)
+	}
+	removeOldFiles := func() {
+		_ = os.Remove(oldConsumerCertFilePath)
+		_ = os.Remove(oldConsumerKeyFilePath)
+	}
+
+	err := rhsmClient.writeConsumerCert(&newConsumerCertFilePath, consumerCert)
+	if err != nil {
+		removeNewFiles()
+		return fmt.Errorf("unable to write new consumer certificate: %s", err)
+	}
+	err = rhsmClient.writeConsumerKey(&newConsumerKeyFilePath, consumerKey)
+	if err != nil {
+		removeNewFiles()
+		return fmt.Errorf("unable to write new consumer key: %s", err)
+	}
+
+	// Back up installed consumer certificate and key using hard links. Installed
+	// files stay in place, until they are replaced by renaming of new files.
+	removeOldFiles()
+	backups := map[string]string{
+		*consumerCertFilePath: oldConsumerCertFilePath,
+		*consumerKeyFilePath:  oldConsumerKeyFilePath,
+	}
+	for filePath, backupFilePath := range backups {
+		err = os.Link(filePath, backupFilePath)
+		if err != nil && !os.IsNotExist(err) {
+			removeNewFiles()
+			removeOldFiles()
+			return fmt.Errorf("unable to back up %s: %s", filePath, err)
+		}
+	}
+
+	// restoreOldFiles tries to restore backed up consumer certificate and key
+	restoreOldFiles := func() {
+		for filePath, backupFilePath := range backups {
+			if _, err := os.Stat(backupFilePath); err != nil {
+				// There was nothing to back up
+				_ = os.Remove(filePath)
+				continue
+			}
+			err := os.Rename(backupFilePath, filePath)
+			if err != nil {
+				rhsmClient.getLogger().Error().Msgf("unable to restore %s from %s: %s",
+					filePath, backupFilePath, err)
+			}
+		}
+	}
+
+	err = os.Rename(newConsumerKeyFilePath, *consumerKeyFilePath)
+	if err != nil {
+		removeNewFiles()
+		removeOldFiles()
+		return fmt.Errorf("unable to replace consumer key: %s", err)
+	}
+	err = os.Rename(newConsumerCertFilePath, *consumerCertFilePath)
+	if err != nil {
+		restoreOldFiles()
+		removeNewFiles()
+		removeOldFiles()
+		return fmt.Errorf("unable to replace consumer certificate: %s", err)
+	}
+
+	removeOldFiles()
+
+	return nil
+}
+
+// RenewIdentity tries to renew consumer certificate, when installed consumer
+// certificate is close to expiration. New consumer certificate and key
+// are installed and consumer cert auth connection is recreated. It returns
+// true, when the consumer certificate was renewed.
+func (rhsmClient *RHSMClient) RenewIdentity(metadata *RequestMetadata) (bool, error) {
+	return rhsmClient.RenewIdentityWithContext(context.Background(), metadata)
+}
+
+// RenewIdentityWithContext is like RenewIdentity, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) RenewIdentityWithContext(ctx context.Context, metadata *RequestMetadata) (bool, error) {
+	// Regenerating of consumer certificate can be safely retried, because
+	// the server always returns the latest consumer certificate
+	ctx = WithRetryablePost(ctx)
+
+	needsRenewal, err := rhsmClient.IdentityNeedsRenewal()
+	if err != nil {
+		return false, err
+	}
+
+	if !needsRenewal {
+		rhsmClient.getLogger().Debug().Msg("consumer certificate is not close to expiration, skipping renewal")
+		return false, nil
+	}
+
+	consumerUuid, err := rhsmClient.GetConsumerUUID()
+	if err != nil {
+		return false, fmt.Errorf("unable to get consumer uuid: %v", err)
+	}
+
+	var headers = make(map[string]string)
+
+	metadata = sanitizeMetadata(metadata)
+
+	connection, err := rhsmClient.getCertAuthConnection()
+	if err != nil {
+		return false, fmt.Errorf("unable to get consumer cert auth connection: %v", err)
+	}
+	res, err := connection.request(
+		ctx,
+		rhsmClient.UserAgent,
+		http.MethodPost,
+		"consumers/"+*consumerUuid,
+		"",
+		"",
+		&headers,
+		nil,
+		metadata,
+	)
+	if err != nil {
+		return false, fmt.Errorf("unable to renew consumer certificate: %s", err)
+	}
+
+	if res.StatusCode != 200 {
+		return false, fmt.Errorf("unable to renew consumer certificate: %w", newCandlepinError(res))
+	}
+
+	resBody, err := getResponseBody(res)
+	if err != nil {
+		return false, err
+	}
+
+	consumerData := ConsumerData{}
+	err = json.Unmarshal([]byte(*resBody), &consumerData)
+	if err != nil {
+		return false, fmt.Errorf("unable to parse consumer object: %s", err)
+	}
+
+	err = rhsmClient.replaceConsumerCertKey(&consumerData.IdCert.Cert, &consumerData.IdCert.Key)
+	if err != nil {
+		return false, err
+	}
+
+	rhsmClient.getLogger().Info().Msgf("consumer certificate renewed, new expiration: %s",
+		consumerData.IdCert.Serial.Expiration)
+
+	certFilePath := filepath.Join(rhsmClient.RHSMConf.RHSM.ConsumerCertDir, "cert.pem")
+	keyFilePath := filepath.Join(rhsmClient.RHSMConf.RHSM.ConsumerCertDir, "key.pem")
+	err = rhsmClient.createCertAuthConnection(
+		&rhsmClient.RHSMConf.Server.Hostname,
+		&rhsmClient.RHSMConf.Server.Port,
+		&rhsmClient.RHSMConf.Server.Prefix,
+		&certFilePath,
+		&keyFilePath,
+	)
+	if err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
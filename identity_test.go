@@ -0,0 +1,282 @@
+package rhsm2
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// generateTestingCertKey tries to generate self-signed certificate and key
+// in PEM format with given common name and validity window
+func generateTestingCertKey(t *testing.T, commonName string, notBefore time.Time, notAfter time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"donaldduck"}},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("unable to marshal key: %s", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return string(certPEM), string(keyPEM)
+}
+
+// TestIdentityNeedsRenewal test detection of certificates close to expiration
+func TestIdentityNeedsRenewal(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		notBefore time.Time
+		notAfter  time.Time
+		want      bool
+	}{
+		{
+			name:      "valid for long time",
+			notBefore: now.AddDate(-1, 0, 0),
+			notAfter:  now.AddDate(1, 0, 0),
+			want:      false,
+		},
+		{
+			name:      "close to expiration",
+			notBefore: now.AddDate(-1, 0, 0),
+			notAfter:  now.AddDate(0, 0, 10),
+			want:      true,
+		},
+		{
+			name:      "expired",
+			notBefore: now.AddDate(-1, 0, 0),
+			notAfter:  now.AddDate(0, 0, -1),
+			want:      true,
+		},
+		{
+			name:      "not valid yet",
+			notBefore: now.AddDate(0, 0, 1),
+			notAfter:  now.AddDate(1, 0, 0),
+			want:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificate := &x509.Certificate{NotBefore: tt.notBefore, NotAfter: tt.notAfter}
+			got := identityNeedsRenewal(certificate, now, DefaultIdentityRenewalThreshold)
+			if got != tt.want {
+				t.Errorf("%s: identityNeedsRenewal() = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRenewIdentityNotNeeded test that no REST API is called, when consumer
+// certificate is not close to expiration
+func TestRenewIdentityNotNeeded(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			t.Fatalf("unexpected REST API call: %s %s", req.Method, req.URL.String())
+		}))
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, true, true, true, false, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	renewed, err := rhsmClient.RenewIdentity(nil)
+	if err != nil {
+		t.Fatalf("RenewIdentity() returned error: %s", err)
+	}
+	if renewed {
+		t.Fatalf("consumer certificate renewed, when it is not close to expiration")
+	}
+}
+
+// TestRenewIdentity test that consumer certificate close to expiration is
+// renewed, installed and the connection is recreated
+func TestRenewIdentity(t *testing.T) {
+	t.Parallel()
+	consumerUUID := "5e9745d5-624d-4af1-916e-2c17df4eb4e8"
+	handlerCounter := 0
+	now := time.Now()
+
+	newCert, newKey := generateTestingCertKey(t, consumerUUID, now.Add(-time.Hour), now.AddDate(1, 0, 0))
+
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodPost || req.URL.String() != "/consumers/"+consumerUUID {
+				t.Fatalf("unexpected REST API call: %s %s", req.Method, req.URL.String())
+			}
+			handlerCounter += 1
+			response, err := json.Marshal(map[string]interface{}{
+				"uuid": consumerUUID,
+				"idCert": map[string]interface{}{
+					"cert": newCert,
+					"key":  newKey,
+					"serial": map[string]interface{}{
+						"expiration": now.AddDate(1, 0, 0).Format(time.RFC3339),
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("unable to create response: %s", err)
+			}
+			rw.WriteHeader(200)
+			_, _ = rw.Write(response)
+		}))
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, true, false, false, false, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	// Install consumer certificate, which expires tomorrow
+	oldCert, oldKey := generateTestingCertKey(t, consumerUUID, now.AddDate(-1, 0, 0), now.AddDate(0, 0, 1))
+	err = rhsmClient.writeConsumerCert(rhsmClient.consumerCertPath(), &oldCert)
+	if err != nil {
+		t.Fatalf("unable to install consumer certificate: %s", err)
+	}
+	err = rhsmClient.writeConsumerKey(rhsmClient.consumerKeyPath(), &oldKey)
+	if err != nil {
+		t.Fatalf("unable to install consumer key: %s", err)
+	}
+
+	oldConnection := rhsmClient.consumerCertAuthConnection
+
+	renewed, err := rhsmClient.RenewIdentity(nil)
+	if err != nil {
+		t.Fatalf("RenewIdentity() returned error: %s", err)
+	}
+	if !renewed || handlerCounter != 1 {
+		t.Fatalf("consumer certificate not renewed, when it is close to expiration")
+	}
+
+	installedCert, err := os.ReadFile(*rhsmClient.consumerCertPath())
+	if err != nil {
+		t.Fatalf("unable to read installed consumer certificate: %s", err)
+	}
+	if string(installedCert) != newCert {
+		t.Errorf("new consumer certificate was not installed")
+	}
+	installedKey, err := os.ReadFile(*rhsmClient.consumerKeyPath())
+	if err != nil {
+		t.Fatalf("unable to read installed consumer key: %s", err)
+	}
+	if string(installedKey) != newKey {
+		t.Errorf("new consumer key was not installed")
+	}
+
+	if _, err := os.Stat(*rhsmClient.consumerCertPath() + ".new"); err == nil {
+		t.Errorf("temporary consumer certificate was not removed")
+	}
+
+	if rhsmClient.consumerCertAuthConnection == oldConnection {
+		t.Errorf("consumer cert auth connection was not recreated")
+	}
+
+	needsRenewal, err := rhsmClient.IdentityNeedsRenewal()
+	if err != nil {
+		t.Fatalf("IdentityNeedsRenewal() returned error: %s", err)
+	}
+	if needsRenewal {
+		t.Errorf("renewed consumer certificate still needs renewal")
+	}
+}
+
+// TestReplaceConsumerCertKeyFailure test that installed consumer key is kept,
+// when it is not possible to replace consumer certificate
+func TestReplaceConsumerCertKeyFailure(t *testing.T) {
+	t.Parallel()
+	consumerUUID := "5e9745d5-624d-4af1-916e-2c17df4eb4e8"
+	now := time.Now()
+
+	tempDirFilePath := t.TempDir()
+
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, true, false, false, false, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	_, oldKey := generateTestingCertKey(t, consumerUUID, now.AddDate(-1, 0, 0), now.AddDate(0, 0, 1))
+	err = rhsmClient.writeConsumerKey(rhsmClient.consumerKeyPath(), &oldKey)
+	if err != nil {
+		t.Fatalf("unable to install consumer key: %s", err)
+	}
+
+	// Consumer certificate cannot be replaced, because it is not a file
+	_ = os.Remove(*rhsmClient.consumerCertPath())
+	err = os.MkdirAll(filepath.Join(*rhsmClient.consumerCertPath(), "dir"), 0755)
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err)
+	}
+
+	newCert, newKey := generateTestingCertKey(t, consumerUUID, now.Add(-time.Hour), now.AddDate(1, 0, 0))
+	err = rhsmClient.replaceConsumerCertKey(&newCert, &newKey)
+	if err == nil {
+		t.Fatalf("no error returned, when consumer certificate cannot be replaced")
+	}
+
+	installedKey, err := os.ReadFile(*rhsmClient.consumerKeyPath())
+	if err != nil {
+		t.Fatalf("unable to read installed consumer key: %s", err)
+	}
+	if string(installedKey) != oldKey {
+		t.Errorf("consumer key was replaced, when consumer certificate was not replaced")
+	}
+
+	for _, filePath := range []string{
+		*rhsmClient.consumerKeyPath() + ".new",
+		*rhsmClient.consumerKeyPath() + ".old",
+		*rhsmClient.consumerCertPath() + ".new",
+		*rhsmClient.consumerCertPath() + ".old",
+	} {
+		if _, err := os.Stat(filePath); err == nil {
+			t.Errorf("temporary file %s was not removed", filePath)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package rhsm2
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -33,6 +34,17 @@ func (rhsmClient *RHSMClient) GetOrgs(
 	username string,
 	password string,
 	metadata *RequestMetadata,
+) ([]OrganizationData, error) {
+	return rhsmClient.GetOrgsWithContext(context.Background(), username, password, metadata)
+}
+
+// GetOrgsWithContext is like GetOrgs, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) GetOrgsWithContext(
+	ctx context.Context,
+	username string,
+	password string,
+	metadata *RequestMetadata,
 ) ([]OrganizationData, error) {
 	var organizations []OrganizationData
 	var headers = make(map[string]string)
@@ -48,6 +60,7 @@ func (rhsmClient *RHSMClient) GetOrgs(
 	}
 
 	res, err := connection.request(
+		ctx,
 		rhsmClient.UserAgent,
 		http.MethodGet,
 		"users/"+username+"/owners",
@@ -60,6 +73,10 @@ func (rhsmClient *RHSMClient) GetOrgs(
 		return organizations, fmt.Errorf("unable to get list of org IDs: %s", err)
 	}
 
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("unable to get list of org IDs: %w", newCandlepinError(res))
+	}
+
 	resBody, err := getResponseBody(res)
 	if err != nil {
 		return nil, err
@@ -75,6 +92,12 @@ func (rhsmClient *RHSMClient) GetOrgs(
 
 // GetOrg tries to get a current organization for the current consumer
 func (rhsmClient *RHSMClient) GetOrg(metadata *RequestMetadata) (*OrganizationData, error) {
+	return rhsmClient.GetOrgWithContext(context.Background(), metadata)
+}
+
+// GetOrgWithContext is like GetOrg, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) GetOrgWithContext(ctx context.Context, metadata *RequestMetadata) (*OrganizationData, error) {
 	consumerUuid, err := rhsmClient.GetConsumerUUID()
 
 	if err != nil {
@@ -90,6 +113,7 @@ func (rhsmClient *RHSMClient) GetOrg(metadata *RequestMetadata) (*OrganizationDa
 	}
 
 	res, err := connection.request(
+		ctx,
 		rhsmClient.UserAgent,
 		http.MethodGet,
 		"consumers/"+*consumerUuid+"/owner",
@@ -103,6 +127,10 @@ func (rhsmClient *RHSMClient) GetOrg(metadata *RequestMetadata) (*OrganizationDa
 		return nil, fmt.Errorf("unable to get organization: %s", err)
 	}
 
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("unable to get organization: %w", newCandlepinError(res))
+	}
+
 	resBody, err := getResponseBody(res)
 	if err != nil {
 		return nil, err
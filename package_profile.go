@@ -0,0 +1,258 @@
+package rhsm2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultPackageProfileCacheFilePath is the file path of the cache with
+// package profile uploaded to the candlepin server last time
+const DefaultPackageProfileCacheFilePath = DefaultCacheDirPath + "/packages/packages.json"
+
+// Package is structure representing one installed package in package profile
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Release string `json:"release"`
+	Epoch   int    `json:"epoch"`
+	Arch    string `json:"arch"`
+	Vendor  string `json:"vendor"`
+}
+
+// PackageProvider is interface of provider of installed packages. The default
+// provider uses rpm command, but it is possible to use another provider (e.g.
+// fake provider in unit tests)
+type PackageProvider interface {
+	// InstalledPackages returns the list of installed packages
+	InstalledPackages() ([]Package, error)
+}
+
+// PackageProfile is structure representing one profile uploaded to the candlepin server
+type PackageProfile struct {
+	ContentType string    `json:"content_type"`
+	Profile     []Package `json:"profile"`
+}
+
+// packageProfileCache is structure representing package profile cached on disk
+type packageProfileCache struct {
+	Hash     string           `json:"hash"`
+	Profiles []PackageProfile `json:"profiles"`
+}
+
+// rpmPackageProvider is the default provider of installed packages.
+// It uses rpm command for getting list of installed packages.
+type rpmPackageProvider struct {
+	// rootDirPath is the root directory of the managed system. When it is not
+	// "/", then rpm database in this root directory is queried
+	rootDirPath string
+}
+
+// rpmQueryFormat is the format of rpm query output. Values are separated by tabs
+const rpmQueryFormat = "%{NAME}\t%{VERSION}\t%{RELEASE}\t%{EPOCH}\t%{ARCH}\t%{VENDOR}\n"
+
+// rpmQueryArguments returns arguments of rpm command used for querying installed packages
+func (provider *rpmPackageProvider) rpmQueryArguments() []string {
+	var args []string
+	if provider.rootDirPath != "" && filepath.Clean(provider.rootDirPath) != "/" {
+		args = append(args, "--root", provider.rootDirPath)
+	}
+	return append(args, "--query", "--all", "--queryformat", rpmQueryFormat)
+}
+
+// InstalledPackages tries to get the list of installed RPM packages
+func (provider *rpmPackageProvider) InstalledPackages() ([]Package, error) {
+	output, err := exec.Command("rpm", provider.rpmQueryArguments()...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to query installed RPM packages: %s", err)
+	}
+	return parseRPMQueryOutput(output), nil
+}
+
+// parseRPMQueryOutput tries to parse output of rpm query using rpmQueryFormat
+func parseRPMQueryOutput(output []byte) []Package {
+	var packages []Package
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 6 {
+			continue
+		}
+		// Epoch is "(none)" for most packages
+		epoch, err := strconv.Atoi(fields[3])
+		if err != nil {
+			epoch = 0
+		}
+		vendor := fields[5]
+		if vendor == "(none)" {
+			vendor = ""
+		}
+		packages = append(packages, Package{
+			Name:    fields[0],
+			Version: fields[1],
+			Release: fields[2],
+			Epoch:   epoch,
+			Arch:    fields[4],
+			Vendor:  vendor,
+		})
+	}
+	return packages
+}
+
+// SetPackageProvider sets the provider of installed packages used for
+// creating package profile
+func (rhsmClient *RHSMClient) SetPackageProvider(provider PackageProvider) {
+	rhsmClient.packageProvider = provider
+}
+
+// createPackageProfiles tries to create the list of package profiles. Packages
+// are sorted to get the same hash for the same set of installed packages.
+func (rhsmClient *RHSMClient) createPackageProfiles() ([]PackageProfile, error) {
+	provider := rhsmClient.packageProvider
+	if provider == nil {
+		provider = &rpmPackageProvider{rootDirPath: rhsmClient.RHSMConf.RootDirPath()}
+	}
+
+	packages, err := provider.InstalledPackages()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].Name != packages[j].Name {
+			return packages[i].Name < packages[j].Name
+		}
+		if packages[i].Arch != packages[j].Arch {
+			return packages[i].Arch < packages[j].Arch
+		}
+		if packages[i].Version != packages[j].Version {
+			return packages[i].Version < packages[j].Version
+		}
+		return packages[i].Release < packages[j].Release
+	})
+
+	if packages == nil {
+		packages = []Package{}
+	}
+
+	return []PackageProfile{{ContentType: "rpm", Profile: packages}}, nil
+}
+
+// UploadPackageProfile tries to upload the profile of installed packages to the
+// candlepin server. The profile is uploaded only, when report_package_profile is
+// enabled in rhsm.conf, and when the profile changed since the last upload.
+// It returns true, when the profile was uploaded.
+func (rhsmClient *RHSMClient) UploadPackageProfile(metadata *RequestMetadata) (bool, error) {
+	return rhsmClient.UploadPackageProfileWithContext(context.Background(), metadata)
+}
+
+// UploadPackageProfileWithContext is like UploadPackageProfile, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) UploadPackageProfileWithContext(ctx context.Context, metadata *RequestMetadata) (bool, error) {
+	if !rhsmClient.RHSMConf.RHSM.ReportPackageProfile {
+		rhsmClient.getLogger().Debug().Msg("reporting of package profile is disabled in configuration file")
+		return false, nil
+	}
+
+	profiles, err := rhsmClient.createPackageProfiles()
+	if err != nil {
+		return false, fmt.Errorf("unable to create package profile: %s", err)
+	}
+
+	hash, err := computeHash(profiles)
+	if err != nil {
+		return false, fmt.Errorf("unable to compute hash of package profile: %s", err)
+	}
+
+	var cachedProfiles packageProfileCache
+	err = readJSONCache(rhsmClient.RHSMConf.packageProfileCacheFilePath, &cachedProfiles)
+	if err != nil {
+		rhsmClient.getLogger().Debug().Msgf("unable to read package profile cache: %s", err)
+	} else if cachedProfiles.Hash == hash {
+		rhsmClient.getLogger().Debug().Msg("package profile not changed, skipping upload of package profile")
+		return false, nil
+	}
+
+	consumerUuid, err := rhsmClient.GetConsumerUUID()
+	if err != nil {
+		return false, fmt.Errorf("unable to get consumer uuid: %v", err)
+	}
+
+	body, err := json.Marshal(profiles)
+	if err != nil {
+		return false, err
+	}
+
+	var headers = make(map[string]string)
+	headers["Content-type"] = "application/json"
+
+	metadata = sanitizeMetadata(metadata)
+
+	connection, err := rhsmClient.getCertAuthConnection()
+	if err != nil {
+		return false, fmt.Errorf("unable to get consumer cert auth connection: %v", err)
+	}
+	res, err := connection.request(
+		ctx,
+		rhsmClient.UserAgent,
+		http.MethodPut,
+		"consumers/"+*consumerUuid+"/profiles",
+		"",
+		"",
+		&headers,
+		&body,
+		metadata,
+	)
+	if err != nil {
+		return false, fmt.Errorf("unable to upload package profile: %s", err)
+	}
+
+	defer func() {
+		// We can ignore error returning, by Close(), because we only
+		// read content of body
+		_ = res.Body.Close()
+	}()
+
+	if res.StatusCode != 200 && res.StatusCode != 204 {
+		return false, fmt.Errorf("unable to upload package profile: %w", newCandlepinError(res))
+	}
+
+	err = writeJSONCache(
+		rhsmClient.getLogger(),
+		rhsmClient.RHSMConf.packageProfileCacheFilePath,
+		packageProfileCache{Hash: hash, Profiles: profiles},
+		rhsmClient.getFilePermissions(),
+	)
+	if err != nil {
+		rhsmClient.getLogger().Warn().Msgf("unable to write package profile cache: %s", err)
+	}
+
+	rhsmClient.getLogger().Info().Msgf("package profile uploaded (%d packages)", len(profiles[0].Profile))
+
+	return true, nil
+}
+
+// UploadPackageProfileOnTransaction tries to upload package profile after DNF
+// transaction. It is intended for DNF plugins. The profile is uploaded only,
+// when package_profile_on_trans is enabled in rhsm.conf
+func (rhsmClient *RHSMClient) UploadPackageProfileOnTransaction(metadata *RequestMetadata) (bool, error) {
+	return rhsmClient.UploadPackageProfileOnTransactionWithContext(context.Background(), metadata)
+}
+
+// UploadPackageProfileOnTransactionWithContext is like UploadPackageProfileOnTransaction, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) UploadPackageProfileOnTransactionWithContext(ctx context.Context, metadata *RequestMetadata) (bool, error) {
+	if !rhsmClient.RHSMConf.RHSM.PackageProfileOnTrans {
+		rhsmClient.getLogger().Debug().Msg("uploading of package profile on transaction is disabled in configuration file")
+		return false, nil
+	}
+	return rhsmClient.UploadPackageProfileWithContext(ctx, metadata)
+}
@@ -0,0 +1,214 @@
+package rhsm2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// fakePackageProvider is package provider used in unit tests
+type fakePackageProvider struct {
+	packages []Package
+	err      error
+}
+
+func (provider *fakePackageProvider) InstalledPackages() ([]Package, error) {
+	// Return copy of packages, because the list is sorted by the caller
+	packages := make([]Package, len(provider.packages))
+	copy(packages, provider.packages)
+	return packages, provider.err
+}
+
+// TestParseRPMQueryOutput test parsing of rpm query output
+func TestParseRPMQueryOutput(t *testing.T) {
+	t.Parallel()
+	output := []byte("bash\t5.2.26\t6.el10\t(none)\tx86_64\tRed Hat, Inc.\n" +
+		"openssl\t3.2.2\t16.el10\t1\tx86_64\tRed Hat, Inc.\n" +
+		"gpg-pubkey\tfd431d51\t4ae0493b\t(none)\t(none)\t(none)\n" +
+		"corrupted line\n")
+
+	packages := parseRPMQueryOutput(output)
+
+	expectedPackages := []Package{
+		{Name: "bash", Version: "5.2.26", Release: "6.el10", Epoch: 0, Arch: "x86_64", Vendor: "Red Hat, Inc."},
+		{Name: "openssl", Version: "3.2.2", Release: "16.el10", Epoch: 1, Arch: "x86_64", Vendor: "Red Hat, Inc."},
+		{Name: "gpg-pubkey", Version: "fd431d51", Release: "4ae0493b", Epoch: 0, Arch: "(none)", Vendor: ""},
+	}
+	if len(packages) != len(expectedPackages) {
+		t.Fatalf("parseRPMQueryOutput() returned %d packages, expected %d",
+			len(packages), len(expectedPackages))
+	}
+	for idx, expectedPackage := range expectedPackages {
+		if packages[idx] != expectedPackage {
+			t.Errorf("parseRPMQueryOutput() returned %v, expected %v", packages[idx], expectedPackage)
+		}
+	}
+}
+
+// TestUploadPackageProfile test that package profile is uploaded only, when
+// TestRPMQueryArguments test that rpm database in the root directory is queried
+func TestRPMQueryArguments(t *testing.T) {
+	t.Parallel()
+	queryArgs := []string{"--query", "--all", "--queryformat", rpmQueryFormat}
+	tests := []struct {
+		rootDirPath string
+		expected    []string
+	}{
+		{rootDirPath: "", expected: queryArgs},
+		{rootDirPath: "/", expected: queryArgs},
+		{rootDirPath: "/mnt/image", expected: append([]string{"--root", "/mnt/image"}, queryArgs...)},
+	}
+	for _, tt := range tests {
+		provider := &rpmPackageProvider{rootDirPath: tt.rootDirPath}
+		if args := provider.rpmQueryArguments(); !reflect.DeepEqual(args, tt.expected) {
+			t.Errorf("root directory %s: expected arguments: %v, got: %v", tt.rootDirPath, tt.expected, args)
+		}
+	}
+}
+
+// it is enabled in configuration file and when the profile changed
+func TestUploadPackageProfile(t *testing.T) {
+	t.Parallel()
+	expectedConsumerUUID := "5e9745d5-624d-4af1-916e-2c17df4eb4e8"
+	handlerCounter := 0
+	var uploadedProfiles []PackageProfile
+
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			expectedURL := "/consumers/" + expectedConsumerUUID + "/profiles"
+			if req.Method != http.MethodPut || req.URL.String() != expectedURL {
+				t.Fatalf("unexpected REST API call: %s %s", req.Method, req.URL.String())
+			}
+			handlerCounter += 1
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatalf("unable to read body of request: %s", err)
+			}
+			err = json.Unmarshal(body, &uploadedProfiles)
+			if err != nil {
+				t.Fatalf("unable to unmarshal body of request: %s", err)
+			}
+			rw.WriteHeader(204)
+		}))
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, true, true, true, false, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	provider := &fakePackageProvider{
+		packages: []Package{
+			{Name: "openssl", Version: "3.2.2", Release: "16.el10", Epoch: 1, Arch: "x86_64", Vendor: "Red Hat, Inc."},
+			{Name: "bash", Version: "5.2.26", Release: "6.el10", Epoch: 0, Arch: "x86_64", Vendor: "Red Hat, Inc."},
+		},
+	}
+	rhsmClient.SetPackageProvider(provider)
+
+	// Reporting of package profile is disabled
+	rhsmClient.RHSMConf.RHSM.ReportPackageProfile = false
+	uploaded, err := rhsmClient.UploadPackageProfile(nil)
+	if err != nil {
+		t.Fatalf("UploadPackageProfile() returned error: %s", err)
+	}
+	if uploaded || handlerCounter != 0 {
+		t.Fatalf("package profile uploaded, when reporting is disabled")
+	}
+
+	// Reporting is enabled and there is no cache
+	rhsmClient.RHSMConf.RHSM.ReportPackageProfile = true
+	uploaded, err = rhsmClient.UploadPackageProfile(nil)
+	if err != nil {
+		t.Fatalf("UploadPackageProfile() returned error: %s", err)
+	}
+	if !uploaded || handlerCounter != 1 {
+		t.Fatalf("package profile not uploaded, when there is no cache")
+	}
+	if len(uploadedProfiles) != 1 || uploadedProfiles[0].ContentType != "rpm" {
+		t.Fatalf("unexpected package profile uploaded: %v", uploadedProfiles)
+	}
+	if len(uploadedProfiles[0].Profile) != 2 || uploadedProfiles[0].Profile[0].Name != "bash" {
+		t.Errorf("packages in profile are not sorted: %v", uploadedProfiles[0].Profile)
+	}
+
+	// Nothing changed
+	uploaded, err = rhsmClient.UploadPackageProfile(nil)
+	if err != nil {
+		t.Fatalf("UploadPackageProfile() returned error: %s", err)
+	}
+	if uploaded || handlerCounter != 1 {
+		t.Fatalf("package profile uploaded, when profile was not changed")
+	}
+
+	// New package installed
+	provider.packages = append(provider.packages,
+		Package{Name: "zsh", Version: "5.9", Release: "15.el10", Arch: "x86_64", Vendor: "Red Hat, Inc."})
+	uploaded, err = rhsmClient.UploadPackageProfile(nil)
+	if err != nil {
+		t.Fatalf("UploadPackageProfile() returned error: %s", err)
+	}
+	if !uploaded || handlerCounter != 2 {
+		t.Fatalf("package profile not uploaded, when profile was changed")
+	}
+	if len(uploadedProfiles[0].Profile) != 3 {
+		t.Errorf("unexpected number of packages in profile: %d", len(uploadedProfiles[0].Profile))
+	}
+
+	// Uploading on transaction is disabled
+	provider.packages = provider.packages[:1]
+	rhsmClient.RHSMConf.RHSM.PackageProfileOnTrans = false
+	uploaded, err = rhsmClient.UploadPackageProfileOnTransaction(nil)
+	if err != nil {
+		t.Fatalf("UploadPackageProfileOnTransaction() returned error: %s", err)
+	}
+	if uploaded || handlerCounter != 2 {
+		t.Fatalf("package profile uploaded on transaction, when it is disabled")
+	}
+}
+
+// TestUploadPackageProfileProviderError test that error of package
+// provider is returned and nothing is uploaded
+func TestUploadPackageProfileProviderError(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			t.Fatalf("unexpected REST API call: %s %s", req.Method, req.URL.String())
+		}))
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, true, true, true, false, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	rhsmClient.RHSMConf.RHSM.ReportPackageProfile = true
+	rhsmClient.SetPackageProvider(&fakePackageProvider{err: fmt.Errorf("rpm database is locked")})
+
+	uploaded, err := rhsmClient.UploadPackageProfile(nil)
+	if err == nil {
+		t.Fatalf("UploadPackageProfile() should return error, when provider fails")
+	}
+	if uploaded {
+		t.Errorf("UploadPackageProfile() should not report uploaded profile")
+	}
+}
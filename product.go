@@ -9,8 +9,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
 const RedhatOidNamespace = "1.3.6.1.4.1.2312.9"
@@ -31,6 +32,8 @@ type InstalledProduct struct {
 	brandType    string
 	brandName    string
 	filePath     string
+	notBefore    time.Time
+	notAfter     time.Time
 }
 
 // DirectoryDefaultProductCertificate is directory containing default
@@ -142,6 +145,8 @@ func parseProductCertificateContent(productCertFilePath *string, productCertCont
 					}
 				}
 				installedProduct.filePath = *productCertFilePath
+				installedProduct.notBefore = certificate.NotBefore
+				installedProduct.notAfter = certificate.NotAfter
 
 				return &installedProduct, nil
 			}
@@ -165,7 +170,7 @@ func readProductCertificate(productCertFilePath *string) (*InstalledProduct, err
 }
 
 // readProductCertificatesInDir tries to read all product certificates from given directory
-func readProductCertificatesInDir(productCertDirPath string) ([]InstalledProduct, error) {
+func readProductCertificatesInDir(logger *zerolog.Logger, productCertDirPath string) ([]InstalledProduct, error) {
 	var productCerts []InstalledProduct
 
 	productCertsFilePaths, err := os.ReadDir(productCertDirPath)
@@ -177,7 +182,7 @@ func readProductCertificatesInDir(productCertDirPath string) ([]InstalledProduct
 		filePath := filepath.Join(productCertDirPath, file.Name())
 		productCert, err := readProductCertificate(&filePath)
 		if err != nil {
-			log.Warn().Msgf("skipping product certificate: %s: %v", filePath, err)
+			logger.Warn().Msgf("skipping product certificate: %s: %v", filePath, err)
 			continue
 		}
 		productCerts = append(productCerts, *productCert)
@@ -185,30 +190,157 @@ func readProductCertificatesInDir(productCertDirPath string) ([]InstalledProduct
 	return productCerts, nil
 }
 
-// readAllProductCertificates tries to read all product certificates in given directory
+// readAllProductCertificates tries to read all product certificates in given directory.
+// When the product certificate with the same product ID is installed in both directories,
+// then the certificate from /etc/pki/product takes precedence
 func (rhsmClient *RHSMClient) readAllProductCertificates() ([]InstalledProduct, error) {
 	var productCerts []InstalledProduct
 	var err1, err2 error
 
 	// Try to read installed product certificates
 	productCertDirPath := rhsmClient.RHSMConf.RHSM.ProductCertDir
-	productCerts, err1 = readProductCertificatesInDir(productCertDirPath)
+	productCerts, err1 = readProductCertificatesInDir(rhsmClient.getLogger(), productCertDirPath)
 	if err1 != nil {
-		log.Warn().Msgf("%s", err1)
+		rhsmClient.getLogger().Warn().Msgf("%s", err1)
 	}
 
 	// Try to read preinstalled (default) product certificates
 	defaultProductCertDirPath := rhsmClient.RHSMConf.RHSM.DefaultProductCertDir
-	defaultProductCerts, err2 := readProductCertificatesInDir(defaultProductCertDirPath)
+	defaultProductCerts, err2 := readProductCertificatesInDir(rhsmClient.getLogger(), defaultProductCertDirPath)
 	if err2 != nil {
-		log.Warn().Msgf("%s", err2)
+		rhsmClient.getLogger().Warn().Msgf("%s", err2)
 	}
 
 	if err1 != nil && err2 != nil {
 		return productCerts, fmt.Errorf("unable to read any product cert: %s, %s", err1, err2)
 	}
 
-	productCerts = append(productCerts, defaultProductCerts...)
+	productIds := make(map[string]bool)
+	for _, productCert := range productCerts {
+		productIds[productCert.Id] = true
+	}
+	for _, defaultProductCert := range defaultProductCerts {
+		if productIds[defaultProductCert.Id] {
+			rhsmClient.getLogger().Debug().Msgf("skipping default product certificate: %s, product %s already installed",
+				defaultProductCert.filePath, defaultProductCert.Id)
+			continue
+		}
+		productIds[defaultProductCert.Id] = true
+		productCerts = append(productCerts, defaultProductCert)
+	}
 
 	return productCerts, nil
 }
+
+// ProductCertificateSource is the type of directory, where product certificate is installed
+type ProductCertificateSource string
+
+const (
+	// ProductCertificateSourceProduct means that product certificate is installed
+	// in /etc/pki/product (typically by DNF plugin)
+	ProductCertificateSourceProduct ProductCertificateSource = "product"
+	// ProductCertificateSourceDefault means that product certificate is pre-installed
+	// in /etc/pki/product-default
+	ProductCertificateSourceDefault ProductCertificateSource = "product-default"
+)
+
+// InstalledProductInfo is structure with all information about one installed product
+type InstalledProductInfo struct {
+	Id           string   `json:"productId"`
+	Name         string   `json:"productName"`
+	Version      string   `json:"version"`
+	Architecture string   `json:"arch"`
+	ProvidedTags []string `json:"providedTags"`
+	BrandType    string   `json:"brandType,omitempty"`
+	BrandName    string   `json:"brandName,omitempty"`
+	// FilePath is the file path of product certificate
+	FilePath string `json:"filePath"`
+	// Source is the directory, where product certificate is installed
+	Source ProductCertificateSource `json:"source"`
+	// NotBefore and NotAfter is the validity of product certificate
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	// ContentAvailable is true, when some installed entitlement certificate
+	// provides content for this product
+	ContentAvailable bool `json:"contentAvailable"`
+	// EntitlementSerials is the list of serial numbers of entitlement certificates
+	// providing content for this product
+	EntitlementSerials []int64 `json:"entitlementSerials"`
+}
+
+// isContentProvidedForProduct returns true, when given engineering product from entitlement
+// certificate provides content for installed product. It is the case, when the ID of
+// engineering product is the same or when the engineering product contains content
+// requiring some tag provided by installed product (typically SCA certificate)
+func isContentProvidedForProduct(logger *zerolog.Logger, engineeringProduct *EngineeringProduct, installedProduct *InstalledProduct) bool {
+	if engineeringProduct.Id == installedProduct.Id {
+		return true
+	}
+	if len(installedProduct.providedTags) == 0 {
+		return false
+	}
+	for _, content := range engineeringProduct.Content {
+		if len(content.RequiredTags) > 0 &&
+			isAnyRequiredTagProvided(logger, content.RequiredTags, installedProduct.providedTags) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListInstalledProducts tries to list all installed products from directories
+// /etc/pki/product and /etc/pki/product-default. Products installed in both
+// directories are listed only once. Every product is cross-referenced with installed
+// entitlement certificates to find out, whether content is available for the product.
+func (rhsmClient *RHSMClient) ListInstalledProducts() ([]InstalledProductInfo, error) {
+	installedProducts, err := rhsmClient.readAllProductCertificates()
+	if err != nil {
+		return nil, err
+	}
+
+	entCerts, err := rhsmClient.ListEntitlementCertificates()
+	if err != nil {
+		rhsmClient.getLogger().Debug().Msgf("unable to list entitlement certificates: %s", err)
+	}
+
+	defaultProductCertDirPath := filepath.Clean(rhsmClient.RHSMConf.RHSM.DefaultProductCertDir)
+
+	productInfos := make([]InstalledProductInfo, 0, len(installedProducts))
+	for i := range installedProducts {
+		installedProduct := &installedProducts[i]
+		productInfo := InstalledProductInfo{
+			Id:                 installedProduct.Id,
+			Name:               installedProduct.Name,
+			Version:            installedProduct.Version,
+			Architecture:       installedProduct.Architecture,
+			ProvidedTags:       installedProduct.providedTags,
+			BrandType:          installedProduct.brandType,
+			BrandName:          installedProduct.brandName,
+			FilePath:           installedProduct.filePath,
+			Source:             ProductCertificateSourceProduct,
+			NotBefore:          installedProduct.notBefore,
+			NotAfter:           installedProduct.notAfter,
+			EntitlementSerials: []int64{},
+		}
+		if productInfo.ProvidedTags == nil {
+			productInfo.ProvidedTags = []string{}
+		}
+		if filepath.Dir(installedProduct.filePath) == defaultProductCertDirPath {
+			productInfo.Source = ProductCertificateSourceDefault
+		}
+
+		for _, entCert := range entCerts {
+			for j := range entCert.Products {
+				if isContentProvidedForProduct(rhsmClient.getLogger(), &entCert.Products[j], installedProduct) {
+					productInfo.EntitlementSerials = append(productInfo.EntitlementSerials, entCert.Serial)
+					break
+				}
+			}
+		}
+		productInfo.ContentAvailable = len(productInfo.EntitlementSerials) > 0
+
+		productInfos = append(productInfos, productInfo)
+	}
+
+	return productInfos, nil
+}
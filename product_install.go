@@ -0,0 +1,271 @@
+package rhsm2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// updateInstalledProductsData is structure representing JSON data used for updating
+// installed products on the candlepin server
+type updateInstalledProductsData struct {
+	InstalledProducts []InstalledProduct `json:"installedProducts"`
+}
+
+// compareProductVersions compares two versions of products (e.g. "9.4" and "10.0").
+// It returns negative number, when version1 is lower than version2, zero, when
+// versions are equal and positive number, when version1 is greater than version2.
+// Missing parts are considered as zero. Parts of versions, which are not numbers,
+// are compared as strings
+func compareProductVersions(version1 string, version2 string) int {
+	parts1 := strings.Split(version1, ".")
+	parts2 := strings.Split(version2, ".")
+	for i := 0; i < len(parts1) || i < len(parts2); i++ {
+		part1, part2 := "0", "0"
+		if i < len(parts1) {
+			part1 = parts1[i]
+		}
+		if i < len(parts2) {
+			part2 = parts2[i]
+		}
+		number1, err1 := strconv.Atoi(part1)
+		number2, err2 := strconv.Atoi(part2)
+		if err1 == nil && err2 == nil {
+			if number1 != number2 {
+				return number1 - number2
+			}
+			continue
+		}
+		if result := strings.Compare(part1, part2); result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+// parseProductCertificatePEM tries to parse product certificate in PEM format. The
+// certificate has to contain Red Hat extensions with product ID and product name
+func parseProductCertificatePEM(productCertPEM []byte) (*InstalledProduct, error) {
+	description := "product certificate"
+	installedProduct, err := parseProductCertificateContent(&description, &productCertPEM)
+	if err != nil {
+		return nil, err
+	}
+	if installedProduct.Id == "" {
+		return nil, fmt.Errorf("product certificate does not contain Red Hat extensions with product ID")
+	}
+	if _, err := strconv.ParseUint(installedProduct.Id, 10, 64); err != nil {
+		return nil, fmt.Errorf("product certificate contains invalid product ID: %s", installedProduct.Id)
+	}
+	if installedProduct.Name == "" {
+		return nil, fmt.Errorf("product certificate: %s does not contain product name", installedProduct.Id)
+	}
+	return installedProduct, nil
+}
+
+// InstallProductCertificate tries to install given product certificate (in PEM format)
+// to the directory with product certificates (typically /etc/pki/product). The
+// certificate is not installed, when the same or newer version of the product is
+// already installed. When the system is registered, then the list of installed
+// products is updated on the candlepin server.
+func (rhsmClient *RHSMClient) InstallProductCertificate(
+	productCertPEM []byte,
+	metadata *RequestMetadata,
+) (*InstalledProduct, error) {
+	return rhsmClient.InstallProductCertificateWithContext(context.Background(), productCertPEM, metadata)
+}
+
+// InstallProductCertificateWithContext is like InstallProductCertificate, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) InstallProductCertificateWithContext(
+	ctx context.Context,
+	productCertPEM []byte,
+	metadata *RequestMetadata,
+) (*InstalledProduct, error) {
+	newProduct, err := parseProductCertificatePEM(productCertPEM)
+	if err != nil {
+		return nil, fmt.Errorf("unable to install product certificate: %s", err)
+	}
+
+	productCertDirPath := rhsmClient.RHSMConf.RHSM.ProductCertDir
+	productCertFilePath := filepath.Join(productCertDirPath, newProduct.Id+".pem")
+
+	var staleProductCertFilePaths []string
+	installedProducts := rhsmClient.getInstalledProducts()
+	for _, installedProduct := range installedProducts {
+		if installedProduct.Id != newProduct.Id {
+			continue
+		}
+		result := compareProductVersions(newProduct.Version, installedProduct.Version)
+		if result == 0 {
+			return nil, fmt.Errorf("unable to install product certificate: product %s version %s is already installed: %s",
+				newProduct.Id, installedProduct.Version, installedProduct.filePath)
+		}
+		if result < 0 {
+			return nil, fmt.Errorf("unable to install product certificate: downgrade of product %s from version %s to %s is not allowed",
+				newProduct.Id, installedProduct.Version, newProduct.Version)
+		}
+		// Older version of product certificate installed in product directory with other
+		// file name has to be removed, because it would be listed together with the new one
+		if filepath.Dir(installedProduct.filePath) == filepath.Clean(productCertDirPath) &&
+			installedProduct.filePath != productCertFilePath {
+			staleProductCertFilePaths = append(staleProductCertFilePaths, installedProduct.filePath)
+		}
+	}
+
+	err = os.MkdirAll(productCertDirPath, rhsmClient.getFilePermissions().Dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create directory %s: %s", productCertDirPath, err)
+	}
+	err = writeFileAtomically(rhsmClient.getLogger(), productCertFilePath, productCertPEM, rhsmClient.getFilePermissions().Cert)
+	if err != nil {
+		return nil, fmt.Errorf("unable to install product certificate: %s", err)
+	}
+
+	// Older product certificates are removed, when the new one is installed. Thus,
+	// the product is not uninstalled, when it is not possible to write new certificate
+	for _, staleProductCertFilePath := range staleProductCertFilePaths {
+		err = os.Remove(staleProductCertFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to remove older product certificate: %s: %s",
+				staleProductCertFilePath, err)
+		}
+	}
+	newProduct.filePath = productCertFilePath
+
+	rhsmClient.getLogger().Info().Msgf("product certificate %s (%s %s) installed to %s",
+		newProduct.Id, newProduct.Name, newProduct.Version, productCertFilePath)
+
+	rhsmClient.updateInstalledProducts(ctx, metadata)
+
+	return newProduct, nil
+}
+
+// RemoveProductCertificate tries to remove product certificate with given product ID
+// from the directory with product certificates (typically /etc/pki/product). Default
+// product certificates installed in /etc/pki/product-default are never removed. The
+// product ID is also removed from the database mapping product IDs to repositories. When
+// the system is registered, then the list of installed products is updated on
+// the candlepin server.
+func (rhsmClient *RHSMClient) RemoveProductCertificate(productId string, metadata *RequestMetadata) error {
+	return rhsmClient.RemoveProductCertificateWithContext(context.Background(), productId, metadata)
+}
+
+// RemoveProductCertificateWithContext is like RemoveProductCertificate, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) RemoveProductCertificateWithContext(
+	ctx context.Context,
+	productId string,
+	metadata *RequestMetadata,
+) error {
+	removed, err := rhsmClient.removeProductCertificate(productId)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return fmt.Errorf("unable to remove product certificate: product %s is not installed in %s",
+			productId, rhsmClient.RHSMConf.RHSM.ProductCertDir)
+	}
+
+	// Product certificate is already removed. Thus, the error is only logged
+	err = rhsmClient.removeProductIdFromDB(productId)
+	if err != nil {
+		rhsmClient.getLogger().Warn().Msgf("%s", err)
+	}
+
+	rhsmClient.updateInstalledProducts(ctx, metadata)
+
+	return nil
+}
+
+// removeProductCertificate tries to remove all product certificates with given product ID
+// from the directory with product certificates. It returns true, when some product
+// certificate was removed. Installed products are not updated on the server.
+func (rhsmClient *RHSMClient) removeProductCertificate(productId string) (bool, error) {
+	productCertDirPath := rhsmClient.RHSMConf.RHSM.ProductCertDir
+	installedProducts, err := readProductCertificatesInDir(rhsmClient.getLogger(), productCertDirPath)
+	if err != nil {
+		return false, fmt.Errorf("unable to remove product certificate: %s", err)
+	}
+
+	removed := false
+	for _, installedProduct := range installedProducts {
+		if installedProduct.Id != productId {
+			continue
+		}
+		err = os.Remove(installedProduct.filePath)
+		if err != nil {
+			return removed, fmt.Errorf("unable to remove product certificate: %s: %s", installedProduct.filePath, err)
+		}
+		rhsmClient.getLogger().Info().Msgf("product certificate %s removed: %s",
+			productId, installedProduct.filePath)
+		removed = true
+	}
+
+	return removed, nil
+}
+
+// updateInstalledProducts tries to send the list of installed products to the candlepin
+// server. When the system is not registered, then nothing is sent. Errors are only logged,
+// because the product certificates are already installed or removed.
+func (rhsmClient *RHSMClient) updateInstalledProducts(ctx context.Context, metadata *RequestMetadata) {
+	consumerUuid, err := rhsmClient.GetConsumerUUID()
+	if err != nil {
+		rhsmClient.getLogger().Debug().Msgf("system is not registered, skipping update of installed products: %s", err)
+		return
+	}
+
+	installedProducts := rhsmClient.getInstalledProducts()
+	if installedProducts == nil {
+		installedProducts = []InstalledProduct{}
+	}
+	body, err := json.Marshal(updateInstalledProductsData{InstalledProducts: installedProducts})
+	if err != nil {
+		rhsmClient.getLogger().Warn().Msgf("unable to update installed products: %s", err)
+		return
+	}
+
+	var headers = make(map[string]string)
+	headers["Content-type"] = "application/json"
+
+	metadata = sanitizeMetadata(metadata)
+
+	connection, err := rhsmClient.getCertAuthConnection()
+	if err != nil {
+		rhsmClient.getLogger().Warn().Msgf("unable to get consumer cert auth connection: %v", err)
+		return
+	}
+	res, err := connection.request(
+		ctx,
+		rhsmClient.UserAgent,
+		http.MethodPut,
+		"consumers/"+*consumerUuid,
+		"",
+		"",
+		&headers,
+		&body,
+		metadata,
+	)
+	if err != nil {
+		rhsmClient.getLogger().Warn().Msgf("unable to update installed products: %s", err)
+		return
+	}
+
+	defer func() {
+		// We can ignore error returning, by Close(), because we only
+		// read content of body
+		_ = res.Body.Close()
+	}()
+
+	if res.StatusCode != 200 && res.StatusCode != 204 {
+		rhsmClient.getLogger().Warn().Msgf("unable to update installed products: %s", newCandlepinError(res))
+		return
+	}
+
+	rhsmClient.getLogger().Info().Msg("installed products updated")
+}
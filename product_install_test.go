@@ -0,0 +1,250 @@
+package rhsm2
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// createTestingProductCert tries to create product certificate in PEM format with
+// Red Hat extensions containing given product ID, name and version
+func createTestingProductCert(t *testing.T, productId string, name string, version string) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err)
+	}
+
+	var extensions []pkix.Extension
+	for extensionId, value := range map[int]string{1: name, 2: version, 3: "x86_64", 4: "rhel-11,rhel-11-x86_64"} {
+		if value == "" {
+			continue
+		}
+		extensionValue, err := asn1.MarshalWithParams(value, "utf8")
+		if err != nil {
+			t.Fatalf("unable to marshal extension value: %s", err)
+		}
+		productNumber, _ := new(big.Int).SetString(productId, 10)
+		extensions = append(extensions, pkix.Extension{
+			Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 2312, 9, 1, int(productNumber.Int64()), extensionId},
+			Value: extensionValue,
+		})
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: "Red Hat Product ID [" + productId + "]"},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: extensions,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unable to create product certificate: %s", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+}
+
+// TestCompareProductVersions tests comparing of product versions
+func TestCompareProductVersions(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		version1 string
+		version2 string
+		expected int
+	}{
+		{version1: "10.0", version2: "10.0", expected: 0},
+		{version1: "10.0", version2: "9.4", expected: 1},
+		{version1: "9.4", version2: "9.10", expected: -1},
+		{version1: "9", version2: "9.0", expected: 0},
+		{version1: "9.4", version2: "9", expected: 1},
+		{version1: "9.4 Beta", version2: "9.4", expected: 1},
+	}
+	for _, tt := range tests {
+		result := compareProductVersions(tt.version1, tt.version2)
+		if (result > 0) != (tt.expected > 0) || (result < 0) != (tt.expected < 0) {
+			t.Errorf("compareProductVersions(%s, %s) = %d, expected: %d",
+				tt.version1, tt.version2, result, tt.expected)
+		}
+	}
+}
+
+// TestInstallProductCertificate tests installing of product certificates including
+// refusing of duplicates and downgrades
+func TestInstallProductCertificate(t *testing.T) {
+	t.Parallel()
+	testingFiles, err := setupTestingFileSystem(t.TempDir(), false, false, false, true, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	// Red Hat Enterprise Linux 10.0 is installed in product-default directory
+	_, err = rhsmClient.InstallProductCertificate(createTestingProductCert(t, "479", "RHEL", "10.0"), nil)
+	if err == nil {
+		t.Errorf("duplicate of product certificate installed")
+	}
+	_, err = rhsmClient.InstallProductCertificate(createTestingProductCert(t, "479", "RHEL", "9.4"), nil)
+	if err == nil {
+		t.Errorf("downgrade of product certificate installed")
+	}
+	_, err = rhsmClient.InstallProductCertificate([]byte("not a certificate"), nil)
+	if err == nil {
+		t.Errorf("invalid product certificate installed")
+	}
+	_, err = rhsmClient.InstallProductCertificate(createTestingProductCert(t, "123", "", "1.0"), nil)
+	if err == nil {
+		t.Errorf("product certificate without product name installed")
+	}
+
+	// Upgrade of default product certificate
+	installedProduct, err := rhsmClient.InstallProductCertificate(
+		createTestingProductCert(t, "479", "RHEL", "10.1"), nil)
+	if err != nil {
+		t.Fatalf("unable to install product certificate: %s", err)
+	}
+	expectedFilePath := filepath.Join(testingFiles.ProductDirPath, "479.pem")
+	if installedProduct.filePath != expectedFilePath {
+		t.Errorf("expected file path: %s, got: %s", expectedFilePath, installedProduct.filePath)
+	}
+
+	// Upgrade of the product certificate installed in product directory. The file
+	// of older product certificate with other file name is removed
+	productFilePath := filepath.Join(testingFiles.ProductDirPath, "900.pem")
+	err = os.Rename(productFilePath, filepath.Join(testingFiles.ProductDirPath, "old.pem"))
+	if err != nil {
+		t.Fatalf("unable to rename product certificate: %s", err)
+	}
+
+	// Older product certificate is kept, when it is not possible to write the new one
+	err = os.MkdirAll(filepath.Join(productFilePath, "dir"), 0755)
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err)
+	}
+	_, err = rhsmClient.InstallProductCertificate(createTestingProductCert(t, "900", "Product", "2.0"), nil)
+	if err == nil {
+		t.Fatalf("product certificate installed, when it is not possible to write it")
+	}
+	if _, err := os.Stat(filepath.Join(testingFiles.ProductDirPath, "old.pem")); err != nil {
+		t.Errorf("older product certificate removed, when new one was not installed: %s", err)
+	}
+	err = os.RemoveAll(productFilePath)
+	if err != nil {
+		t.Fatalf("unable to remove directory: %s", err)
+	}
+
+	_, err = rhsmClient.InstallProductCertificate(createTestingProductCert(t, "900", "Product", "2.0"), nil)
+	if err != nil {
+		t.Fatalf("unable to install product certificate: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(testingFiles.ProductDirPath, "old.pem")); !os.IsNotExist(err) {
+		t.Errorf("older product certificate was not removed")
+	}
+	if _, err := os.Stat(productFilePath); err != nil {
+		t.Errorf("product certificate was not installed: %s", err)
+	}
+
+	products, err := rhsmClient.ListInstalledProducts()
+	if err != nil {
+		t.Fatalf("unable to list installed products: %s", err)
+	}
+	versions := make(map[string]string)
+	for _, product := range products {
+		versions[product.Id] = product.Version
+	}
+	if len(products) != 2 || versions["479"] != "10.1" || versions["900"] != "2.0" {
+		t.Errorf("unexpected installed products: %v", versions)
+	}
+}
+
+// TestRemoveProductCertificate tests removing of product certificates and updating
+// of installed products on the server
+func TestRemoveProductCertificate(t *testing.T) {
+	t.Parallel()
+	expectedConsumerUUID := "5e9745d5-624d-4af1-916e-2c17df4eb4e8"
+	handlerCounter := 0
+	var updateData updateInstalledProductsData
+
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			expectedURL := "/consumers/" + expectedConsumerUUID
+			if req.Method != http.MethodPut || req.URL.String() != expectedURL {
+				t.Fatalf("unexpected REST API call: %s %s", req.Method, req.URL.String())
+			}
+			handlerCounter += 1
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatalf("unable to read body of request: %s", err)
+			}
+			err = json.Unmarshal(body, &updateData)
+			if err != nil {
+				t.Fatalf("unable to unmarshal body of request: %s", err)
+			}
+			rw.WriteHeader(204)
+		}))
+	defer server.Close()
+
+	testingFiles, err := setupTestingFileSystem(t.TempDir(), true, true, false, true, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	// Default product certificate cannot be removed
+	err = rhsmClient.RemoveProductCertificate("479", nil)
+	if err == nil {
+		t.Errorf("default product certificate removed")
+	}
+	err = rhsmClient.RemoveProductCertificate("123", nil)
+	if err == nil {
+		t.Errorf("no error returned for not installed product")
+	}
+	if handlerCounter != 0 {
+		t.Fatalf("installed products updated, when no product certificate was removed")
+	}
+
+	err = rhsmClient.writeProductIdDB(map[string][]string{"900": {"repo-900"}, "69": {"repo-69"}})
+	if err != nil {
+		t.Fatalf("unable to write product ID database: %s", err)
+	}
+
+	err = rhsmClient.RemoveProductCertificate("900", nil)
+	if err != nil {
+		t.Fatalf("unable to remove product certificate: %s", err)
+	}
+	productIdDB, err := rhsmClient.readProductIdDB()
+	if err != nil {
+		t.Fatalf("unable to read product ID database: %s", err)
+	}
+	expectedDB := map[string][]string{"69": {"repo-69"}}
+	if !reflect.DeepEqual(productIdDB, expectedDB) {
+		t.Errorf("expected product ID database: %v, got: %v", expectedDB, productIdDB)
+	}
+	if _, err := os.Stat(filepath.Join(testingFiles.ProductDirPath, "900.pem")); !os.IsNotExist(err) {
+		t.Errorf("product certificate was not removed")
+	}
+	if handlerCounter != 1 {
+		t.Fatalf("installed products were not updated on the server")
+	}
+	if len(updateData.InstalledProducts) != 1 || updateData.InstalledProducts[0].Id != "479" {
+		t.Errorf("unexpected installed products sent to the server: %v", updateData.InstalledProducts)
+	}
+}
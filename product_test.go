@@ -3,6 +3,9 @@ package rhsm2
 import (
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 )
 
@@ -119,3 +122,103 @@ func TestReadNoProductCertificates(t *testing.T) {
 			rhsmClient.RHSMConf.RHSM.ProductCertDir, rhsmClient.RHSMConf.RHSM.DefaultProductCertDir)
 	}
 }
+
+// TestListInstalledProducts tests listing of installed products, deduplication of
+// products installed in both directories and cross-referencing with entitlement certificates
+func TestListInstalledProducts(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		duplicateProduct bool
+		installEntCert   bool
+		expectedSource   ProductCertificateSource
+	}{
+		{
+			name:           "default product with content",
+			installEntCert: true,
+			expectedSource: ProductCertificateSourceDefault,
+		},
+		{
+			name:             "product installed in both directories",
+			duplicateProduct: true,
+			installEntCert:   true,
+			expectedSource:   ProductCertificateSourceProduct,
+		},
+		{
+			name:           "no entitlement certificate",
+			expectedSource: ProductCertificateSourceDefault,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			testingFiles, err := setupTestingFileSystem(
+				t.TempDir(), false, true, tt.installEntCert, true, true)
+			if err != nil {
+				t.Fatalf("unable to setup testing environment: %s", err)
+			}
+			rhsmClient, err := setupTestingRHSMClient(testingFiles, nil, nil)
+			if err != nil {
+				t.Fatalf("unable to setup testing rhsm client: %s", err)
+			}
+
+			if tt.duplicateProduct {
+				srcFilePath := filepath.Join(testingFiles.ProductDefaultDirPath, "479.pem")
+				dstFilePath := filepath.Join(testingFiles.ProductDirPath, "479.pem")
+				perm := os.FileMode(0644)
+				err = copyFile(&srcFilePath, &dstFilePath, &perm)
+				if err != nil {
+					t.Fatalf("unable to copy product certificate: %s", err)
+				}
+			}
+
+			installedProducts, err := rhsmClient.ListInstalledProducts()
+			if err != nil {
+				t.Fatalf("unable to list installed products: %s", err)
+			}
+			if len(installedProducts) != 2 {
+				t.Fatalf("expected 2 installed products, got: %d", len(installedProducts))
+			}
+
+			products := make(map[string]InstalledProductInfo)
+			for _, product := range installedProducts {
+				products[product.Id] = product
+			}
+
+			rhelProduct, exists := products["479"]
+			if !exists {
+				t.Fatalf("product 479 not listed: %v", installedProducts)
+			}
+			if rhelProduct.Source != tt.expectedSource {
+				t.Errorf("expected source: %s, got: %s", tt.expectedSource, rhelProduct.Source)
+			}
+			if len(rhelProduct.ProvidedTags) != 2 {
+				t.Errorf("unexpected provided tags: %v", rhelProduct.ProvidedTags)
+			}
+			if rhelProduct.NotAfter.IsZero() {
+				t.Errorf("validity of product certificate was not read")
+			}
+			if rhelProduct.ContentAvailable != tt.installEntCert {
+				t.Errorf("content available: %v, expected: %v", rhelProduct.ContentAvailable, tt.installEntCert)
+			}
+			if tt.installEntCert && (len(rhelProduct.EntitlementSerials) != 1 ||
+				strconv.FormatInt(rhelProduct.EntitlementSerials[0], 10) != testEntCertSerialNumber) {
+				t.Errorf("unexpected entitlement serials: %v", rhelProduct.EntitlementSerials)
+			}
+
+			// Product without tags is not provided by SCA entitlement certificate
+			otherProduct, exists := products["900"]
+			if !exists {
+				t.Fatalf("product 900 not listed: %v", installedProducts)
+			}
+			if otherProduct.Source != ProductCertificateSourceProduct {
+				t.Errorf("expected source: %s, got: %s", ProductCertificateSourceProduct, otherProduct.Source)
+			}
+			if otherProduct.ContentAvailable {
+				t.Errorf("content should not be available for product without tags")
+			}
+		})
+	}
+}
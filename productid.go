@@ -0,0 +1,296 @@
+package rhsm2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// DefaultProductIdDBFilePath is the file path of the database mapping product IDs
+// to repositories, which provided product certificates
+const DefaultProductIdDBFilePath = DefaultCacheDirPath + "/productid.js"
+
+// productIdMetadataType is the type of repository metadata containing product certificate
+const productIdMetadataType = "productid"
+
+// repoMD is structure used for parsing repodata/repomd.xml
+type repoMD struct {
+	Data []struct {
+		Type     string `xml:"type,attr"`
+		Location struct {
+			Href string `xml:"href,attr"`
+		} `xml:"location"`
+	} `xml:"data"`
+}
+
+// readProductIdDB tries to read the database mapping product IDs to labels of repositories.
+// When the database does not exist, then empty database is returned.
+func (rhsmClient *RHSMClient) readProductIdDB() (map[string][]string, error) {
+	productIdDB := make(map[string][]string)
+	err := readJSONCache(rhsmClient.RHSMConf.productIdDBFilePath, &productIdDB)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[string][]string), nil
+		}
+		return nil, err
+	}
+	return productIdDB, nil
+}
+
+// writeProductIdDB tries to write the database mapping product IDs to labels of repositories
+func (rhsmClient *RHSMClient) writeProductIdDB(productIdDB map[string][]string) error {
+	return writeJSONCache(rhsmClient.getLogger(), rhsmClient.RHSMConf.productIdDBFilePath, productIdDB, rhsmClient.getFilePermissions())
+}
+
+// removeProductIdFromDB tries to remove given product ID from the database mapping
+// product IDs to labels of repositories. When the product ID is not in the database,
+// then the database is not changed.
+func (rhsmClient *RHSMClient) removeProductIdFromDB(productId string) error {
+	productIdDB, err := rhsmClient.readProductIdDB()
+	if err != nil {
+		return fmt.Errorf("unable to read product ID database: %s", err)
+	}
+	if _, exists := productIdDB[productId]; !exists {
+		return nil
+	}
+	delete(productIdDB, productId)
+	err = rhsmClient.writeProductIdDB(productIdDB)
+	if err != nil {
+		return fmt.Errorf("unable to write product ID database: %s", err)
+	}
+	return nil
+}
+
+// readProductIdFromRepoMetadata tries to read product certificate from local copy of
+// repository metadata. The metadataDirPath could be the directory of repository
+// containing directory "repodata" or it could be the directory "repodata" itself.
+// The product certificate could be compressed using gzip.
+func readProductIdFromRepoMetadata(metadataDirPath string) ([]byte, error) {
+	repoDirPath := metadataDirPath
+	repoMDFilePath := filepath.Join(metadataDirPath, "repodata", "repomd.xml")
+	if _, err := os.Stat(repoMDFilePath); err != nil {
+		repoDirPath = filepath.Dir(filepath.Clean(metadataDirPath))
+		repoMDFilePath = filepath.Join(metadataDirPath, "repomd.xml")
+	}
+
+	repoMDContent, err := os.ReadFile(repoMDFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read repository metadata: %s", err)
+	}
+	var repoMetadata repoMD
+	err = xml.Unmarshal(repoMDContent, &repoMetadata)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse repository metadata: %s: %s", repoMDFilePath, err)
+	}
+
+	var productIdHref string
+	for _, data := range repoMetadata.Data {
+		if data.Type == productIdMetadataType {
+			productIdHref = data.Location.Href
+			break
+		}
+	}
+	if productIdHref == "" {
+		return nil, fmt.Errorf("repository metadata: %s does not contain %s", repoMDFilePath, productIdMetadataType)
+	}
+
+	productIdFilePath := filepath.Join(repoDirPath, filepath.FromSlash(productIdHref))
+	productIdContent, err := os.ReadFile(productIdFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read product certificate from repository metadata: %s", err)
+	}
+
+	// Check gzip magic number
+	if bytes.HasPrefix(productIdContent, []byte{0x1f, 0x8b}) {
+		gzipReader, err := gzip.NewReader(bytes.NewReader(productIdContent))
+		if err != nil {
+			return nil, fmt.Errorf("unable to uncompress product certificate: %s: %s", productIdFilePath, err)
+		}
+		defer func() {
+			_ = gzipReader.Close()
+		}()
+		productIdContent, err = io.ReadAll(gzipReader)
+		if err != nil {
+			return nil, fmt.Errorf("unable to uncompress product certificate: %s: %s", productIdFilePath, err)
+		}
+	}
+
+	return productIdContent, nil
+}
+
+// UpdateProductIdFromRepo tries to install product certificate provided by repository
+// with given label. The repository has to be in redhat.repo. The product certificate is
+// read from local copy of repository metadata (typically downloaded by dnf) in given
+// directory. The product certificate is installed, when the product is not installed
+// yet or when newer version of product is provided. The repository is recorded in
+// the database mapping product IDs to repositories (typically /var/lib/rhsm/productid.js).
+func (rhsmClient *RHSMClient) UpdateProductIdFromRepo(
+	repoLabel string,
+	metadataDirPath string,
+	metadata *RequestMetadata,
+) (*InstalledProduct, error) {
+	return rhsmClient.UpdateProductIdFromRepoWithContext(context.Background(), repoLabel, metadataDirPath, metadata)
+}
+
+// UpdateProductIdFromRepoWithContext is like UpdateProductIdFromRepo, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) UpdateProductIdFromRepoWithContext(
+	ctx context.Context,
+	repoLabel string,
+	metadataDirPath string,
+	metadata *RequestMetadata,
+) (*InstalledProduct, error) {
+	repos, err := rhsmClient.ListRepos(nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list repositories: %s", err)
+	}
+	if !slices.ContainsFunc(repos, func(repo Repo) bool { return repo.Label == repoLabel }) {
+		return nil, fmt.Errorf("repository %s is not provided by redhat.repo", repoLabel)
+	}
+
+	productCertPEM, err := readProductIdFromRepoMetadata(metadataDirPath)
+	if err != nil {
+		return nil, err
+	}
+	product, err := parseProductCertificatePEM(productCertPEM)
+	if err != nil {
+		return nil, fmt.Errorf("repository %s provides invalid product certificate: %s", repoLabel, err)
+	}
+
+	// Install product certificate only in the case, when it is not installed yet,
+	// or it is newer version of already installed product certificate
+	install := true
+	for _, installedProduct := range rhsmClient.getInstalledProducts() {
+		if installedProduct.Id == product.Id && compareProductVersions(product.Version, installedProduct.Version) <= 0 {
+			rhsmClient.getLogger().Debug().Msgf("product %s version %s is already installed: %s",
+				product.Id, installedProduct.Version, installedProduct.filePath)
+			product = &installedProduct
+			install = false
+			break
+		}
+	}
+	if install {
+		product, err = rhsmClient.InstallProductCertificateWithContext(ctx, productCertPEM, metadata)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	productIdDB, err := rhsmClient.readProductIdDB()
+	if err != nil {
+		return product, fmt.Errorf("unable to read product ID database: %s", err)
+	}
+	if !slices.Contains(productIdDB[product.Id], repoLabel) {
+		productIdDB[product.Id] = append(productIdDB[product.Id], repoLabel)
+		sort.Strings(productIdDB[product.Id])
+		err = rhsmClient.writeProductIdDB(productIdDB)
+		if err != nil {
+			return product, fmt.Errorf("unable to write product ID database: %s", err)
+		}
+	}
+
+	return product, nil
+}
+
+// RemoveProductIdsOfDisabledRepos tries to remove product certificates, which were installed
+// from repositories, which are not enabled anymore. Repositories, which are still provided
+// by installed entitlement certificates, but they are disabled, are removed from the database
+// mapping product IDs to repositories. Repositories, which are not provided by installed
+// entitlement certificates, are kept, because their state is not known. When no enabled
+// repository is recorded for the product, then the product certificate is removed from
+// the directory with product certificates. Default product certificates are never removed.
+// When the system is not registered or no entitlement certificate is installed, then
+// nothing is removed. When some product certificate is removed, then installed products
+// are updated on the candlepin server. IDs of removed products are returned.
+func (rhsmClient *RHSMClient) RemoveProductIdsOfDisabledRepos(metadata *RequestMetadata) ([]string, error) {
+	return rhsmClient.RemoveProductIdsOfDisabledReposWithContext(context.Background(), metadata)
+}
+
+// RemoveProductIdsOfDisabledReposWithContext is like RemoveProductIdsOfDisabledRepos, but it uses
+// the given context. HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) RemoveProductIdsOfDisabledReposWithContext(
+	ctx context.Context,
+	metadata *RequestMetadata,
+) ([]string, error) {
+	productIdDB, err := rhsmClient.readProductIdDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read product ID database: %s", err)
+	}
+	if len(productIdDB) == 0 {
+		return []string{}, nil
+	}
+
+	// State of repositories is not known, when the system is not registered
+	// or no entitlement certificate is installed (e.g. during refresh)
+	if _, err := rhsmClient.GetConsumerUUID(); err != nil {
+		rhsmClient.getLogger().Debug().Msgf("system is not registered, skipping removing of product IDs: %s", err)
+		return []string{}, nil
+	}
+	installedCertKeys, err := rhsmClient.getInstalledEntitlementCertificateKeys()
+	if err != nil || len(installedCertKeys) == 0 {
+		rhsmClient.getLogger().Debug().Msg("no entitlement certificate installed, skipping removing of product IDs")
+		return []string{}, nil
+	}
+
+	repos, err := rhsmClient.ListRepos(nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list repositories: %s", err)
+	}
+	repoEnabled := make(map[string]bool)
+	for _, repo := range repos {
+		repoEnabled[repo.Label] = repo.Enabled
+	}
+
+	removedProductIds := []string{}
+	dbChanged := false
+	for productId, repoLabels := range productIdDB {
+		var keptLabels []string
+		for _, repoLabel := range repoLabels {
+			// Repository, which is not listed, is kept
+			if enabled, listed := repoEnabled[repoLabel]; !listed || enabled {
+				keptLabels = append(keptLabels, repoLabel)
+			}
+		}
+		if len(keptLabels) == len(repoLabels) {
+			continue
+		}
+		dbChanged = true
+		if len(keptLabels) > 0 {
+			productIdDB[productId] = keptLabels
+			continue
+		}
+
+		removed, err := rhsmClient.removeProductCertificate(productId)
+		if err != nil {
+			rhsmClient.getLogger().Warn().Msgf("%s", err)
+			continue
+		}
+		delete(productIdDB, productId)
+		if removed {
+			removedProductIds = append(removedProductIds, productId)
+		}
+	}
+
+	if dbChanged {
+		err = rhsmClient.writeProductIdDB(productIdDB)
+		if err != nil {
+			return removedProductIds, fmt.Errorf("unable to write product ID database: %s", err)
+		}
+	}
+
+	if len(removedProductIds) > 0 {
+		sort.Strings(removedProductIds)
+		rhsmClient.getLogger().Info().Msgf("product certificates of disabled repositories removed: %v",
+			removedProductIds)
+		rhsmClient.updateInstalledProducts(ctx, metadata)
+	}
+
+	return removedProductIds, nil
+}
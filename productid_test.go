@@ -0,0 +1,252 @@
+package rhsm2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/ini.v1"
+)
+
+// createTestingRepoMetadata tries to create local copy of repository metadata
+// containing given product certificate
+func createTestingRepoMetadata(t *testing.T, productCertPEM []byte, compress bool) string {
+	repoDirPath := t.TempDir()
+	repoDataDirPath := filepath.Join(repoDirPath, "repodata")
+	err := os.MkdirAll(repoDataDirPath, 0755)
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err)
+	}
+
+	productIdFileName := "3b2b2e1a-productid"
+	productIdContent := productCertPEM
+	if compress {
+		productIdFileName += ".gz"
+		var compressed bytes.Buffer
+		gzipWriter := gzip.NewWriter(&compressed)
+		_, _ = gzipWriter.Write(productCertPEM)
+		_ = gzipWriter.Close()
+		productIdContent = compressed.Bytes()
+	}
+	err = os.WriteFile(filepath.Join(repoDataDirPath, productIdFileName), productIdContent, 0644)
+	if err != nil {
+		t.Fatalf("unable to write productid: %s", err)
+	}
+
+	repoMDContent := `<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo" xmlns:rpm="http://linux.duke.edu/metadata/rpm">
+  <revision>1700000000</revision>
+  <data type="primary">
+    <location href="repodata/primary.xml.gz"/>
+  </data>
+  <data type="productid">
+    <location href="repodata/` + productIdFileName + `"/>
+  </data>
+</repomd>
+`
+	err = os.WriteFile(filepath.Join(repoDataDirPath, "repomd.xml"), []byte(repoMDContent), 0644)
+	if err != nil {
+		t.Fatalf("unable to write repomd.xml: %s", err)
+	}
+	return repoDirPath
+}
+
+// TestReadProductIdFromRepoMetadata tests reading of product certificate from repository metadata
+func TestReadProductIdFromRepoMetadata(t *testing.T) {
+	t.Parallel()
+	productCertPEM := createTestingProductCert(t, "69", "Red Hat Enterprise Linux Server", "11.0")
+
+	tests := []struct {
+		name     string
+		compress bool
+		repodata bool
+	}{
+		{name: "uncompressed productid"},
+		{name: "compressed productid", compress: true},
+		{name: "repodata directory", compress: true, repodata: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			metadataDirPath := createTestingRepoMetadata(t, productCertPEM, tt.compress)
+			if tt.repodata {
+				metadataDirPath = filepath.Join(metadataDirPath, "repodata")
+			}
+			content, err := readProductIdFromRepoMetadata(metadataDirPath)
+			if err != nil {
+				t.Fatalf("unable to read productid: %s", err)
+			}
+			if !bytes.Equal(content, productCertPEM) {
+				t.Errorf("unexpected content of productid: %s", content)
+			}
+		})
+	}
+
+	_, err := readProductIdFromRepoMetadata(t.TempDir())
+	if err == nil {
+		t.Errorf("no error returned for directory without repository metadata")
+	}
+}
+
+// TestProductIdFromRepo tests installing of product certificates from repository metadata
+// and removing product certificates of disabled repositories
+func TestProductIdFromRepo(t *testing.T) {
+	t.Parallel()
+	testingFiles, err := setupTestingFileSystem(t.TempDir(), false, true, true, true, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+	rhsmClient.RHSMConf.RHSM.BaseURL = "https://cdn.example.com"
+	_, err = rhsmClient.generateRepoFileFromInstalledEntitlementCerts()
+	if err != nil {
+		t.Fatalf("unable to generate repo file: %s", err)
+	}
+
+	metadataDirPath := createTestingRepoMetadata(
+		t, createTestingProductCert(t, "69", "Red Hat Enterprise Linux Server", "11.0"), true)
+	productCertFilePath := filepath.Join(testingFiles.ProductDirPath, "69.pem")
+
+	_, err = rhsmClient.UpdateProductIdFromRepo("unknown-repo", metadataDirPath, nil)
+	if err == nil {
+		t.Errorf("product certificate installed from repository not provided by redhat.repo")
+	}
+
+	// Enabled repository provides product certificate
+	product, err := rhsmClient.UpdateProductIdFromRepo("release-content-38090", metadataDirPath, nil)
+	if err != nil {
+		t.Fatalf("unable to update product ID: %s", err)
+	}
+	if product.Id != "69" || product.filePath != productCertFilePath {
+		t.Errorf("unexpected product installed: %+v", product)
+	}
+
+	// Disabled repository provides the same product certificate
+	_, err = rhsmClient.UpdateProductIdFromRepo("awesomeos-ppc64-801", metadataDirPath, nil)
+	if err != nil {
+		t.Fatalf("unable to update product ID: %s", err)
+	}
+	productIdDB, err := rhsmClient.readProductIdDB()
+	if err != nil {
+		t.Fatalf("unable to read product ID database: %s", err)
+	}
+	expectedDB := map[string][]string{"69": {"awesomeos-ppc64-801", "release-content-38090"}}
+	if !reflect.DeepEqual(productIdDB, expectedDB) {
+		t.Errorf("expected product ID database: %v, got: %v", expectedDB, productIdDB)
+	}
+
+	// Product certificate is still provided by enabled repository
+	removed, err := rhsmClient.RemoveProductIdsOfDisabledRepos(nil)
+	if err != nil {
+		t.Fatalf("unable to remove product IDs: %s", err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("product certificates removed: %v", removed)
+	}
+	if _, err := os.Stat(productCertFilePath); err != nil {
+		t.Errorf("product certificate of enabled repository removed: %s", err)
+	}
+	productIdDB, err = rhsmClient.readProductIdDB()
+	if err != nil {
+		t.Fatalf("unable to read product ID database: %s", err)
+	}
+	expectedDB = map[string][]string{"69": {"release-content-38090"}}
+	if !reflect.DeepEqual(productIdDB, expectedDB) {
+		t.Errorf("expected product ID database: %v, got: %v", expectedDB, productIdDB)
+	}
+
+	// Disable the repository in redhat.repo
+	repoFile, err := ini.Load(testingFiles.YumRepoFilePath)
+	if err != nil {
+		t.Fatalf("unable to load repo file: %s", err)
+	}
+	repoFile.Section("release-content-38090").Key("enabled").SetValue("0")
+	err = repoFile.SaveTo(testingFiles.YumRepoFilePath)
+	if err != nil {
+		t.Fatalf("unable to save repo file: %s", err)
+	}
+
+	removed, err = rhsmClient.RemoveProductIdsOfDisabledRepos(nil)
+	if err != nil {
+		t.Fatalf("unable to remove product IDs: %s", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"69"}) {
+		t.Errorf("expected removed products: [69], got: %v", removed)
+	}
+	if _, err := os.Stat(productCertFilePath); !os.IsNotExist(err) {
+		t.Errorf("product certificate of disabled repository was not removed")
+	}
+	productIdDB, err = rhsmClient.readProductIdDB()
+	if err != nil {
+		t.Fatalf("unable to read product ID database: %s", err)
+	}
+	if len(productIdDB) != 0 {
+		t.Errorf("product ID database is not empty: %v", productIdDB)
+	}
+}
+
+// TestRemoveProductIdsOfDisabledReposUnknownState tests that product certificates are
+// not removed, when the state of repositories is not known
+func TestRemoveProductIdsOfDisabledReposUnknownState(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		registered bool
+		entCerts   bool
+		repoLabel  string
+	}{
+		{name: "unregistered system", entCerts: true, repoLabel: "awesomeos-ppc64-801"},
+		{name: "no entitlement certificate", registered: true, repoLabel: "awesomeos-ppc64-801"},
+		{name: "repository not provided", registered: true, entCerts: true, repoLabel: "unknown-repo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			testingFiles, err := setupTestingFileSystem(t.TempDir(), false, tt.registered, tt.entCerts, true, true)
+			if err != nil {
+				t.Fatalf("unable to setup testing environment: %s", err)
+			}
+			rhsmClient, err := setupTestingRHSMClient(testingFiles, nil, nil)
+			if err != nil {
+				t.Fatalf("unable to setup testing rhsm client: %s", err)
+			}
+			rhsmClient.RHSMConf.RHSM.BaseURL = "https://cdn.example.com"
+
+			productCertFilePath := filepath.Join(testingFiles.ProductDirPath, "69.pem")
+			err = os.WriteFile(productCertFilePath,
+				createTestingProductCert(t, "69", "Red Hat Enterprise Linux Server", "11.0"), 0644)
+			if err != nil {
+				t.Fatalf("unable to write product certificate: %s", err)
+			}
+			expectedDB := map[string][]string{"69": {tt.repoLabel}}
+			err = rhsmClient.writeProductIdDB(expectedDB)
+			if err != nil {
+				t.Fatalf("unable to write product ID database: %s", err)
+			}
+
+			removed, err := rhsmClient.RemoveProductIdsOfDisabledRepos(nil)
+			if err != nil {
+				t.Fatalf("unable to remove product IDs: %s", err)
+			}
+			if len(removed) != 0 {
+				t.Errorf("product certificates removed: %v", removed)
+			}
+			if _, err := os.Stat(productCertFilePath); err != nil {
+				t.Errorf("product certificate removed: %s", err)
+			}
+			productIdDB, err := rhsmClient.readProductIdDB()
+			if err != nil {
+				t.Fatalf("unable to read product ID database: %s", err)
+			}
+			if !reflect.DeepEqual(productIdDB, expectedDB) {
+				t.Errorf("expected product ID database: %v, got: %v", expectedDB, productIdDB)
+			}
+		})
+	}
+}
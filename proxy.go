@@ -0,0 +1,172 @@
+package rhsm2
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultProxyScheme is the scheme used for connection to proxy server,
+// when proxy_scheme is not set in rhsm.conf
+const DefaultProxyScheme = "http"
+
+// getEnvironmentVariable returns the value of the first environment variable
+// that is set. It is used for variables like HTTPS_PROXY and https_proxy
+func getEnvironmentVariable(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// proxyURLFromConf tries to create URL of proxy server from rhsm.conf. When no
+// proxy server is configured in rhsm.conf, then nil is returned.
+func proxyURLFromConf(serverConf *RHSMConfServer) *url.URL {
+	if serverConf.ProxyHostname == "" {
+		return nil
+	}
+
+	proxyScheme := serverConf.ProxyScheme
+	if proxyScheme == "" {
+		proxyScheme = DefaultProxyScheme
+	}
+
+	// net.JoinHostPort adds brackets to raw IPv6 address
+	proxyHostnamePort := serverConf.ProxyHostname
+	if serverConf.ProxyPort != "" {
+		proxyHostnamePort = net.JoinHostPort(serverConf.ProxyHostname, serverConf.ProxyPort)
+	}
+
+	proxyURL := &url.URL{
+		Scheme: proxyScheme,
+		Host:   proxyHostnamePort,
+	}
+	if serverConf.ProxyUser != "" || serverConf.ProxyPassword != "" {
+		proxyURL.User = url.UserPassword(serverConf.ProxyUser, serverConf.ProxyPassword)
+	}
+
+	return proxyURL
+}
+
+// proxyURLFromEnvironment tries to get URL of proxy server from HTTPS_PROXY
+// environment variable. When the URL does not contain scheme, then
+// DefaultProxyScheme is used.
+func proxyURLFromEnvironment(logger *zerolog.Logger) *url.URL {
+	envProxy := getEnvironmentVariable("HTTPS_PROXY", "https_proxy")
+	if envProxy == "" {
+		return nil
+	}
+
+	if !strings.Contains(envProxy, "://") {
+		envProxy = DefaultProxyScheme + "://" + envProxy
+	}
+
+	proxyURL, err := url.Parse(envProxy)
+	if err != nil || proxyURL.Host == "" {
+		logger.Warn().Msgf("ignoring invalid proxy URL in HTTPS_PROXY: %s", envProxy)
+		return nil
+	}
+
+	return proxyURL
+}
+
+// matchNoProxyPattern returns true, when the host and port matches one pattern
+// from no_proxy. Supported patterns are:
+//   - "*" matching all hosts
+//   - CIDR (e.g. 192.168.0.0/16) matching IP addresses in the network
+//   - IP address or hostname matching exactly the host
+//   - domain (e.g. example.com or .example.com) matching domain and all subdomains
+//
+// Pattern can contain port (e.g. example.com:8443). Such pattern matches only the given port.
+func matchNoProxyPattern(host string, port string, pattern string) bool {
+	if pattern == "*" {
+		return true
+	}
+
+	// CIDR pattern is matched only with IP addresses
+	if _, network, err := net.ParseCIDR(pattern); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && network.Contains(ip)
+	}
+
+	// Pattern can contain port
+	if patternHost, patternPort, err := net.SplitHostPort(pattern); err == nil {
+		if patternPort != port {
+			return false
+		}
+		pattern = patternHost
+	}
+	pattern = strings.Trim(pattern, "[]")
+
+	// IP address has to match exactly
+	if patternIP := net.ParseIP(pattern); patternIP != nil {
+		ip := net.ParseIP(host)
+		return ip != nil && ip.Equal(patternIP)
+	}
+
+	// Hostname and domain suffix
+	pattern = strings.ToLower(strings.TrimPrefix(pattern, "*"))
+	pattern = strings.TrimPrefix(pattern, ".")
+	host = strings.ToLower(host)
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// matchNoProxy returns true, when the host and port matches any pattern from
+// comma (or white space) separated list of no_proxy patterns
+func matchNoProxy(host string, port string, noProxy string) bool {
+	patterns := strings.FieldsFunc(noProxy, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	for _, pattern := range patterns {
+		if matchNoProxyPattern(host, port, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// getProxyFunc returns function used by http.Transport for selecting proxy server.
+// Configuration from rhsm.conf has higher priority. When no proxy server is configured
+// in rhsm.conf, then HTTPS_PROXY environment variable is used. The no_proxy option
+// from rhsm.conf is used for matching hosts. When it is not set, then NO_PROXY
+// environment variable is used.
+func (rhsmClient *RHSMClient) getProxyFunc() func(*http.Request) (*url.URL, error) {
+	proxyURL := proxyURLFromConf(&rhsmClient.RHSMConf.Server)
+	if proxyURL != nil {
+		rhsmClient.getLogger().Debug().Msgf("using proxy configuration from rhsm.conf")
+	} else {
+		proxyURL = proxyURLFromEnvironment(rhsmClient.getLogger())
+		if proxyURL != nil {
+			rhsmClient.getLogger().Debug().Msgf("using proxy configuration from environment variable HTTPS_PROXY")
+		}
+	}
+
+	if proxyURL == nil {
+		return nil
+	}
+
+	rhsmClient.getLogger().Debug().Msgf("using proxy: %s", proxyURL.Redacted())
+
+	noProxy := rhsmClient.RHSMConf.Server.NoProxy
+	if noProxy == "" {
+		noProxy = getEnvironmentVariable("NO_PROXY", "no_proxy")
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		port := req.URL.Port()
+		if port == "" {
+			port = "443"
+		}
+		if matchNoProxy(req.URL.Hostname(), port, noProxy) {
+			rhsmClient.getLogger().Debug().Msgf("not using proxy for %s (no_proxy: %s)", req.URL.Host, noProxy)
+			return nil, nil
+		}
+		return proxyURL, nil
+	}
+}
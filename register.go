@@ -1,19 +1,22 @@
 package rhsm2
 
 import (
+	"context"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
 // SystemFacts is collection of system facts necessary during registration
+//
+// Deprecated: System facts are collected by fact collectors and sent as
+// map[string]string in RegisterData.Facts. SystemFacts is not used anymore.
 type SystemFacts struct {
 	SystemCertificateVersion string `json:"system.certificate_version"`
 }
@@ -22,7 +25,7 @@ type SystemFacts struct {
 type RegisterData struct {
 	Type              string             `json:"type"`
 	Name              string             `json:"name"`
-	Facts             *SystemFacts       `json:"facts"`
+	Facts             map[string]string  `json:"facts"`
 	InstalledProducts []InstalledProduct `json:"installedProducts"`
 	ContentTags       []string           `json:"contentTags"`
 	Role              string             `json:"role"`
@@ -32,13 +35,41 @@ type RegisterData struct {
 	Environments      []Environment      `json:"environments"`
 }
 
-// RegisterError is structure used for parsing JSON document returned
-// by candlepin server, when registration is not successful
+// RegisterError is error returned, when registration is not successful.
+//
+// Deprecated: Use CandlepinError instead. RegisterError embeds CandlepinError,
+// which can be retrieved using errors.As.
 type RegisterError struct {
+	*CandlepinError
 	DisplayMessage string `json:"displayMessage"`
 	RequestUuid    string `json:"requestUuid"`
 }
 
+// newRegisterError creates RegisterError wrapping given CandlepinError
+func newRegisterError(candlepinError *CandlepinError) RegisterError {
+	return RegisterError{
+		CandlepinError: candlepinError,
+		DisplayMessage: candlepinError.DisplayMessage,
+		RequestUuid:    candlepinError.RequestUuid,
+	}
+}
+
+// Error interface
+func (registerError RegisterError) Error() string {
+	if registerError.CandlepinError != nil {
+		return registerError.CandlepinError.Error()
+	}
+	return registerError.DisplayMessage
+}
+
+// Unwrap returns wrapped CandlepinError
+func (registerError RegisterError) Unwrap() error {
+	if registerError.CandlepinError == nil {
+		return nil
+	}
+	return registerError.CandlepinError
+}
+
 // RegisterOptions is structure containing various registration options
 type RegisterOptions struct {
 	username       *string
@@ -50,6 +81,7 @@ type RegisterOptions struct {
 
 // registerSystem tries to register system
 func (rhsmClient *RHSMClient) registerSystem(
+	ctx context.Context,
 	registerOptions *RegisterOptions,
 	metadata *RequestMetadata,
 ) (*ConsumerData, error) {
@@ -83,13 +115,9 @@ func (rhsmClient *RHSMClient) registerSystem(
 		}
 	}
 
-	// It is necessary to set system certificate version to value 3.0 or higher
-	facts := SystemFacts{
-		SystemCertificateVersion: "3.2",
-		// TODO: try to get some real facts.
-	}
+	facts := rhsmClient.collectFacts()
 
-	hostname, err := os.Hostname()
+	hostname, err := rhsmClient.getHostname()
 	if err != nil {
 		return nil, fmt.Errorf("unable to get hostname: %s", err)
 	}
@@ -97,10 +125,10 @@ func (rhsmClient *RHSMClient) registerSystem(
 	var sysPurpose *SysPurposeJSON
 	sysPurpose, err = getSystemPurpose(&rhsmClient.RHSMConf.syspurposeFilePath)
 	if err != nil {
-		log.Warn().Msgf("unable to read syspurpose: %s", err)
+		rhsmClient.getLogger().Warn().Msgf("unable to read syspurpose: %s", err)
 		defaultSysPurpose := getDefaultSystemPurpose()
 		sysPurpose = &defaultSysPurpose
-		log.Info().Msgf("using default syspurpose values")
+		rhsmClient.getLogger().Info().Msgf("using default syspurpose values")
 	}
 
 	installedProducts := rhsmClient.getInstalledProducts()
@@ -112,7 +140,7 @@ func (rhsmClient *RHSMClient) registerSystem(
 	registerData := RegisterData{
 		Type:              "system",
 		Name:              hostname,
-		Facts:             &facts,
+		Facts:             facts,
 		Role:              sysPurpose.Role,
 		Usage:             sysPurpose.Usage,
 		ServiceLevel:      sysPurpose.ServiceLevelAgreement,
@@ -131,6 +159,7 @@ func (rhsmClient *RHSMClient) registerSystem(
 	}
 
 	res, err := connection.request(
+		ctx,
 		rhsmClient.UserAgent,
 		http.MethodPost,
 		"consumers",
@@ -145,26 +174,7 @@ func (rhsmClient *RHSMClient) registerSystem(
 	}
 
 	if res.StatusCode != 200 {
-		resBody, err := io.ReadAll(res.Body)
-		if err != nil {
-			return nil, fmt.Errorf(
-				"unable to register, status code: %d (unable to read response body)",
-				res.StatusCode,
-			)
-		}
-		var regError RegisterError
-		err = json.Unmarshal(resBody, &regError)
-		if err != nil {
-			return nil, fmt.Errorf(
-				"unable to register, status code: %d (unable to parse response body)",
-				res.StatusCode,
-			)
-		}
-		return nil, fmt.Errorf(
-			"unable to register, status code: %d, error message: %s",
-			res.StatusCode,
-			regError.DisplayMessage,
-		)
+		return nil, fmt.Errorf("unable to register: %w", newRegisterError(newCandlepinError(res)))
 	}
 
 	resBody, err := getResponseBody(res)
@@ -178,17 +188,26 @@ func (rhsmClient *RHSMClient) registerSystem(
 		return nil, fmt.Errorf("unable to parse consumer object: %s, %s", *resBody, err)
 	}
 
-	err = writeConsumerCert(rhsmClient.consumerCertPath(), &consumerData.IdCert.Cert)
+	err = rhsmClient.writeConsumerCert(rhsmClient.consumerCertPath(), &consumerData.IdCert.Cert)
 	if err != nil {
 		return nil, err
 	}
 
-	err = writeConsumerKey(rhsmClient.consumerKeyPath(), &consumerData.IdCert.Key)
+	err = rhsmClient.writeConsumerKey(rhsmClient.consumerKeyPath(), &consumerData.IdCert.Key)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Info().Msg("System registered")
+	rhsmClient.getLogger().Info().Msg("System registered")
+
+	// Cache reported facts to be able to detect changes of facts later
+	factsCache, err := newFactsCache(facts)
+	if err == nil {
+		err = rhsmClient.writeFactsCache(factsCache)
+	}
+	if err != nil {
+		rhsmClient.getLogger().Warn().Msgf("unable to write facts cache: %s", err)
+	}
 
 	certFilePath := filepath.Join(rhsmClient.RHSMConf.RHSM.ConsumerCertDir, "cert.pem")
 	keyFilePath := filepath.Join(rhsmClient.RHSMConf.RHSM.ConsumerCertDir, "key.pem")
@@ -212,7 +231,7 @@ func (rhsmClient *RHSMClient) registerSystem(
 		// there can be some content override associated with one of
 		// activation keys
 		getContentOverrides := registerOptions.activationKeys != nil
-		err = rhsmClient.enableContent(getContentOverrides, metadata)
+		err = rhsmClient.enableContent(ctx, getContentOverrides, metadata)
 		if err != nil {
 			return nil, err
 		}
@@ -230,6 +249,18 @@ func (rhsmClient *RHSMClient) RegisterOrgActivationKeys(
 	activationKeys []string,
 	options *map[string]string,
 	metadata *RequestMetadata,
+) (*ConsumerData, error) {
+	return rhsmClient.RegisterOrgActivationKeysWithContext(context.Background(), org, activationKeys, options, metadata)
+}
+
+// RegisterOrgActivationKeysWithContext is like RegisterOrgActivationKeys, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) RegisterOrgActivationKeysWithContext(
+	ctx context.Context,
+	org *string,
+	activationKeys []string,
+	options *map[string]string,
+	metadata *RequestMetadata,
 ) (*ConsumerData, error) {
 	var registerOptions RegisterOptions
 
@@ -238,7 +269,7 @@ func (rhsmClient *RHSMClient) RegisterOrgActivationKeys(
 	registerOptions.organization = org
 	registerOptions.activationKeys = &activationKeys
 
-	return rhsmClient.registerSystem(&registerOptions, metadata)
+	return rhsmClient.registerSystem(ctx, &registerOptions, metadata)
 }
 
 // RegisterUsernamePassword tries to register system using username and password
@@ -247,6 +278,18 @@ func (rhsmClient *RHSMClient) RegisterUsernamePassword(
 	password *string,
 	options *map[string]string,
 	metadata *RequestMetadata,
+) (*ConsumerData, error) {
+	return rhsmClient.RegisterUsernamePasswordWithContext(context.Background(), username, password, options, metadata)
+}
+
+// RegisterUsernamePasswordWithContext is like RegisterUsernamePassword, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) RegisterUsernamePasswordWithContext(
+	ctx context.Context,
+	username *string,
+	password *string,
+	options *map[string]string,
+	metadata *RequestMetadata,
 ) (*ConsumerData, error) {
 	var registerOptions RegisterOptions
 	var environments []string
@@ -261,7 +304,7 @@ func (rhsmClient *RHSMClient) RegisterUsernamePassword(
 			case "org":
 				org = value
 			default:
-				log.Warn().Msgf("unknown option: %s", option)
+				rhsmClient.getLogger().Warn().Msgf("unknown option: %s", option)
 			}
 		}
 	}
@@ -273,18 +316,23 @@ func (rhsmClient *RHSMClient) RegisterUsernamePassword(
 
 	metadata = sanitizeMetadata(metadata)
 
-	return rhsmClient.registerSystem(&registerOptions, metadata)
+	return rhsmClient.registerSystem(ctx, &registerOptions, metadata)
 }
 
-// createProductMap tries to create map of entitlement certificates
-func createProductMap(entCertKeys []EntitlementCertificateKeyJSON) map[int64][]EngineeringProduct {
+// createProductMap tries to create map of entitlement certificates. Signatures of entitlement
+// data are verified using given CA certificates
+func createProductMap(
+	logger *zerolog.Logger,
+	entCertKeys []EntitlementCertificateKeyJSON,
+	caCerts []*x509.Certificate,
+) map[int64][]EngineeringProduct {
 	var engineeringProducts = make(map[int64][]EngineeringProduct)
 	for _, entCertKey := range entCertKeys {
 		serial := entCertKey.Serial.Serial
 		certContent := &entCertKey.Cert
-		products, err := getContentFromEntCert(certContent)
+		products, err := getContentFromEntCert(certContent, caCerts)
 		if err != nil {
-			log.Warn().Msgf("unable to get content from entitlement certificate: %s", err)
+			logger.Warn().Msgf("unable to get content from entitlement certificate: %s", err)
 			continue
 		}
 		engineeringProducts[serial] = products
@@ -306,8 +354,13 @@ type ContentOverridesResult struct {
 
 // enableContent tries to get SCA entitlement certificate and generate redhat.repo from these
 // certificates. Note: candlepin returns only one SCA certificate, but it returns it
-// in the list. Thus, in theory more certificates could be returned.
-func (rhsmClient *RHSMClient) enableContent(getContentOverrides bool, info *RequestMetadata) error {
+// in the list. Thus, in theory more certificates could be returned. Both concurrent
+// REST API calls are canceled, when the given context is canceled.
+func (rhsmClient *RHSMClient) enableContent(
+	ctx context.Context,
+	getContentOverrides bool,
+	info *RequestMetadata,
+) error {
 	var waitGroup sync.WaitGroup
 
 	// Try to get SCA entitlement certificate and key asynchronously
@@ -316,7 +369,7 @@ func (rhsmClient *RHSMClient) enableContent(getContentOverrides bool, info *Requ
 	waitGroup.Add(1)
 	go func(wg *sync.WaitGroup, result chan EntCertKeysResult) {
 		defer wg.Done()
-		entCertKeys, err := rhsmClient.getSCAEntitlementCertificates(info)
+		entCertKeys, err := rhsmClient.getSCAEntitlementCertificates(ctx, info)
 		entCertKeyResult := EntCertKeysResult{entCertKeys, err}
 		result <- entCertKeyResult
 	}(&waitGroup, entCertKeysChan)
@@ -329,7 +382,7 @@ func (rhsmClient *RHSMClient) enableContent(getContentOverrides bool, info *Requ
 		waitGroup.Add(1)
 		go func(wg *sync.WaitGroup, result chan ContentOverridesResult) {
 			defer wg.Done()
-			contentOverridesList, err := rhsmClient.getContentOverrides(info)
+			contentOverridesList, err := rhsmClient.getContentOverrides(ctx, info)
 			contentOverridesResult := ContentOverridesResult{contentOverridesList, err}
 			result <- contentOverridesResult
 		}(&waitGroup, contentOverridesChan)
@@ -338,6 +391,11 @@ func (rhsmClient *RHSMClient) enableContent(getContentOverrides bool, info *Requ
 	// Wait for result of both REST API calls
 	waitGroup.Wait()
 
+	// Do not write anything, when the context was canceled in the meantime
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("unable to enable content: %w", err)
+	}
+
 	// Create empty maps of products and content overrides for corner cases
 	engineeringProducts := make(map[int64][]EngineeringProduct)
 
@@ -348,7 +406,7 @@ func (rhsmClient *RHSMClient) enableContent(getContentOverrides bool, info *Requ
 			return entCertKeysResult.err
 		}
 		// Get content from entitlement certificates
-		engineeringProducts = createProductMap(entCertKeysResult.entCertKeyJSONList)
+		engineeringProducts = createProductMap(rhsmClient.getLogger(), entCertKeysResult.entCertKeyJSONList, rhsmClient.loadCACertificates())
 	}
 
 	// Try to get content overrides from the channel
@@ -358,31 +416,29 @@ func (rhsmClient *RHSMClient) enableContent(getContentOverrides bool, info *Requ
 			if contentOverridesResult.err != nil {
 				return contentOverridesResult.err
 			}
-			if len(contentOverridesResult.contentOverridesList) > 0 {
-				err := writeContentOverridesToDnf5RepoOverride(
-					contentOverridesResult.contentOverridesList,
-					dnf5RedHatReposOverrideFilePath,
-				)
-				if err != nil {
-					log.Warn().Msgf("unable to write content overrides to repo file: %s", err)
-				}
+			err := rhsmClient.saveContentOverrides(contentOverridesResult.contentOverridesList)
+			if err != nil {
+				rhsmClient.getLogger().Warn().Msgf("unable to write content overrides to repo file: %s", err)
 			}
 		}
 	}
 
 	// Write content to the redhat.repo file
 	if len(engineeringProducts) > 0 {
-		err := rhsmClient.writeRepoFile(engineeringProducts)
+		_, err := rhsmClient.writeRepoFile(engineeringProducts)
 		if err != nil {
 			return fmt.Errorf("unable to write repo file: %s: %s",
 				rhsmClient.RHSMConf.yumRepoFilePath, err)
 		}
-		log.Info().Msgf("%s generated", rhsmClient.RHSMConf.yumRepoFilePath)
+		rhsmClient.getLogger().Info().Msgf("%s generated", rhsmClient.RHSMConf.yumRepoFilePath)
 	} else {
-		log.Debug().Msgf("skipping writing repo file %s, because the list of engineering products is empty",
+		rhsmClient.getLogger().Debug().Msgf("skipping writing repo file %s, because the list of engineering products is empty",
 			rhsmClient.RHSMConf.yumRepoFilePath)
 	}
 
+	// Install other types of content
+	rhsmClient.updateContent(engineeringProducts)
+
 	return nil
 }
 
@@ -391,7 +447,7 @@ func (rhsmClient *RHSMClient) enableContent(getContentOverrides bool, info *Requ
 func (rhsmClient *RHSMClient) getInstalledProducts() []InstalledProduct {
 	installedProducts, err := rhsmClient.readAllProductCertificates()
 	if err != nil {
-		log.Warn().Msgf("unable to read any director with product certificates: %s\n", err)
+		rhsmClient.getLogger().Warn().Msgf("unable to read any director with product certificates: %s\n", err)
 	}
 	return installedProducts
 }
@@ -419,14 +475,12 @@ func createListOfContentTags(installedProducts []InstalledProduct) []string {
 
 // writeConsumerCert tries to write consumer certificate. It is
 // typically /etc/pki/consumer/cert.pem
-func writeConsumerCert(consumerCertFilePath *string, consumerCert *string) error {
-	var mode os.FileMode = 0640
-	return writePemFile(consumerCertFilePath, consumerCert, &mode)
+func (rhsmClient *RHSMClient) writeConsumerCert(consumerCertFilePath *string, consumerCert *string) error {
+	return writePemFile(rhsmClient.getLogger(), consumerCertFilePath, consumerCert, rhsmClient.getFilePermissions().Cert)
 }
 
 // writeConsumerKey tries to write consumer key. It is typically
 // /etc/pki/consumer/key.pem
-func writeConsumerKey(consumerKeyFilePath *string, consumerKey *string) error {
-	var mode os.FileMode = 0640
-	return writePemFile(consumerKeyFilePath, consumerKey, &mode)
+func (rhsmClient *RHSMClient) writeConsumerKey(consumerKeyFilePath *string, consumerKey *string) error {
+	return writePemFile(rhsmClient.getLogger(), consumerKeyFilePath, consumerKey, rhsmClient.getFilePermissions().Key)
 }
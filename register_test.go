@@ -1,6 +1,9 @@
 package rhsm2
 
 import (
+	"context"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -1250,3 +1253,158 @@ func TestRegisterTwoActivationsKeyOrg(t *testing.T) {
 
 	helperTestInstalledFiles(t, tempDirFilePath)
 }
+
+// TestRegisterSystemFacts test the case, when system facts collected from
+// testing /sys and /proc tree together with custom facts are sent to
+// the server during registration
+func TestRegisterSystemFacts(t *testing.T) {
+	t.Parallel()
+	expectedConsumerUUID := "0b497970-760f-4623-943a-673c125f5b8e"
+	var registerData RegisterData
+
+	username := "admin"
+	password := "admin"
+
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			reqURL := req.URL.String()
+
+			if req.Method == http.MethodPost && reqURL == "/consumers" {
+				body, err := io.ReadAll(req.Body)
+				if err != nil {
+					t.Fatalf("unable to read body of request: %s", err)
+				}
+				err = json.Unmarshal(body, &registerData)
+				if err != nil {
+					t.Fatalf("unable to unmarshal body of request: %s", err)
+				}
+				rw.WriteHeader(200)
+				_, _ = rw.Write([]byte(consumerCreatedResponse))
+			} else if req.Method == http.MethodGet && reqURL == "/consumers/"+expectedConsumerUUID+"/certificates" {
+				rw.WriteHeader(200)
+				_, _ = rw.Write([]byte(entitlementCertCreatedResponse))
+			} else {
+				t.Fatalf("unexpected REST API call: %s %s", req.Method, reqURL)
+			}
+		}))
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, true, false, false, false, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	// TODO: try to use secure connection
+	rhsmClient.RHSMConf.Server.Insecure = true
+
+	_, err = rhsmClient.RegisterUsernamePassword(&username, &password, nil, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %s", err)
+	}
+
+	expectedFacts := map[string]string{
+		"system.certificate_version":     "3.2",
+		"dmi.system.manufacturer":        "QEMU",
+		"cpu.cpu(s)":                     "4",
+		"memory.memtotal":                "8024668",
+		"net.interface.eth0.mac_address": "52:54:00:12:34:56",
+		"uname.release":                  "6.12.0-55.el10.x86_64",
+		"distribution.name":              "Red Hat Enterprise Linux",
+		"virt.is_guest":                  "true",
+		"site.name":                      "brno",
+		"cost_center":                    "CC-1234",
+		"uname.nodename":                 "node1.brno.example.com",
+	}
+	for key, expectedValue := range expectedFacts {
+		if registerData.Facts[key] != expectedValue {
+			t.Errorf("expected fact %s: %s, got: %s", key, expectedValue, registerData.Facts[key])
+		}
+	}
+
+	// Reported facts should be cached
+	changed, _, err := rhsmClient.FactsChanged()
+	if err != nil {
+		t.Fatalf("unable to compare facts with cache: %s", err)
+	}
+	if changed {
+		t.Errorf("facts reported during registration were not cached")
+	}
+}
+
+// TestRegisterActivationKeyOrgContextCanceled test the case, when context is
+// canceled during getting SCA entitlement certificate. Concurrent REST API calls
+// have to be canceled and redhat.repo must not be generated
+func TestRegisterActivationKeyOrgContextCanceled(t *testing.T) {
+	t.Parallel()
+	expectedConsumerUUID := "3d9f61ba-2776-43fe-8256-7a30918cdb96"
+
+	orgId := "donaldduck"
+	activationKey := "awesome_os_pool"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			reqURL := req.URL.String()
+
+			if req.Method == http.MethodPost && reqURL == "/consumers?owner="+orgId+"&activation_keys="+activationKey {
+				rw.WriteHeader(200)
+				_, _ = rw.Write([]byte(consumerCreatedResponseActivationKey))
+			} else if req.Method == http.MethodGet && reqURL == "/consumers/"+expectedConsumerUUID+"/certificates" {
+				// Cancel the context and wait until the client closes connection
+				cancel()
+				<-req.Context().Done()
+			} else if req.Method == http.MethodGet && reqURL == "/consumers/"+expectedConsumerUUID+"/content_overrides" {
+				rw.WriteHeader(200)
+				_, _ = rw.Write([]byte("[]"))
+			} else {
+				t.Errorf("unexpected REST API call: %s %s", req.Method, reqURL)
+			}
+		}))
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, true, false, false, false, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	rhsmClient.RHSMConf.Server.Insecure = true
+
+	activationKeys := []string{activationKey}
+	_, err = rhsmClient.RegisterOrgActivationKeysWithContext(ctx, &orgId, activationKeys, nil, nil)
+	if err == nil {
+		t.Fatalf("registration with canceled context did not fail")
+	}
+
+	expectedEntitlementCertFilePath := filepath.Join(tempDirFilePath, "etc/pki/entitlement/1454563328016773404.pem")
+	if _, err := os.Stat(expectedEntitlementCertFilePath); err == nil {
+		t.Errorf("entitlement cert file %s installed", expectedEntitlementCertFilePath)
+	}
+
+	// Testing file system contains only empty redhat.repo
+	expectedRepoFilePath := filepath.Join(tempDirFilePath, "etc/yum.repos.d/redhat.repo")
+	repoFileInfo, err := os.Stat(expectedRepoFilePath)
+	if err != nil {
+		t.Fatalf("unable to stat repo file %s: %s", expectedRepoFilePath, err)
+	}
+	if repoFileInfo.Size() != 0 {
+		t.Errorf("repo file %s generated", expectedRepoFilePath)
+	}
+}
@@ -1,6 +1,7 @@
 package rhsm2
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,8 +10,9 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
 // isAnyRequiredTagProvided tries to find if any of the required tags is provided in the list
@@ -22,7 +24,7 @@ import (
 //	requiredTags = ["rhel-11", "rhel-11-x86_64"]
 //	releaseTags = ["rhel-11-x86_64"]
 //	isAnyRequiredTagProvided(requiredTags, releaseTags) -> true
-func isAnyRequiredTagProvided(requiredTags []string, releaseTags []string) bool {
+func isAnyRequiredTagProvided(logger *zerolog.Logger, requiredTags []string, releaseTags []string) bool {
 	// If no tags are required, then return true
 	if len(requiredTags) == 0 {
 		return true
@@ -32,7 +34,7 @@ func isAnyRequiredTagProvided(requiredTags []string, releaseTags []string) bool
 		for _, releaseTag := range releaseTags {
 			// It is enough to check if the required tag starts with the release tag.
 			if strings.HasPrefix(releaseTag, requiredTag) {
-				log.Debug().Msgf("required tag %s matches release tags: %s", requiredTag, releaseTags)
+				logger.Debug().Msgf("required tag %s matches release tags: %s", requiredTag, releaseTags)
 				return true
 			}
 		}
@@ -64,10 +66,10 @@ type OSRelease struct {
 	VersionMinor string
 }
 
-// parseOSRelease tries to parse the content of the /etc/os-release file.
-// It reads only the ID and VERSION_ID attributes.
-func parseOSRelease(content *[]byte) (*OSRelease, error) {
-	release := OSRelease{}
+// parseOSReleaseVariables tries to parse all variables from the content
+// of the /etc/os-release file. Empty lines and comments are ignored.
+func parseOSReleaseVariables(content *[]byte) map[string]string {
+	variables := make(map[string]string)
 	lines := strings.Split(string(*content), "\n")
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -80,13 +82,18 @@ func parseOSRelease(content *[]byte) (*OSRelease, error) {
 		}
 		key := strings.TrimSpace(parts[0])
 		value := strings.Trim(strings.TrimSpace(parts[1]), "\"'")
+		variables[key] = value
+	}
+	return variables
+}
 
-		switch key {
-		case "ID":
-			release.ID = value
-		case "VERSION_ID":
-			release.VersionID = value
-		}
+// parseOSRelease tries to parse the content of the /etc/os-release file.
+// It reads only the ID and VERSION_ID attributes.
+func parseOSRelease(content *[]byte) (*OSRelease, error) {
+	variables := parseOSReleaseVariables(content)
+	release := OSRelease{
+		ID:        variables["ID"],
+		VersionID: variables["VERSION_ID"],
 	}
 
 	if release.ID == "" || release.VersionID == "" {
@@ -144,7 +151,7 @@ func (rhsmClient *RHSMClient) filterInstalledProductsUsingOSRelease(installedPro
 		return nil, err
 	}
 
-	log.Debug().Msgf("os release: %s-%s parsed from: %v",
+	rhsmClient.getLogger().Debug().Msgf("os release: %s-%s parsed from: %v",
 		release.ID,
 		release.VersionMajor,
 		rhsmClient.RHSMConf.osReleaseFilePath,
@@ -166,7 +173,7 @@ func (rhsmClient *RHSMClient) filterInstalledProductsUsingOSRelease(installedPro
 			}
 		}
 		if !found {
-			log.Warn().Msgf(
+			rhsmClient.getLogger().Warn().Msgf(
 				"skipping product: %s; its tags: %s do not match os release: %s",
 				product.filePath,
 				product.providedTags,
@@ -185,7 +192,7 @@ func (rhsmClient *RHSMClient) filterInstalledProductsUsingOSRelease(installedPro
 }
 
 // getListingFile tries to get the content of the 'listing' file from CDN
-func (rhsmClient *RHSMClient) getListingFile(listingPath string, metadata *RequestMetadata) (*string, error) {
+func (rhsmClient *RHSMClient) getListingFile(ctx context.Context, listingPath string, metadata *RequestMetadata) (*string, error) {
 	connection, err := rhsmClient.getEntitlementCertAuthConnection()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get entitlement cert auth connection: %s", err)
@@ -194,6 +201,7 @@ func (rhsmClient *RHSMClient) getListingFile(listingPath string, metadata *Reque
 	metadata = sanitizeMetadata(metadata)
 
 	resp, err := connection.request(
+		ctx,
 		rhsmClient.UserAgent,
 		http.MethodGet,
 		listingPath,
@@ -206,7 +214,7 @@ func (rhsmClient *RHSMClient) getListingFile(listingPath string, metadata *Reque
 	if err != nil {
 		return nil, err
 	} else if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("unable to get content listing: %d", resp.StatusCode)
+		return nil, fmt.Errorf("unable to get content listing: %w", newCandlepinError(resp))
 	}
 
 	respBody, err := getResponseBody(resp)
@@ -223,38 +231,50 @@ const DefaultDnfVarsReleaseFilePath = DefaultDnfVarsDirPath + "releasever"
 // setDnfVarsRelease tries to set the release only on the host in the variable file /etc/dnf/vars/releasever.
 func (rhsmClient *RHSMClient) setDnfVarsRelease(release string) error {
 	dnfVarsDirPath := filepath.Dir(rhsmClient.RHSMConf.dnfVarsReleaseFilePath)
-	err := os.MkdirAll(dnfVarsDirPath, 0755)
+	err := os.MkdirAll(dnfVarsDirPath, rhsmClient.getFilePermissions().Dir)
 	if err != nil {
 		return fmt.Errorf("unable to create directory %s: %s", dnfVarsDirPath, err)
 	}
 
-	releaseFile, err := os.Create(rhsmClient.RHSMConf.dnfVarsReleaseFilePath)
+	err = writeFileAtomically(
+		rhsmClient.getLogger(),
+		rhsmClient.RHSMConf.dnfVarsReleaseFilePath,
+		[]byte(release),
+		rhsmClient.getFilePermissions().Config,
+	)
 	if err != nil {
-		return fmt.Errorf("unable to create file %s: %s", rhsmClient.RHSMConf.dnfVarsReleaseFilePath, err)
+		return fmt.Errorf("unable to write file %s: %s", rhsmClient.RHSMConf.dnfVarsReleaseFilePath, err)
 	}
 
-	defer func(releaseFile *os.File) {
-		err := releaseFile.Close()
-		if err != nil {
-			log.Warn().Msgf("unable to close file: %s", err)
-		}
-	}(releaseFile)
+	return nil
+}
 
-	_, err = releaseFile.WriteString(release)
-	if err != nil {
-		return err
+// backgroundContext returns the context used for requests done asynchronously. The
+// returned context is not canceled, when given context is canceled, but values of
+// given context are kept. The server_timeout from rhsm.conf is used as the time limit.
+func (rhsmClient *RHSMClient) backgroundContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx = context.WithoutCancel(ctx)
+	if rhsmClient.RHSMConf.Server.Timeout > 0 {
+		return context.WithTimeout(ctx, time.Duration(rhsmClient.RHSMConf.Server.Timeout)*time.Second)
 	}
-
-	return nil
+	return context.WithCancel(ctx)
 }
 
 // SetRelease tries to set the release on the host in the variable file /etc/dnf/vars/releasever.
 // It also tries to set the release on the candlepin server. The set release on the server is done
 // asynchronously. When the release is set to "", then delete the release file.
 func (rhsmClient *RHSMClient) SetRelease(release string, metadata *RequestMetadata) error {
+	return rhsmClient.SetReleaseWithContext(context.Background(), release, metadata)
+}
+
+// SetReleaseWithContext is like SetRelease, but it uses the given context. The request
+// to the server is done asynchronously. Thus, it is not canceled, when the context is
+// canceled after returning from this method. The request is limited by server_timeout
+// from rhsm.conf instead.
+func (rhsmClient *RHSMClient) SetReleaseWithContext(ctx context.Context, release string, metadata *RequestMetadata) error {
 	// When the release is empty, then try to delete the release file.
 	if release == "" {
-		err := rhsmClient.UnsetRelease()
+		err := rhsmClient.UnsetReleaseWithContext(ctx)
 		if err != nil {
 			return err
 		}
@@ -266,9 +286,11 @@ func (rhsmClient *RHSMClient) SetRelease(release string, metadata *RequestMetada
 		return err
 	}
 	go func() {
-		err := rhsmClient.setReleaseOnServer(metadata, release)
+		ctx, cancel := rhsmClient.backgroundContext(ctx)
+		defer cancel()
+		err := rhsmClient.setReleaseOnServer(ctx, metadata, release)
 		if err != nil {
-			log.Warn().Msgf("unable to set release on server: %s", err)
+			rhsmClient.getLogger().Warn().Msgf("unable to set release on server: %s", err)
 		}
 	}()
 	return nil
@@ -291,14 +313,24 @@ func (rhsmClient *RHSMClient) unsetDnfVarsRelease() error {
 // It also tries to unset the release on the candlepin server. The unset release on the server is done
 // asynchronously.
 func (rhsmClient *RHSMClient) UnsetRelease() error {
+	return rhsmClient.UnsetReleaseWithContext(context.Background())
+}
+
+// UnsetReleaseWithContext is like UnsetRelease, but it uses the given context. The request
+// to the server is done asynchronously. Thus, it is not canceled, when the context is
+// canceled after returning from this method. The request is limited by server_timeout
+// from rhsm.conf instead.
+func (rhsmClient *RHSMClient) UnsetReleaseWithContext(ctx context.Context) error {
 	err := rhsmClient.unsetDnfVarsRelease()
 	if err != nil {
 		return err
 	}
 	go func() {
-		err := rhsmClient.setReleaseOnServer(nil, "")
+		ctx, cancel := rhsmClient.backgroundContext(ctx)
+		defer cancel()
+		err := rhsmClient.setReleaseOnServer(ctx, nil, "")
 		if err != nil {
-			log.Warn().Msgf("unable to unset release on server: %s", err)
+			rhsmClient.getLogger().Warn().Msgf("unable to unset release on server: %s", err)
 		}
 	}()
 	return nil
@@ -318,7 +350,7 @@ func (rhsmClient *RHSMClient) GetDnfVarsRelease() (string, error) {
 	defer func(releaseFile *os.File) {
 		err := releaseFile.Close()
 		if err != nil {
-			log.Warn().Msgf("unable to close file: %s", err)
+			rhsmClient.getLogger().Warn().Msgf("unable to close file: %s", err)
 		}
 	}(releaseFile)
 	release, err := os.ReadFile(rhsmClient.RHSMConf.dnfVarsReleaseFilePath)
@@ -335,7 +367,7 @@ type Release struct {
 
 // setReleaseOnServer tries to set the release on the candlepin server only (not on the host in the variable
 // file in /etc/dnf/vars/).
-func (rhsmClient *RHSMClient) setReleaseOnServer(metadata *RequestMetadata, release string) error {
+func (rhsmClient *RHSMClient) setReleaseOnServer(ctx context.Context, metadata *RequestMetadata, release string) error {
 	consumerUuid, err := rhsmClient.GetConsumerUUID()
 
 	if err != nil {
@@ -360,6 +392,7 @@ func (rhsmClient *RHSMClient) setReleaseOnServer(metadata *RequestMetadata, rele
 		return fmt.Errorf("unable to get consumer cert auth connection: %v", err)
 	}
 	res, err := connection.request(
+		ctx,
 		rhsmClient.UserAgent,
 		http.MethodPut,
 		"consumers/"+*consumerUuid,
@@ -375,7 +408,7 @@ func (rhsmClient *RHSMClient) setReleaseOnServer(metadata *RequestMetadata, rele
 	}
 
 	if res.StatusCode != 204 {
-		return fmt.Errorf("unable to set release: %d", res.StatusCode)
+		return fmt.Errorf("unable to set release: %w", newCandlepinError(res))
 	}
 
 	return nil
@@ -383,6 +416,12 @@ func (rhsmClient *RHSMClient) setReleaseOnServer(metadata *RequestMetadata, rele
 
 // GetReleaseFromServer tries to get the latest release from the candlepin server.
 func (rhsmClient *RHSMClient) GetReleaseFromServer(metadata *RequestMetadata) (string, error) {
+	return rhsmClient.GetReleaseFromServerWithContext(context.Background(), metadata)
+}
+
+// GetReleaseFromServerWithContext is like GetReleaseFromServer, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) GetReleaseFromServerWithContext(ctx context.Context, metadata *RequestMetadata) (string, error) {
 	consumerUuid, err := rhsmClient.GetConsumerUUID()
 
 	if err != nil {
@@ -398,6 +437,7 @@ func (rhsmClient *RHSMClient) GetReleaseFromServer(metadata *RequestMetadata) (s
 		return "", fmt.Errorf("unable to get consumer cert auth connection: %v", err)
 	}
 	res, err := connection.request(
+		ctx,
 		rhsmClient.UserAgent,
 		http.MethodGet,
 		"consumers/"+*consumerUuid+"/release",
@@ -413,7 +453,7 @@ func (rhsmClient *RHSMClient) GetReleaseFromServer(metadata *RequestMetadata) (s
 	}
 
 	if res.StatusCode != 200 {
-		return "", fmt.Errorf("unable to get latest release: %d", res.StatusCode)
+		return "", fmt.Errorf("unable to get latest release: %w", newCandlepinError(res))
 	}
 	resBody, err := getResponseBody(res)
 	if err != nil {
@@ -448,7 +488,7 @@ func (rhsmClient *RHSMClient) getReleaseTags() ([]string, error) {
 	for _, product := range filteredInstalledProducts {
 		installedProductFilePaths = append(installedProductFilePaths, product.filePath)
 	}
-	log.Debug().Msgf("trying to get release tags from installed products: %v", installedProductFilePaths)
+	rhsmClient.getLogger().Debug().Msgf("trying to get release tags from installed products: %v", installedProductFilePaths)
 
 	requiredTags := createListOfContentTags(filteredInstalledProducts)
 	return requiredTags, nil
@@ -457,6 +497,15 @@ func (rhsmClient *RHSMClient) getReleaseTags() ([]string, error) {
 // GetCdnReleases tries to get the list of available releases from CDN. The list of releases
 // should include only unique values of releases. There should not be any duplicates.
 func (rhsmClient *RHSMClient) GetCdnReleases(metadata *RequestMetadata) (map[string]struct{}, error) {
+	return rhsmClient.GetCdnReleasesWithContext(context.Background(), metadata)
+}
+
+// GetCdnReleasesWithContext is like GetCdnReleases, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) GetCdnReleasesWithContext(
+	ctx context.Context,
+	metadata *RequestMetadata,
+) (map[string]struct{}, error) {
 	// If the connection to the repository does not exist, return error
 	_, err := rhsmClient.getEntitlementCertAuthConnection()
 	if err != nil {
@@ -478,14 +527,14 @@ func (rhsmClient *RHSMClient) GetCdnReleases(metadata *RequestMetadata) (map[str
 	// used for getting the list of available releases
 	releaseTags, err := rhsmClient.getReleaseTags()
 	if err != nil {
-		log.Debug().Msgf("unable to get release tags: %s", err)
+		rhsmClient.getLogger().Debug().Msgf("unable to get release tags: %s", err)
 		return nil, err
 	}
-	log.Debug().Msgf("release tags: %v", releaseTags)
+	rhsmClient.getLogger().Debug().Msgf("release tags: %v", releaseTags)
 
-	listingPaths := getListingPathFromEngProducts(engineeringProductsMap, releaseTags)
+	listingPaths := getListingPathFromEngProducts(rhsmClient.getLogger(), engineeringProductsMap, releaseTags)
 
-	releases := rhsmClient.getAllReleasesFromPaths(listingPaths, metadata)
+	releases := rhsmClient.getAllReleasesFromPaths(ctx, listingPaths, metadata)
 
 	return releases, nil
 }
@@ -493,19 +542,19 @@ func (rhsmClient *RHSMClient) GetCdnReleases(metadata *RequestMetadata) (map[str
 // getAllReleasesFromPaths tries to get the list of available releases from given content paths.
 // The list of releases should include only unique values of releases. There should not be
 // any duplicates.
-func (rhsmClient *RHSMClient) getAllReleasesFromPaths(listingPaths map[string]struct{}, metadata *RequestMetadata) map[string]struct{} {
+func (rhsmClient *RHSMClient) getAllReleasesFromPaths(ctx context.Context, listingPaths map[string]struct{}, metadata *RequestMetadata) map[string]struct{} {
 	var releaseMap = make(map[string]struct{})
 	for path := range listingPaths {
 		listingPath := filepath.Join(path, "/listing")
-		respBody, err := rhsmClient.getListingFile(listingPath, metadata)
+		respBody, err := rhsmClient.getListingFile(ctx, listingPath, metadata)
 		if err != nil {
-			log.Warn().Msgf("failed to retrieve listing file from path: %s: %s", path, err)
+			rhsmClient.getLogger().Warn().Msgf("failed to retrieve listing file from path: %s: %s", path, err)
 			continue
 		}
 
-		releases := parseListingFileContent(respBody, &listingPath)
+		releases := parseListingFileContent(rhsmClient.getLogger(), respBody, &listingPath)
 
-		log.Debug().Msgf("got %v releases from path: %s", releases, path)
+		rhsmClient.getLogger().Debug().Msgf("got %v releases from path: %s", releases, path)
 
 		for _, release := range releases {
 			if _, exists := releaseMap[release]; !exists {
@@ -522,6 +571,7 @@ func (rhsmClient *RHSMClient) getAllReleasesFromPaths(listingPaths map[string]st
 // Each item of the map contains the list of content labels. The list of release tags is used for
 // filtering the content.
 func getListingPathFromEngProducts(
+	logger *zerolog.Logger,
 	engineeringProductsMap map[int64][]EngineeringProduct,
 	releaseTags []string,
 ) map[string]struct{} {
@@ -534,8 +584,8 @@ func getListingPathFromEngProducts(
 				// then the content is considered as enabled by default.
 				if content.Enabled == nil || *content.Enabled {
 					// Check if any of tag required by content is provided in the release tags
-					if !isAnyRequiredTagProvided(content.RequiredTags, releaseTags) {
-						log.Debug().Msgf(
+					if !isAnyRequiredTagProvided(logger, content.RequiredTags, releaseTags) {
+						logger.Debug().Msgf(
 							"skipping content: '%s'; no of its required tags: %s found in release tags: %s",
 							content.Label, content.RequiredTags, releaseTags,
 						)
@@ -546,7 +596,7 @@ func getListingPathFromEngProducts(
 						continue
 					}
 					if _, exists := listingPaths[basePath]; !exists {
-						log.Debug().Msgf("adding path %s to the list of listing paths", basePath)
+						logger.Debug().Msgf("adding path %s to the list of listing paths", basePath)
 						listingPaths[basePath] = struct{}{}
 					}
 				}
@@ -566,7 +616,7 @@ func getListingPathFromEngProducts(
 // 10.1
 // 10.2
 // 10.3
-func parseListingFileContent(respBody *string, listingPath *string) []string {
+func parseListingFileContent(logger *zerolog.Logger, respBody *string, listingPath *string) []string {
 	releasesMap := make(map[string]struct{})
 	lines := strings.Split(*respBody, "\n")
 	for _, line := range lines {
@@ -580,7 +630,7 @@ func parseListingFileContent(respBody *string, listingPath *string) []string {
 			if _, exists := releasesMap[line]; !exists {
 				releasesMap[line] = struct{}{}
 			} else {
-				log.Warn().Msgf("duplicate release found: %s in %s", line, *listingPath)
+				logger.Warn().Msgf("duplicate release found: %s in %s", line, *listingPath)
 			}
 		}
 	}
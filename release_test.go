@@ -1,12 +1,17 @@
 package rhsm2
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
 func Test_getListingPath(t *testing.T) {
@@ -109,7 +114,7 @@ func Test_parseListingFile(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := parseListingFileContent(&tt.content, &tt.path)
+			got := parseListingFileContent(&log.Logger, &tt.content, &tt.path)
 			if len(got) != len(tt.want) {
 				t.Errorf("%s: parseListingFileContent() = %v, want %v", tt.name, got, tt.want)
 				return
@@ -318,7 +323,7 @@ func Test_getListingPathFromEngProducts(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := getListingPathFromEngProducts(tt.engineeringProducts, tt.productTags)
+			got := getListingPathFromEngProducts(&log.Logger, tt.engineeringProducts, tt.productTags)
 			if len(got) != len(tt.expectedListingPaths) {
 				t.Errorf("getListingPathFromEngProducts() got = %v, expected %v", got, tt.expectedListingPaths)
 				return
@@ -658,7 +663,7 @@ func Test_SetReleaseOnServer(t *testing.T) {
 				t.Fatalf("unable to setup testing rhsm client: %s", err)
 			}
 
-			err = rhsmClient.setReleaseOnServer(nil, tt.releaseVer)
+			err = rhsmClient.setReleaseOnServer(context.Background(), nil, tt.releaseVer)
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("%s: setReleaseOnServer() error = %v, wantErr %v", tt.name, err, tt.wantErr)
@@ -721,7 +726,7 @@ func Test_isAnyRequiredTagProvided(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := isAnyRequiredTagProvided(tt.requiredTags, tt.providedTags)
+			got := isAnyRequiredTagProvided(&log.Logger, tt.requiredTags, tt.providedTags)
 			if got != tt.want {
 				t.Errorf("%s: isAnyRequiredTagProvided() = %v, want %v", tt.name, got, tt.want)
 			}
@@ -752,7 +757,7 @@ func Test_SetReleaseOnServerUnregistered(t *testing.T) {
 		t.Fatalf("unable to setup testing rhsm client: %s", err)
 	}
 
-	err = rhsmClient.setReleaseOnServer(nil, "10.1")
+	err = rhsmClient.setReleaseOnServer(context.Background(), nil, "10.1")
 	if err == nil {
 		t.Fatal("expected error when setting release on unregistered system")
 	}
@@ -1410,3 +1415,47 @@ HOME_URL="https://www.redhat.com/"`,
 		})
 	}
 }
+
+// Test_SetReleaseCanceledContext test that the release is set on the server, when
+// the context is canceled right after returning from SetReleaseWithContext
+func Test_SetReleaseCanceledContext(t *testing.T) {
+	t.Parallel()
+	requestBody := make(chan string, 1)
+
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodPut {
+				t.Errorf("unexpected HTTP method: %s", req.Method)
+			}
+			body, _ := io.ReadAll(req.Body)
+			rw.WriteHeader(204)
+			requestBody <- string(body)
+		}))
+	defer server.Close()
+
+	testingFiles, err := setupTestingFileSystem(t.TempDir(), true, true, true, true, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = rhsmClient.SetReleaseWithContext(ctx, "11.5", nil)
+	cancel()
+	if err != nil {
+		t.Fatalf("SetReleaseWithContext() returned error: %s", err)
+	}
+
+	select {
+	case body := <-requestBody:
+		if !strings.Contains(body, "11.5") {
+			t.Errorf("unexpected body of request: %s", body)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("release was not set on the server")
+	}
+}
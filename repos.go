@@ -0,0 +1,557 @@
+package rhsm2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// contentOverrideData is structure representing JSON data used for
+// creating and removing content overrides
+type contentOverrideData struct {
+	ContentLabel string `json:"contentLabel"`
+	Name         string `json:"name,omitempty"`
+	Value        string `json:"value,omitempty"`
+}
+
+// matchRepoLabels tries to find labels of repositories matching given patterns.
+// Patterns can contain wildcards supported by path.Match (e.g. "rhel-9-*").
+// When some pattern does not match any repository, then error is returned.
+// The returned list of labels is sorted and it does not contain duplicities.
+func matchRepoLabels(patterns []string, repoLabels []string) ([]string, error) {
+	matched := make(map[string]struct{})
+	var unmatchedPatterns []string
+
+	for _, pattern := range patterns {
+		patternMatched := false
+		for _, repoLabel := range repoLabels {
+			match, err := path.Match(pattern, repoLabel)
+			if err != nil {
+				return nil, fmt.Errorf("invalid repository pattern: %s: %s", pattern, err)
+			}
+			if match {
+				matched[repoLabel] = struct{}{}
+				patternMatched = true
+			}
+		}
+		if !patternMatched {
+			unmatchedPatterns = append(unmatchedPatterns, pattern)
+		}
+	}
+
+	if len(unmatchedPatterns) > 0 {
+		return nil, fmt.Errorf("no repository matches: %s", strings.Join(unmatchedPatterns, ", "))
+	}
+
+	result := make([]string, 0, len(matched))
+	for repoLabel := range matched {
+		result = append(result, repoLabel)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// getAvailableRepoLabels tries to get labels of all repositories provided
+// by installed entitlement certificates. Content of other types than yum and
+// content not supported by the architecture of the host is ignored, because
+// it is never written to redhat.repo
+func (rhsmClient *RHSMClient) getAvailableRepoLabels() ([]string, error) {
+	engineeringProductsMap, err := rhsmClient.getEngineeringProducts()
+	if err != nil {
+		return nil, err
+	}
+
+	hostArch := hostArchitecture()
+	var repoLabels []string
+	for _, engineeringProducts := range engineeringProductsMap {
+		for _, engineeringProduct := range engineeringProducts {
+			for _, content := range engineeringProduct.Content {
+				if !isUsableRepoContent(&content, hostArch) {
+					continue
+				}
+				repoLabels = append(repoLabels, content.Label)
+			}
+		}
+	}
+
+	return repoLabels, nil
+}
+
+// changeContentOverrides tries to create (PUT) or remove (DELETE) content overrides
+// on the candlepin server. The server returns the list of all content overrides
+// of the consumer after the change.
+func (rhsmClient *RHSMClient) changeContentOverrides(
+	ctx context.Context,
+	method string,
+	contentOverrides []contentOverrideData,
+	metadata *RequestMetadata,
+) ([]ContentOverride, error) {
+	consumerUuid, err := rhsmClient.GetConsumerUUID()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get consumer uuid: %v", err)
+	}
+
+	body, err := json.Marshal(contentOverrides)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal content overrides: %s", err)
+	}
+
+	var headers = make(map[string]string)
+	headers["Content-type"] = "application/json"
+
+	connection, err := rhsmClient.getCertAuthConnection()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get consumer cert auth connection: %v", err)
+	}
+	res, err := connection.request(
+		ctx,
+		rhsmClient.UserAgent,
+		method,
+		"consumers/"+*consumerUuid+"/content_overrides",
+		"",
+		"",
+		&headers,
+		&body,
+		metadata,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to change content overrides: %s", err)
+	}
+
+	defer func() {
+		// We can ignore error returning, by Close(), because we only
+		// read content of body
+		_ = res.Body.Close()
+	}()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to change content overrides: %w", newCandlepinError(res))
+	}
+
+	resBody, err := getResponseBody(res)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []ContentOverride
+	err = json.Unmarshal([]byte(*resBody), &result)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse content overrides: %s", err)
+	}
+
+	return result, nil
+}
+
+// applyContentOverrides tries to save content overrides returned by the server
+// and to regenerate redhat.repo
+func (rhsmClient *RHSMClient) applyContentOverrides(contentOverrides []ContentOverride) error {
+	err := rhsmClient.saveContentOverrides(contentOverrides)
+	if err != nil {
+		rhsmClient.getLogger().Warn().Msgf("unable to write content overrides to repo file: %s", err)
+	}
+
+	_, err = rhsmClient.generateRepoFileFromInstalledEntitlementCerts()
+	if err != nil {
+		return fmt.Errorf("unable to write repo file: %s: %s",
+			rhsmClient.RHSMConf.yumRepoFilePath, err)
+	}
+
+	return nil
+}
+
+// setRepoOverrides tries to set content overrides with given name and value
+// for all repositories matching given patterns
+func (rhsmClient *RHSMClient) setRepoOverrides(
+	ctx context.Context,
+	repoPatterns []string,
+	overrides map[string]string,
+	metadata *RequestMetadata,
+) ([]string, error) {
+	if len(repoPatterns) == 0 {
+		return nil, fmt.Errorf("no repository specified")
+	}
+	if len(overrides) == 0 {
+		return nil, fmt.Errorf("no content override specified")
+	}
+
+	availableRepoLabels, err := rhsmClient.getAvailableRepoLabels()
+	if err != nil {
+		return nil, err
+	}
+
+	repoLabels, err := matchRepoLabels(repoPatterns, availableRepoLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		if isProtectedRepoKey(name) {
+			return nil, fmt.Errorf("unable to override protected key: %s", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var contentOverrides []contentOverrideData
+	for _, repoLabel := range repoLabels {
+		for _, name := range names {
+			contentOverrides = append(contentOverrides, contentOverrideData{
+				ContentLabel: repoLabel,
+				Name:         name,
+				Value:        overrides[name],
+			})
+		}
+	}
+
+	metadata = sanitizeMetadata(metadata)
+
+	result, err := rhsmClient.changeContentOverrides(ctx, http.MethodPut, contentOverrides, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rhsmClient.applyContentOverrides(result)
+	if err != nil {
+		return repoLabels, err
+	}
+
+	return repoLabels, nil
+}
+
+// EnableRepos tries to enable repositories matching given patterns. Patterns can
+// contain wildcards (e.g. "rhel-9-*"). Repositories are enabled using content overrides
+// on the candlepin server. Then redhat.repo and dnf5 repo override file are regenerated.
+// The list of labels of enabled repositories is returned.
+func (rhsmClient *RHSMClient) EnableRepos(repoPatterns []string, metadata *RequestMetadata) ([]string, error) {
+	return rhsmClient.EnableReposWithContext(context.Background(), repoPatterns, metadata)
+}
+
+// EnableReposWithContext is like EnableRepos, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) EnableReposWithContext(
+	ctx context.Context,
+	repoPatterns []string,
+	metadata *RequestMetadata,
+) ([]string, error) {
+	return rhsmClient.setRepoOverrides(ctx, repoPatterns, map[string]string{"enabled": "1"}, metadata)
+}
+
+// DisableRepos tries to disable repositories matching given patterns. Patterns can
+// contain wildcards (e.g. "rhel-9-*"). Repositories are disabled using content overrides
+// on the candlepin server. Then redhat.repo and dnf5 repo override file are regenerated.
+// The list of labels of disabled repositories is returned.
+func (rhsmClient *RHSMClient) DisableRepos(repoPatterns []string, metadata *RequestMetadata) ([]string, error) {
+	return rhsmClient.DisableReposWithContext(context.Background(), repoPatterns, metadata)
+}
+
+// DisableReposWithContext is like DisableRepos, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) DisableReposWithContext(
+	ctx context.Context,
+	repoPatterns []string,
+	metadata *RequestMetadata,
+) ([]string, error) {
+	return rhsmClient.setRepoOverrides(ctx, repoPatterns, map[string]string{"enabled": "0"}, metadata)
+}
+
+// SetRepoOverride tries to set content overrides (name: value) for repositories matching
+// given patterns. Patterns can contain wildcards (e.g. "rhel-9-*"). Then redhat.repo
+// and dnf5 repo override file are regenerated. The list of labels of modified
+// repositories is returned.
+func (rhsmClient *RHSMClient) SetRepoOverride(
+	repoPatterns []string,
+	overrides map[string]string,
+	metadata *RequestMetadata,
+) ([]string, error) {
+	return rhsmClient.SetRepoOverrideWithContext(context.Background(), repoPatterns, overrides, metadata)
+}
+
+// SetRepoOverrideWithContext is like SetRepoOverride, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) SetRepoOverrideWithContext(
+	ctx context.Context,
+	repoPatterns []string,
+	overrides map[string]string,
+	metadata *RequestMetadata,
+) ([]string, error) {
+	return rhsmClient.setRepoOverrides(ctx, repoPatterns, overrides, metadata)
+}
+
+// RemoveRepoOverride tries to remove content overrides with given names for repositories
+// matching given patterns. When no name is given, then all content overrides of matching
+// repositories are removed. Patterns can contain wildcards (e.g. "rhel-9-*"). Patterns
+// are matched with available repositories and with repositories having some content override.
+// Then redhat.repo and dnf5 repo override file are regenerated. The list of labels
+// of modified repositories is returned.
+func (rhsmClient *RHSMClient) RemoveRepoOverride(
+	repoPatterns []string,
+	names []string,
+	metadata *RequestMetadata,
+) ([]string, error) {
+	return rhsmClient.RemoveRepoOverrideWithContext(context.Background(), repoPatterns, names, metadata)
+}
+
+// RemoveRepoOverrideWithContext is like RemoveRepoOverride, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) RemoveRepoOverrideWithContext(
+	ctx context.Context,
+	repoPatterns []string,
+	names []string,
+	metadata *RequestMetadata,
+) ([]string, error) {
+	if len(repoPatterns) == 0 {
+		return nil, fmt.Errorf("no repository specified")
+	}
+
+	metadata = sanitizeMetadata(metadata)
+
+	repoLabels, err := rhsmClient.getAvailableRepoLabels()
+	if err != nil {
+		return nil, err
+	}
+
+	// It is possible to remove content overrides of repositories, which are not available anymore
+	currentContentOverrides, err := rhsmClient.getContentOverrides(ctx, metadata)
+	if err != nil {
+		return nil, err
+	}
+	for _, contentOverride := range currentContentOverrides {
+		repoLabels = append(repoLabels, contentOverride.ContentLabel)
+	}
+
+	matchedRepoLabels, err := matchRepoLabels(repoPatterns, repoLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	var contentOverrides []contentOverrideData
+	for _, repoLabel := range matchedRepoLabels {
+		if len(names) == 0 {
+			contentOverrides = append(contentOverrides, contentOverrideData{ContentLabel: repoLabel})
+			continue
+		}
+		for _, name := range names {
+			contentOverrides = append(contentOverrides, contentOverrideData{
+				ContentLabel: repoLabel,
+				Name:         name,
+			})
+		}
+	}
+
+	result, err := rhsmClient.changeContentOverrides(ctx, http.MethodDelete, contentOverrides, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rhsmClient.applyContentOverrides(result)
+	if err != nil {
+		return matchedRepoLabels, err
+	}
+
+	return matchedRepoLabels, nil
+}
+
+// RepoEnabledSource is the source of the effective enabled value of repository
+type RepoEnabledSource string
+
+const (
+	// RepoEnabledSourceCert means that the enabled value is the default value
+	// from entitlement certificate
+	RepoEnabledSourceCert RepoEnabledSource = "cert"
+	// RepoEnabledSourceOverride means that the enabled value is set by content override
+	RepoEnabledSourceOverride RepoEnabledSource = "override"
+	// RepoEnabledSourceLocal means that the enabled value was modified locally in redhat.repo
+	RepoEnabledSourceLocal RepoEnabledSource = "local"
+)
+
+// Repo is structure representing one repository provided by installed
+// entitlement certificates together with its effective state
+type Repo struct {
+	Label string `json:"label"`
+	Name  string `json:"name"`
+	// URL is the URL of repository with resolved variables $releasever and $basearch.
+	// The variable $releasever is resolved only, when the release is set in dnf variables
+	URL           string            `json:"url"`
+	Enabled       bool              `json:"enabled"`
+	EnabledSource RepoEnabledSource `json:"enabledSource"`
+	Arches        []string          `json:"arches"`
+	RequiredTags  []string          `json:"requiredTags"`
+}
+
+// ListReposOptions is structure containing filters used by ListRepos. When
+// no filter is set, then all repositories are listed.
+type ListReposOptions struct {
+	// Enabled lists only enabled repositories
+	Enabled bool
+	// Disabled lists only disabled repositories
+	Disabled bool
+	// MatchingInstalledProducts lists only repositories, whose required tags
+	// are provided by installed products. The same rule is used for generating redhat.repo
+	MatchingInstalledProducts bool
+}
+
+// getCurrentContentOverrides tries to get content overrides received from the server
+// last time without any REST API call. The cache of content overrides is used. When
+// the cache does not exist, then dnf5 repo override file is used.
+func (rhsmClient *RHSMClient) getCurrentContentOverrides() map[string]map[string]string {
+	contentOverrides := rhsmClient.readContentOverridesCache()
+	if contentOverrides != nil {
+		return createMapFromContentOverrides(rhsmClient.filterContentOverrides(contentOverrides))
+	}
+	repoOverrides, err := readContentOverridesFromDnf5RepoOverride(rhsmClient.RHSMConf.dnf5ReposOverrideFilePath)
+	if err != nil {
+		rhsmClient.getLogger().Debug().Msgf("unable to read dnf5 repo override file: %s", err)
+		return make(map[string]map[string]string)
+	}
+	return repoOverrides
+}
+
+// repoEnabledValue returns true, when the value of enabled key means enabled repository
+func repoEnabledValue(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
+
+// resolveRepoURL replaces dnf variables $releasever and $basearch in the URL of
+// repository. When the release is empty, then $releasever is not replaced.
+func resolveRepoURL(repoURL string, release string, arch string) string {
+	if release != "" {
+		repoURL = strings.ReplaceAll(repoURL, "$releasever", release)
+	}
+	return strings.ReplaceAll(repoURL, "$basearch", arch)
+}
+
+// ListRepos tries to list repositories provided by installed entitlement certificates.
+// The enabled state of each repository is computed from default value in entitlement
+// certificate, content overrides and local modifications of redhat.repo. When dnf5
+// is used, then content overrides have the highest priority, because dnf5 reads
+// repo override file after redhat.repo. When dnf4 is used, then local modifications
+// of redhat.repo have the highest priority. Variables $releasever and $basearch are
+// resolved in URLs of repositories using the release from dnf variables and the
+// architecture of the host. Content of other types than yum and content not supported
+// by the architecture of the host is not listed, because it is never written to
+// redhat.repo. The list is sorted by labels and it can be filtered
+// using options. When options are nil, then all repositories are listed.
+func (rhsmClient *RHSMClient) ListRepos(options *ListReposOptions) ([]Repo, error) {
+	if options == nil {
+		options = &ListReposOptions{}
+	}
+
+	engineeringProductsMap, err := rhsmClient.getEngineeringProducts()
+	if err != nil {
+		return nil, err
+	}
+
+	contentOverrides := rhsmClient.getCurrentContentOverrides()
+
+	repoFile, err := readExistingRepoFile(rhsmClient.RHSMConf.yumRepoFilePath)
+	if err != nil {
+		rhsmClient.getLogger().Warn().Msgf("%s, ignoring local state of repositories", err)
+		repoFile = ini.Empty()
+	}
+
+	dnfVersion := rhsmClient.getDnfVersion()
+
+	release, err := rhsmClient.GetDnfVarsRelease()
+	if err != nil {
+		rhsmClient.getLogger().Warn().Msgf("%s, $releasever is not resolved in URLs of repositories", err)
+	}
+	release = strings.TrimSpace(release)
+	hostArch := hostArchitecture()
+
+	var providedTags []string
+	if options.MatchingInstalledProducts {
+		providedTags = createListOfContentTags(rhsmClient.getInstalledProducts())
+	}
+
+	// Serials are sorted to get the same result, when more certificates provide the same repository
+	serials := make([]int64, 0, len(engineeringProductsMap))
+	for serial := range engineeringProductsMap {
+		serials = append(serials, serial)
+	}
+	slices.Sort(serials)
+
+	repos := make([]Repo, 0)
+	listedLabels := make(map[string]struct{})
+	for _, serial := range serials {
+		for _, engineeringProduct := range engineeringProductsMap[serial] {
+			for _, content := range engineeringProduct.Content {
+				if !isUsableRepoContent(&content, hostArch) {
+					continue
+				}
+				if _, exists := listedLabels[content.Label]; exists {
+					continue
+				}
+				listedLabels[content.Label] = struct{}{}
+
+				repoURL, err := rhsmClient.contentBaseURL(&content)
+				if err != nil {
+					return nil, err
+				}
+
+				repo := Repo{
+					Label:         content.Label,
+					Name:          content.Name,
+					URL:           resolveRepoURL(repoURL, release, hostArch),
+					Enabled:       content.Enabled == nil || *content.Enabled,
+					EnabledSource: RepoEnabledSourceCert,
+					Arches:        content.Arches,
+					RequiredTags:  content.RequiredTags,
+				}
+
+				overrideValue, overridden := contentOverrides[content.Label]["enabled"]
+				applyOverride := func() {
+					if overridden {
+						repo.Enabled = repoEnabledValue(overrideValue)
+						repo.EnabledSource = RepoEnabledSourceOverride
+					}
+				}
+
+				if dnfVersion != DnfVersion5 {
+					applyOverride()
+				}
+				if repoFile.HasSection(content.Label) && repoFile.Section(content.Label).HasKey("enabled") {
+					localEnabled := repoEnabledValue(repoFile.Section(content.Label).Key("enabled").Value())
+					if localEnabled != repo.Enabled {
+						repo.Enabled = localEnabled
+						repo.EnabledSource = RepoEnabledSourceLocal
+					}
+				}
+				if dnfVersion == DnfVersion5 {
+					applyOverride()
+				}
+
+				if options.Enabled && !options.Disabled && !repo.Enabled {
+					continue
+				}
+				if options.Disabled && !options.Enabled && repo.Enabled {
+					continue
+				}
+				if options.MatchingInstalledProducts && !isAnyRequiredTagProvided(rhsmClient.getLogger(), repo.RequiredTags, providedTags) {
+					continue
+				}
+
+				repos = append(repos, repo)
+			}
+		}
+	}
+
+	sort.Slice(repos, func(i, j int) bool {
+		return repos[i].Label < repos[j].Label
+	})
+
+	return repos, nil
+}
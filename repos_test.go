@@ -0,0 +1,598 @@
+package rhsm2
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/ini.v1"
+)
+
+// TestMatchRepoLabels test matching of repository labels with patterns
+func TestMatchRepoLabels(t *testing.T) {
+	t.Parallel()
+	repoLabels := []string{
+		"rhel-9-for-x86_64-baseos-rpms",
+		"rhel-9-for-x86_64-appstream-rpms",
+		"rhel-9-for-x86_64-baseos-debug-rpms",
+		"rhel-10-for-x86_64-baseos-rpms",
+	}
+	tests := []struct {
+		name     string
+		patterns []string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "exact label",
+			patterns: []string{"rhel-9-for-x86_64-baseos-rpms"},
+			expected: []string{"rhel-9-for-x86_64-baseos-rpms"},
+		},
+		{
+			name:     "wildcard",
+			patterns: []string{"rhel-9-*"},
+			expected: []string{
+				"rhel-9-for-x86_64-appstream-rpms",
+				"rhel-9-for-x86_64-baseos-debug-rpms",
+				"rhel-9-for-x86_64-baseos-rpms",
+			},
+		},
+		{
+			name:     "overlapping patterns",
+			patterns: []string{"*-baseos-rpms", "rhel-10-*"},
+			expected: []string{
+				"rhel-10-for-x86_64-baseos-rpms",
+				"rhel-9-for-x86_64-baseos-rpms",
+			},
+		},
+		{
+			name:     "pattern not matching any repository",
+			patterns: []string{"rhel-9-*", "rhel-8-*"},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid pattern",
+			patterns: []string{"rhel-9-["},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			result, err := matchRepoLabels(tt.patterns, repoLabels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("matchRepoLabels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected labels: %v, got: %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+// testingContentOverridesServer is testing server storing content overrides
+// in memory. It supports GET, PUT and DELETE of content overrides.
+type testingContentOverridesServer struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	overrides []ContentOverride
+	requests  map[string][]contentOverrideData
+}
+
+// newTestingContentOverridesServer creates testing server with given content overrides
+func newTestingContentOverridesServer(t *testing.T, overrides []ContentOverride) *testingContentOverridesServer {
+	overridesServer := &testingContentOverridesServer{
+		overrides: overrides,
+		requests:  make(map[string][]contentOverrideData),
+	}
+	overridesServer.Server = httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			expectedURL := "/consumers/5e9745d5-624d-4af1-916e-2c17df4eb4e8/content_overrides"
+			if req.URL.String() != expectedURL {
+				t.Errorf("expected request URL: %s, got: %s", expectedURL, req.URL.String())
+				rw.WriteHeader(404)
+				return
+			}
+
+			overridesServer.mutex.Lock()
+			defer overridesServer.mutex.Unlock()
+
+			if req.Method != http.MethodGet {
+				body, _ := io.ReadAll(req.Body)
+				var data []contentOverrideData
+				err := json.Unmarshal(body, &data)
+				if err != nil {
+					t.Errorf("unable to parse request body: %s", err)
+				}
+				overridesServer.requests[req.Method] = data
+			}
+
+			switch req.Method {
+			case http.MethodGet:
+			case http.MethodPut:
+				for _, data := range overridesServer.requests[req.Method] {
+					overridesServer.overrides = append(overridesServer.overrides, ContentOverride{
+						ContentLabel: data.ContentLabel,
+						Name:         data.Name,
+						Value:        data.Value,
+					})
+				}
+			case http.MethodDelete:
+				var remaining []ContentOverride
+				for _, override := range overridesServer.overrides {
+					removed := false
+					for _, data := range overridesServer.requests[req.Method] {
+						if data.ContentLabel == override.ContentLabel && (data.Name == "" || data.Name == override.Name) {
+							removed = true
+						}
+					}
+					if !removed {
+						remaining = append(remaining, override)
+					}
+				}
+				overridesServer.overrides = remaining
+			default:
+				t.Errorf("unexpected request method: %s", req.Method)
+			}
+
+			result := overridesServer.overrides
+			if result == nil {
+				result = []ContentOverride{}
+			}
+			body, _ := json.Marshal(result)
+			rw.WriteHeader(200)
+			_, _ = rw.Write(body)
+		}))
+	return overridesServer
+}
+
+// TestEnableDisableRepos test enabling and disabling of repositories using wildcards
+func TestEnableDisableRepos(t *testing.T) {
+	t.Parallel()
+	server := newTestingContentOverridesServer(t, nil)
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, false, true, true, true, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server.Server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	enabledRepos, err := rhsmClient.EnableRepos([]string{"awesomeos-ppc64-*"}, nil)
+	if err != nil {
+		t.Fatalf("unable to enable repositories: %s", err)
+	}
+	expectedRepos := []string{
+		"awesomeos-ppc64-100000000000003",
+		"awesomeos-ppc64-100000000000011",
+		"awesomeos-ppc64-801",
+		"awesomeos-ppc64-99000",
+	}
+	if !reflect.DeepEqual(enabledRepos, expectedRepos) {
+		t.Fatalf("expected enabled repositories: %v, got: %v", expectedRepos, enabledRepos)
+	}
+
+	expectedRequest := []contentOverrideData{}
+	for _, repoLabel := range expectedRepos {
+		expectedRequest = append(expectedRequest, contentOverrideData{
+			ContentLabel: repoLabel,
+			Name:         "enabled",
+			Value:        "1",
+		})
+	}
+	if !reflect.DeepEqual(server.requests[http.MethodPut], expectedRequest) {
+		t.Fatalf("expected request: %v, got: %v", expectedRequest, server.requests[http.MethodPut])
+	}
+
+	// The dnf5 repo override file and redhat.repo have to be generated
+	repoOverrides, err := readContentOverridesFromDnf5RepoOverride(testingFiles.Dnf5OverrideFilePath)
+	if err != nil {
+		t.Fatalf("unable to read dnf5 repo override file: %s", err)
+	}
+	for _, repoLabel := range expectedRepos {
+		if repoOverrides[repoLabel]["enabled"] != "1" {
+			t.Errorf("repository %s not enabled in dnf5 repo override file", repoLabel)
+		}
+	}
+	info, err := os.Stat(testingFiles.YumRepoFilePath)
+	if err != nil || info.Size() == 0 {
+		t.Errorf("repo file %s was not generated", testingFiles.YumRepoFilePath)
+	}
+
+	disabledRepos, err := rhsmClient.DisableRepos([]string{"awesomeos-801"}, nil)
+	if err != nil {
+		t.Fatalf("unable to disable repositories: %s", err)
+	}
+	if !reflect.DeepEqual(disabledRepos, []string{"awesomeos-801"}) {
+		t.Fatalf("expected disabled repositories: %v, got: %v", []string{"awesomeos-801"}, disabledRepos)
+	}
+	repoOverrides, err = readContentOverridesFromDnf5RepoOverride(testingFiles.Dnf5OverrideFilePath)
+	if err != nil {
+		t.Fatalf("unable to read dnf5 repo override file: %s", err)
+	}
+	if repoOverrides["awesomeos-801"]["enabled"] != "0" {
+		t.Errorf("repository awesomeos-801 not disabled in dnf5 repo override file")
+	}
+}
+
+// TestEnableReposNotMatching test that no request is sent to the server,
+// when some pattern does not match any available repository
+func TestEnableReposNotMatching(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			t.Errorf("unexpected REST API call: %s %s", req.Method, req.URL.String())
+		}))
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, false, true, true, true, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	_, err = rhsmClient.EnableRepos([]string{"awesomeos-801", "rhel-9-*"}, nil)
+	if err == nil {
+		t.Fatalf("enabling of not existing repository did not fail")
+	}
+
+	// Content of other types than yum is never written to redhat.repo
+	_, err = rhsmClient.EnableRepos([]string{"awesomeos-deb-38070"}, nil)
+	if err == nil {
+		t.Fatalf("enabling of repository with other type than yum did not fail")
+	}
+}
+
+// TestSetRemoveRepoOverride test setting and removing of content overrides
+func TestSetRemoveRepoOverride(t *testing.T) {
+	t.Parallel()
+	// Content override of repository, which is not available anymore
+	server := newTestingContentOverridesServer(t, []ContentOverride{
+		{ContentLabel: "old-repo", Name: "enabled", Value: "1"},
+	})
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, false, true, true, true, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server.Server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	// Repositories for x86_64 are available only on compatible hosts
+	if !isArchSupported([]string{"x86_64"}, hostArchitecture()) {
+		t.Skipf("repositories for x86_64 are not available on %s", hostArchitecture())
+	}
+
+	repos, err := rhsmClient.SetRepoOverride(
+		[]string{"awesomeos-x86_64-only-*"},
+		map[string]string{"priority": "10", "module_hotfixes": "1"},
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("unable to set content overrides: %s", err)
+	}
+	expectedRepos := []string{
+		"awesomeos-x86_64-only-content-213412341235",
+		"awesomeos-x86_64-only-content-213412341236",
+	}
+	if !reflect.DeepEqual(repos, expectedRepos) {
+		t.Fatalf("expected repositories: %v, got: %v", expectedRepos, repos)
+	}
+	if len(server.requests[http.MethodPut]) != 4 {
+		t.Fatalf("expected 4 content overrides in request, got: %v", server.requests[http.MethodPut])
+	}
+
+	// Remove only priority
+	_, err = rhsmClient.RemoveRepoOverride([]string{"awesomeos-x86_64-only-*"}, []string{"priority"}, nil)
+	if err != nil {
+		t.Fatalf("unable to remove content overrides: %s", err)
+	}
+	repoOverrides, err := readContentOverridesFromDnf5RepoOverride(testingFiles.Dnf5OverrideFilePath)
+	if err != nil {
+		t.Fatalf("unable to read dnf5 repo override file: %s", err)
+	}
+	for _, repoLabel := range expectedRepos {
+		if _, exists := repoOverrides[repoLabel]["priority"]; exists {
+			t.Errorf("priority of %s was not removed", repoLabel)
+		}
+		if repoOverrides[repoLabel]["module_hotfixes"] != "1" {
+			t.Errorf("module_hotfixes of %s was removed", repoLabel)
+		}
+	}
+
+	// Remove all content overrides including overrides of not available repository
+	repos, err = rhsmClient.RemoveRepoOverride([]string{"*"}, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to remove content overrides: %s", err)
+	}
+	if !slices.Contains(repos, "old-repo") {
+		t.Fatalf("content overrides of not available repository were not removed: %v", repos)
+	}
+	if _, err := os.Stat(testingFiles.Dnf5OverrideFilePath); !os.IsNotExist(err) {
+		t.Errorf("dnf5 repo override file without any content override was not removed")
+	}
+}
+
+// TestEnableReposDnf4 test that content overrides are written directly
+// to redhat.repo, when dnf4 is used and protected keys cannot be overridden
+func TestEnableReposDnf4(t *testing.T) {
+	t.Parallel()
+	// Content override of protected key set on the server
+	server := newTestingContentOverridesServer(t, []ContentOverride{
+		{ContentLabel: "awesomeos-801", Name: "baseurl", Value: "https://evil.example.com/"},
+	})
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, false, true, true, true, true)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server.Server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+	rhsmClient.SetDnfVersion(DnfVersion4)
+
+	_, err = rhsmClient.SetRepoOverride([]string{"awesomeos-801"}, map[string]string{"sslclientkey": "/tmp/key.pem"}, nil)
+	if err == nil {
+		t.Fatalf("overriding of protected key did not fail")
+	}
+
+	_, err = rhsmClient.EnableRepos([]string{"awesomeos-801", "awesomeos-ppc64-*"}, nil)
+	if err != nil {
+		t.Fatalf("unable to enable repositories: %s", err)
+	}
+	_, err = rhsmClient.SetRepoOverride([]string{"awesomeos-801"}, map[string]string{"priority": "10"}, nil)
+	if err != nil {
+		t.Fatalf("unable to set content override: %s", err)
+	}
+
+	if _, err := os.Stat(testingFiles.Dnf5OverrideFilePath); !os.IsNotExist(err) {
+		t.Errorf("dnf5 repo override file was written for dnf4")
+	}
+
+	repoFile, err := ini.Load(testingFiles.YumRepoFilePath)
+	if err != nil {
+		t.Fatalf("unable to load repo file: %s", err)
+	}
+	for _, repoLabel := range []string{"awesomeos-801", "awesomeos-ppc64-801"} {
+		if repoFile.Section(repoLabel).Key("enabled").Value() != "1" {
+			t.Errorf("repository %s not enabled in repo file", repoLabel)
+		}
+	}
+	section := repoFile.Section("awesomeos-801")
+	if section.Key("priority").Value() != "10" {
+		t.Errorf("content override priority not written to repo file")
+	}
+	if strings.Contains(section.Key("baseurl").Value(), "evil.example.com") {
+		t.Errorf("protected key baseurl was overridden: %s", section.Key("baseurl").Value())
+	}
+
+	// Content overrides are kept, when repo file is regenerated from installed certificates
+	_, err = rhsmClient.generateRepoFileFromInstalledEntitlementCerts()
+	if err != nil {
+		t.Fatalf("unable to generate repo file: %s", err)
+	}
+	repoFile, err = ini.Load(testingFiles.YumRepoFilePath)
+	if err != nil {
+		t.Fatalf("unable to load repo file: %s", err)
+	}
+	if repoFile.Section("awesomeos-801").Key("priority").Value() != "10" {
+		t.Errorf("content override priority was lost during regeneration of repo file")
+	}
+}
+
+// TestListRepos test listing of repositories with effective enabled state
+func TestListRepos(t *testing.T) {
+	t.Parallel()
+
+	// setupRHSMClient creates testing rhsm client with generated redhat.repo,
+	// content overrides and local changes of redhat.repo
+	setupRHSMClient := func(t *testing.T, dnfVersion DnfVersion) *RHSMClient {
+		testingFiles, err := setupTestingFileSystem(
+			t.TempDir(), false, true, true, true, true)
+		if err != nil {
+			t.Fatalf("unable to setup testing environment: %s", err)
+		}
+
+		rhsmClient, err := setupTestingRHSMClient(testingFiles, nil, nil)
+		if err != nil {
+			t.Fatalf("unable to setup testing rhsm client: %s", err)
+		}
+		rhsmClient.RHSMConf.RHSM.BaseURL = "https://cdn.example.com"
+		rhsmClient.SetDnfVersion(dnfVersion)
+
+		err = rhsmClient.saveContentOverrides([]ContentOverride{
+			{ContentLabel: "awesomeos-801", Name: "enabled", Value: "1"},
+			{ContentLabel: "awesome-release-content-38091", Name: "enabled", Value: "0"},
+		})
+		if err != nil {
+			t.Fatalf("unable to save content overrides: %s", err)
+		}
+		_, err = rhsmClient.generateRepoFileFromInstalledEntitlementCerts()
+		if err != nil {
+			t.Fatalf("unable to generate repo file: %s", err)
+		}
+
+		// Simulate changes done by user
+		repoFile, err := ini.Load(testingFiles.YumRepoFilePath)
+		if err != nil {
+			t.Fatalf("unable to load repo file: %s", err)
+		}
+		repoFile.Section("tagged-content-32060").Key("enabled").SetValue("1")
+		repoFile.Section("awesome-release-content-38091").Key("enabled").SetValue("1")
+		err = repoFile.SaveTo(testingFiles.YumRepoFilePath)
+		if err != nil {
+			t.Fatalf("unable to save repo file: %s", err)
+		}
+
+		return rhsmClient
+	}
+
+	tests := []struct {
+		name           string
+		dnfVersion     DnfVersion
+		expectedStates map[string]Repo
+	}{
+		{
+			name:       "dnf5",
+			dnfVersion: DnfVersion5,
+			expectedStates: map[string]Repo{
+				"release-content-38090":         {Enabled: true, EnabledSource: RepoEnabledSourceCert},
+				"awesomeos-ppc64-801":           {Enabled: false, EnabledSource: RepoEnabledSourceCert},
+				"awesomeos-801":                 {Enabled: true, EnabledSource: RepoEnabledSourceOverride},
+				"tagged-content-32060":          {Enabled: true, EnabledSource: RepoEnabledSourceLocal},
+				"awesome-release-content-38091": {Enabled: false, EnabledSource: RepoEnabledSourceOverride},
+			},
+		},
+		{
+			name:       "dnf4",
+			dnfVersion: DnfVersion4,
+			expectedStates: map[string]Repo{
+				"release-content-38090":         {Enabled: true, EnabledSource: RepoEnabledSourceCert},
+				"awesomeos-ppc64-801":           {Enabled: false, EnabledSource: RepoEnabledSourceCert},
+				"awesomeos-801":                 {Enabled: true, EnabledSource: RepoEnabledSourceOverride},
+				"tagged-content-32060":          {Enabled: true, EnabledSource: RepoEnabledSourceLocal},
+				"awesome-release-content-38091": {Enabled: true, EnabledSource: RepoEnabledSourceLocal},
+			},
+		},
+	}
+
+	// Content of other types than yum is never listed and content for other
+	// architectures is listed only on compatible hosts
+	expectedCount := 91
+	for _, arches := range [][]string{{"x86_64"}, {"x86_64"}, {"x86_64", "i386"}} {
+		if !isArchSupported(arches, hostArchitecture()) {
+			expectedCount--
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			rhsmClient := setupRHSMClient(t, tt.dnfVersion)
+			repos, err := rhsmClient.ListRepos(nil)
+			if err != nil {
+				t.Fatalf("unable to list repositories: %s", err)
+			}
+			if len(repos) != expectedCount {
+				t.Fatalf("expected %d repositories, got: %d", expectedCount, len(repos))
+			}
+			for _, repo := range repos {
+				if slices.Contains([]string{"awesomeos-deb-38070", "awesomeos-docker-images-37090", "awesomeos-ostree-37091"}, repo.Label) {
+					t.Errorf("repository %s of other type than yum listed", repo.Label)
+				}
+				expected, exists := tt.expectedStates[repo.Label]
+				if !exists {
+					continue
+				}
+				if repo.Enabled != expected.Enabled || repo.EnabledSource != expected.EnabledSource {
+					t.Errorf("repository %s expected enabled: %v (%s), got: %v (%s)", repo.Label,
+						expected.Enabled, expected.EnabledSource, repo.Enabled, repo.EnabledSource)
+				}
+			}
+		})
+	}
+
+	rhsmClient := setupRHSMClient(t, DnfVersion5)
+	repos, err := rhsmClient.ListRepos(nil)
+	if err != nil {
+		t.Fatalf("unable to list repositories: %s", err)
+	}
+	if isArchSupported([]string{"x86_64"}, hostArchitecture()) {
+		repo := repos[slices.IndexFunc(repos, func(repo Repo) bool { return repo.Label == "awesomeos-x86_64-only-content-213412341236" })]
+		expectedURL := "https://cdn.example.com/path/to/awesomeos/x86_64_content/213412341236-6401"
+		if repo.URL != expectedURL || !reflect.DeepEqual(repo.Arches, []string{"x86_64"}) {
+			t.Errorf("unexpected repository: %v", repo)
+		}
+	}
+
+	// $releasever is resolved only, when the release is set
+	repo := repos[slices.IndexFunc(repos, func(repo Repo) bool { return repo.Label == "awesome-release-content-38091" })]
+	expectedURL := "https://cdn.example.com/path/to/fake-content/rhel10/$releasever/awesome-release-content/38091-33333"
+	if repo.URL != expectedURL {
+		t.Errorf("expected URL: %s, got: %s", expectedURL, repo.URL)
+	}
+	err = rhsmClient.setDnfVarsRelease("10.1\n")
+	if err != nil {
+		t.Fatalf("unable to set release: %s", err)
+	}
+	repos, err = rhsmClient.ListRepos(nil)
+	if err != nil {
+		t.Fatalf("unable to list repositories: %s", err)
+	}
+	repo = repos[slices.IndexFunc(repos, func(repo Repo) bool { return repo.Label == "awesome-release-content-38091" })]
+	expectedURL = "https://cdn.example.com/path/to/fake-content/rhel10/10.1/awesome-release-content/38091-33333"
+	if repo.URL != expectedURL {
+		t.Errorf("expected URL: %s, got: %s", expectedURL, repo.URL)
+	}
+	if got := resolveRepoURL("https://cdn.example.com/$releasever/$basearch/os", "10", "aarch64"); got != "https://cdn.example.com/10/aarch64/os" {
+		t.Errorf("unexpected resolved URL: %s", got)
+	}
+
+	enabledRepos, err := rhsmClient.ListRepos(&ListReposOptions{Enabled: true})
+	if err != nil {
+		t.Fatalf("unable to list enabled repositories: %s", err)
+	}
+	disabledRepos, err := rhsmClient.ListRepos(&ListReposOptions{Disabled: true})
+	if err != nil {
+		t.Fatalf("unable to list disabled repositories: %s", err)
+	}
+	if len(enabledRepos)+len(disabledRepos) != len(repos) {
+		t.Errorf("enabled (%d) and disabled (%d) repositories do not cover all repositories (%d)",
+			len(enabledRepos), len(disabledRepos), len(repos))
+	}
+	for _, repo := range enabledRepos {
+		if !repo.Enabled {
+			t.Errorf("disabled repository %s listed as enabled", repo.Label)
+		}
+	}
+	for _, repo := range disabledRepos {
+		if repo.Enabled {
+			t.Errorf("enabled repository %s listed as disabled", repo.Label)
+		}
+	}
+
+	// Installed products do not provide TAG1 and TAG2
+	matchingRepos, err := rhsmClient.ListRepos(&ListReposOptions{MatchingInstalledProducts: true})
+	if err != nil {
+		t.Fatalf("unable to list repositories matching installed products: %s", err)
+	}
+	if len(matchingRepos) != expectedCount-2 {
+		t.Errorf("expected %d repositories matching installed products, got: %d", expectedCount-2, len(matchingRepos))
+	}
+	for _, repo := range matchingRepos {
+		if slices.Contains(repo.RequiredTags, "TAG1") {
+			t.Errorf("repository %s not matching installed products listed", repo.Label)
+		}
+	}
+}
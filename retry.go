@@ -0,0 +1,187 @@
+package rhsm2
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default values of retry policy used by connections to candlepin server and CDN
+const (
+	DefaultRetryMaxAttempts    = 3
+	DefaultRetryInitialBackoff = 1 * time.Second
+	DefaultRetryMaxBackoff     = 30 * time.Second
+)
+
+// RetryPolicy is the policy used for retrying failed HTTP requests. Only requests
+// using idempotent HTTP methods (GET, HEAD, OPTIONS, PUT and DELETE) are retried.
+// POST requests are retried only, when the context is marked using WithRetryablePost.
+// A request is retried, when it is not possible to get any response from the server,
+// or the server responds with one of RetryStatusCodes.
+type RetryPolicy struct {
+	// MaxAttempts is the maximal number of attempts including the first one.
+	// Value lower than 2 disables retrying.
+	MaxAttempts int
+	// InitialBackoff is the delay before the second attempt. The delay is doubled
+	// for every next attempt. Random jitter is added to the delay.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximal delay between two attempts. When the server
+	// asks for longer delay in Retry-After header, then MaxBackoff is used.
+	MaxBackoff time.Duration
+	// RetryStatusCodes is the list of HTTP status codes of responses that are retried
+	RetryStatusCodes []int
+}
+
+// DefaultRetryPolicy returns the default retry policy. Requests are retried,
+// when candlepin server is overloaded or temporarily unavailable. Retrying is
+// not enabled by default. It has to be enabled using SetRetryPolicy.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    DefaultRetryMaxAttempts,
+		InitialBackoff: DefaultRetryInitialBackoff,
+		MaxBackoff:     DefaultRetryMaxBackoff,
+		RetryStatusCodes: []int{
+			http.StatusTooManyRequests,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// SetRetryPolicy sets the retry policy used by all connections of RHSMClient.
+// Existing connections are replaced with copies using the new retry policy.
+// Thus, requests already in progress are not affected.
+func (rhsmClient *RHSMClient) SetRetryPolicy(retryPolicy RetryPolicy) {
+	rhsmClient.connectionMutex.Lock()
+	defer rhsmClient.connectionMutex.Unlock()
+
+	rhsmClient.RHSMConf.retryPolicy = &retryPolicy
+	for _, connection := range []**RHSMConnection{
+		&rhsmClient.noAuthConnection,
+		&rhsmClient.consumerCertAuthConnection,
+		&rhsmClient.entitlementCertAuthConnection,
+	} {
+		if *connection != nil {
+			newConnection := **connection
+			newConnection.RetryPolicy = retryPolicy
+			*connection = &newConnection
+		}
+	}
+}
+
+// getRetryPolicy returns the current retry policy. When no retry policy was
+// set, then zero value disabling retrying is returned. It has to be called
+// with locked connectionMutex, because the retry policy could be changed
+// concurrently by SetRetryPolicy
+func (rhsmClient *RHSMClient) getRetryPolicy() RetryPolicy {
+	if rhsmClient.RHSMConf.retryPolicy == nil {
+		return RetryPolicy{}
+	}
+	return *rhsmClient.RHSMConf.retryPolicy
+}
+
+// retryablePostKey is the key of context value marking POST requests as retryable
+type retryablePostKey struct{}
+
+// WithRetryablePost returns copy of the context, which marks POST requests
+// as safe to retry. It should be used only for POST requests, which do not
+// have any side effect, when they are called more than once.
+func WithRetryablePost(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryablePostKey{}, true)
+}
+
+// isRetryableRequest returns true, when the request with given method
+// can be retried
+func isRetryableRequest(ctx context.Context, method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	case http.MethodPost:
+		retryable, _ := ctx.Value(retryablePostKey{}).(bool)
+		return retryable
+	default:
+		return false
+	}
+}
+
+// isRetryStatusCode returns true, when the response with given status code should be retried
+func (retryPolicy *RetryPolicy) isRetryStatusCode(statusCode int) bool {
+	for _, retryStatusCode := range retryPolicy.RetryStatusCodes {
+		if statusCode == retryStatusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns jittered exponential delay before the next attempt. The
+// attempt is the number of already finished attempts. The delay is between
+// one half and the whole exponential backoff.
+func (retryPolicy *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := retryPolicy.InitialBackoff
+	for i := 1; i < attempt && delay < retryPolicy.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if retryPolicy.MaxBackoff > 0 && delay > retryPolicy.MaxBackoff {
+		delay = retryPolicy.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// parseRetryAfter tries to parse value of Retry-After HTTP header. The value
+// can be the number of seconds or HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// retryDelay returns delay before the next attempt and true, when the request
+// should be retried. The attempt is the number of already finished attempts.
+// When the server responded with Retry-After header, then the delay requested
+// by the server is used, but it is never longer than MaxBackoff.
+func (retryPolicy *RetryPolicy) retryDelay(attempt int, res *http.Response, err error) (time.Duration, bool) {
+	if attempt >= retryPolicy.MaxAttempts {
+		return 0, false
+	}
+
+	if err != nil {
+		return retryPolicy.backoff(attempt), true
+	}
+
+	if !retryPolicy.isRetryStatusCode(res.StatusCode) {
+		return 0, false
+	}
+
+	if delay, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+		if retryPolicy.MaxBackoff > 0 && delay > retryPolicy.MaxBackoff {
+			delay = retryPolicy.MaxBackoff
+		}
+		return delay, true
+	}
+
+	return retryPolicy.backoff(attempt), true
+}
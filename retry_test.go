@@ -0,0 +1,406 @@
+package rhsm2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testingRetryPolicy is retry policy with short delays used in unit tests
+func testingRetryPolicy(maxAttempts int) RetryPolicy {
+	retryPolicy := DefaultRetryPolicy()
+	retryPolicy.MaxAttempts = maxAttempts
+	retryPolicy.InitialBackoff = time.Millisecond
+	retryPolicy.MaxBackoff = 10 * time.Millisecond
+	return retryPolicy
+}
+
+// TestParseRetryAfter test parsing of Retry-After HTTP header
+func TestParseRetryAfter(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 10, 6, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		value         string
+		expectedDelay time.Duration
+		expectedOk    bool
+	}{
+		{
+			name:          "number of seconds",
+			value:         "120",
+			expectedDelay: 120 * time.Second,
+			expectedOk:    true,
+		},
+		{
+			name:          "zero seconds",
+			value:         "0",
+			expectedDelay: 0,
+			expectedOk:    true,
+		},
+		{
+			name:          "HTTP date",
+			value:         "Sun, 06 Oct 2024 09:00:30 GMT",
+			expectedDelay: 30 * time.Second,
+			expectedOk:    true,
+		},
+		{
+			name:          "HTTP date in the past",
+			value:         "Sun, 06 Oct 2024 08:00:00 GMT",
+			expectedDelay: 0,
+			expectedOk:    true,
+		},
+		{
+			name:       "empty value",
+			value:      "",
+			expectedOk: false,
+		},
+		{
+			name:       "negative number",
+			value:      "-5",
+			expectedOk: false,
+		},
+		{
+			name:       "invalid value",
+			value:      "tomorrow",
+			expectedOk: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			delay, ok := parseRetryAfter(tt.value, now)
+			if ok != tt.expectedOk {
+				t.Fatalf("expected ok: %v, got: %v", tt.expectedOk, ok)
+			}
+			if delay != tt.expectedDelay {
+				t.Errorf("expected delay: %v, got: %v", tt.expectedDelay, delay)
+			}
+		})
+	}
+}
+
+// TestRetryPolicyBackoff test that jittered exponential backoff is
+// within expected limits
+func TestRetryPolicyBackoff(t *testing.T) {
+	t.Parallel()
+	retryPolicy := RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	}
+	tests := []struct {
+		attempt     int
+		expectedMax time.Duration
+	}{
+		{attempt: 1, expectedMax: 100 * time.Millisecond},
+		{attempt: 2, expectedMax: 200 * time.Millisecond},
+		{attempt: 3, expectedMax: 400 * time.Millisecond},
+		{attempt: 4, expectedMax: 800 * time.Millisecond},
+		{attempt: 5, expectedMax: time.Second},
+		{attempt: 100, expectedMax: time.Second},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			delay := retryPolicy.backoff(tt.attempt)
+			if delay < tt.expectedMax/2 || delay > tt.expectedMax {
+				t.Fatalf("attempt %d: delay %v is not in range <%v, %v>",
+					tt.attempt, delay, tt.expectedMax/2, tt.expectedMax)
+			}
+		}
+	}
+}
+
+// TestIsRetryableRequest test which requests can be retried
+func TestIsRetryableRequest(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		method   string
+		expected bool
+	}{
+		{"GET", context.Background(), http.MethodGet, true},
+		{"PUT", context.Background(), http.MethodPut, true},
+		{"DELETE", context.Background(), http.MethodDelete, true},
+		{"POST", context.Background(), http.MethodPost, false},
+		{"marked POST", WithRetryablePost(context.Background()), http.MethodPost, true},
+		{"PATCH", context.Background(), http.MethodPatch, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if isRetryableRequest(tt.ctx, tt.method) != tt.expected {
+				t.Errorf("expected retryable: %v for %s", tt.expected, tt.method)
+			}
+		})
+	}
+}
+
+// testingRetryServer is testing server returning responses with given
+// status codes and Retry-After headers in the order. When all responses
+// are used, then it returns status of server. HTTP headers of all
+// requests are saved.
+type testingRetryServer struct {
+	*httptest.Server
+	mutex      sync.Mutex
+	headers    []http.Header
+	statuses   []int
+	retryAfter string
+}
+
+// newTestingRetryServer creates testing server returning given status codes
+func newTestingRetryServer(statuses []int, retryAfter string) *testingRetryServer {
+	retryServer := &testingRetryServer{statuses: statuses, retryAfter: retryAfter}
+	retryServer.Server = httptest.NewTLSServer(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			retryServer.mutex.Lock()
+			attempt := len(retryServer.headers)
+			retryServer.headers = append(retryServer.headers, req.Header.Clone())
+			retryServer.mutex.Unlock()
+
+			if attempt < len(retryServer.statuses) {
+				if retryServer.retryAfter != "" {
+					rw.Header().Set("Retry-After", retryServer.retryAfter)
+				}
+				rw.WriteHeader(retryServer.statuses[attempt])
+				return
+			}
+			rw.WriteHeader(200)
+			_, _ = rw.Write([]byte(serverStatusResponse))
+		}))
+	return retryServer
+}
+
+// attempts returns number of requests received by testing server
+func (retryServer *testingRetryServer) attempts() int {
+	retryServer.mutex.Lock()
+	defer retryServer.mutex.Unlock()
+	return len(retryServer.headers)
+}
+
+// TestRequestRetry test that failed requests are retried according to retry policy
+func TestRequestRetry(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name               string
+		method             string
+		markPost           bool
+		maxAttempts        int
+		statuses           []int
+		retryAfter         string
+		expectedAttempts   int
+		expectedStatusCode int
+	}{
+		{
+			name:               "GET retried until success",
+			method:             http.MethodGet,
+			maxAttempts:        3,
+			statuses:           []int{503, 429},
+			expectedAttempts:   3,
+			expectedStatusCode: 200,
+		},
+		{
+			name:               "GET honors Retry-After",
+			method:             http.MethodGet,
+			maxAttempts:        3,
+			statuses:           []int{429},
+			retryAfter:         "0",
+			expectedAttempts:   2,
+			expectedStatusCode: 200,
+		},
+		{
+			name:               "GET waits at most MaxBackoff, when Retry-After is too long",
+			method:             http.MethodGet,
+			maxAttempts:        3,
+			statuses:           []int{429},
+			retryAfter:         "3600",
+			expectedAttempts:   2,
+			expectedStatusCode: 200,
+		},
+		{
+			name:               "GET max attempts exceeded",
+			method:             http.MethodGet,
+			maxAttempts:        2,
+			statuses:           []int{503, 503, 503},
+			expectedAttempts:   2,
+			expectedStatusCode: 503,
+		},
+		{
+			name:               "GET not retried for not retryable status code",
+			method:             http.MethodGet,
+			maxAttempts:        3,
+			statuses:           []int{500},
+			expectedAttempts:   1,
+			expectedStatusCode: 500,
+		},
+		{
+			name:               "POST not retried",
+			method:             http.MethodPost,
+			maxAttempts:        3,
+			statuses:           []int{503},
+			expectedAttempts:   1,
+			expectedStatusCode: 503,
+		},
+		{
+			name:               "marked POST retried",
+			method:             http.MethodPost,
+			markPost:           true,
+			maxAttempts:        3,
+			statuses:           []int{503},
+			expectedAttempts:   2,
+			expectedStatusCode: 200,
+		},
+		{
+			name:               "retrying disabled",
+			method:             http.MethodGet,
+			maxAttempts:        1,
+			statuses:           []int{503},
+			expectedAttempts:   1,
+			expectedStatusCode: 503,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			server := newTestingRetryServer(tt.statuses, tt.retryAfter)
+			defer server.Close()
+
+			tempDirFilePath := t.TempDir()
+			testingFiles, err := setupTestingFileSystem(
+				tempDirFilePath, false, false, false, false, false)
+			if err != nil {
+				t.Fatalf("unable to setup testing environment: %s", err)
+			}
+
+			rhsmClient, err := setupTestingRHSMClient(testingFiles, server.Server, nil)
+			if err != nil {
+				t.Fatalf("unable to setup testing rhsm client: %s", err)
+			}
+			rhsmClient.SetRetryPolicy(testingRetryPolicy(tt.maxAttempts))
+
+			ctx := context.Background()
+			if tt.markPost {
+				ctx = WithRetryablePost(ctx)
+			}
+
+			body := []byte("{}")
+			metadata := sanitizeMetadata(nil)
+			res, err := rhsmClient.noAuthConnection.request(
+				ctx,
+				rhsmClient.UserAgent,
+				tt.method,
+				"status",
+				"",
+				"",
+				nil,
+				&body,
+				metadata,
+			)
+			if err != nil {
+				t.Fatalf("request failed: %s", err)
+			}
+			_ = res.Body.Close()
+
+			if res.StatusCode != tt.expectedStatusCode {
+				t.Errorf("expected status code: %d, got: %d", tt.expectedStatusCode, res.StatusCode)
+			}
+
+			if server.attempts() != tt.expectedAttempts {
+				t.Fatalf("expected %d attempts, got: %d", tt.expectedAttempts, server.attempts())
+			}
+
+			// All attempts have to use the same Correlation-ID and unique Request-ID
+			requestIDs := make(map[string]struct{})
+			for _, header := range server.headers {
+				if header.Get("Correlation-ID") != *metadata.CorrelationId {
+					t.Errorf("expected Correlation-ID: %s, got: %s",
+						*metadata.CorrelationId, header.Get("Correlation-ID"))
+				}
+				requestID := header.Get("Request-ID")
+				if _, exists := requestIDs[requestID]; exists {
+					t.Errorf("Request-ID: %s used more than once", requestID)
+				}
+				requestIDs[requestID] = struct{}{}
+			}
+		})
+	}
+}
+
+// TestRequestRetryDisabledByDefault test that failed requests are not retried,
+// when no retry policy is set
+func TestRequestRetryDisabledByDefault(t *testing.T) {
+	t.Parallel()
+	server := newTestingRetryServer([]int{503}, "")
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, false, false, false, false, false)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server.Server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+
+	_, err = rhsmClient.GetServerStatus(nil)
+	if err == nil {
+		t.Fatalf("getting server status from unavailable server did not fail")
+	}
+
+	if server.attempts() != 1 {
+		t.Errorf("expected one attempt, got: %d", server.attempts())
+	}
+}
+
+// TestRequestRetryContextCanceled test that waiting for the next attempt
+// is interrupted, when the context is canceled
+func TestRequestRetryContextCanceled(t *testing.T) {
+	t.Parallel()
+	server := newTestingRetryServer([]int{503, 503, 503}, "")
+	defer server.Close()
+
+	tempDirFilePath := t.TempDir()
+	testingFiles, err := setupTestingFileSystem(
+		tempDirFilePath, false, false, false, false, false)
+	if err != nil {
+		t.Fatalf("unable to setup testing environment: %s", err)
+	}
+
+	rhsmClient, err := setupTestingRHSMClient(testingFiles, server.Server, nil)
+	if err != nil {
+		t.Fatalf("unable to setup testing rhsm client: %s", err)
+	}
+	rhsmClient.SetRetryPolicy(RetryPolicy{
+		MaxAttempts:      3,
+		InitialBackoff:   time.Hour,
+		MaxBackoff:       time.Hour,
+		RetryStatusCodes: []int{503},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = rhsmClient.GetServerStatusWithContext(ctx, nil)
+	if err == nil {
+		t.Fatalf("getting server status with canceled context did not fail")
+	}
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("waiting for next attempt was not interrupted, it took: %v", elapsed)
+	}
+
+	if server.attempts() != 1 {
+		t.Errorf("expected one attempt, got: %d", server.attempts())
+	}
+}
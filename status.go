@@ -1,11 +1,10 @@
 package rhsm2
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
-
-	"github.com/rs/zerolog/log"
 )
 
 // RHSMEndPoints is structure used for storing GET response from
@@ -18,6 +17,12 @@ type RHSMEndPoints struct {
 
 // GetServerEndpoints tries to get list of supported server endpoints
 func (rhsmClient *RHSMClient) GetServerEndpoints(metadata *RequestMetadata) (*[]RHSMEndPoints, error) {
+	return rhsmClient.GetServerEndpointsWithContext(context.Background(), metadata)
+}
+
+// GetServerEndpointsWithContext is like GetServerEndpoints, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) GetServerEndpointsWithContext(ctx context.Context, metadata *RequestMetadata) (*[]RHSMEndPoints, error) {
 	var rhsmEndPoints []RHSMEndPoints
 	var connection *RHSMConnection
 
@@ -42,6 +47,7 @@ func (rhsmClient *RHSMClient) GetServerEndpoints(metadata *RequestMetadata) (*[]
 	}
 
 	res, err := connection.request(
+		ctx,
 		rhsmClient.UserAgent,
 		http.MethodGet,
 		"",
@@ -61,6 +67,10 @@ func (rhsmClient *RHSMClient) GetServerEndpoints(metadata *RequestMetadata) (*[]
 		_ = res.Body.Close()
 	}()
 
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("unable to get server endpoints: %w", newCandlepinError(res))
+	}
+
 	resBody, err := getResponseBody(res)
 	if err != nil {
 		return nil, err
@@ -105,6 +115,12 @@ type RHSMStatus struct {
 // GetServerStatus tries to get status from the server. This
 // method is possible to call, when server is connected or not
 func (rhsmClient *RHSMClient) GetServerStatus(metadata *RequestMetadata) (*RHSMStatus, error) {
+	return rhsmClient.GetServerStatusWithContext(context.Background(), metadata)
+}
+
+// GetServerStatusWithContext is like GetServerStatus, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) GetServerStatusWithContext(ctx context.Context, metadata *RequestMetadata) (*RHSMStatus, error) {
 	var rhsmStatus RHSMStatus
 	var connection *RHSMConnection
 
@@ -129,6 +145,7 @@ func (rhsmClient *RHSMClient) GetServerStatus(metadata *RequestMetadata) (*RHSMS
 	}
 
 	res, err := connection.request(
+		ctx,
 		rhsmClient.UserAgent,
 		http.MethodGet,
 		"status",
@@ -142,12 +159,11 @@ func (rhsmClient *RHSMClient) GetServerStatus(metadata *RequestMetadata) (*RHSMS
 		return nil, fmt.Errorf("unable to get server status :%v", err)
 	}
 
-	// Server can respond only with 200 or 500 status codes
-	if res.StatusCode == 500 {
-		var unregisterServerError UnregisterServerError
-		parseServerResponse(&unregisterServerError, res)
-		log.Error().Msgf("unable to server status: %s",
-			unregisterServerError.DisplayMessage)
+	// Server should respond only with 200 or 500 status codes
+	if res.StatusCode != 200 {
+		// UnregisterServerError is returned for backward compatibility. It wraps CandlepinError
+		unregisterServerError := newUnregisterServerError(newCandlepinError(res))
+		rhsmClient.getLogger().Error().Msgf("unable to get server status: %s", unregisterServerError)
 		return nil, unregisterServerError
 	}
 
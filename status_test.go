@@ -1,6 +1,7 @@
 package rhsm2
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -286,4 +287,13 @@ func TestGetServerStatusInternalServerError(t *testing.T) {
 	if err == nil {
 		t.Fatalf("no error raised, when there was internal server error")
 	}
+
+	// Deprecated UnregisterServerError is returned for backward compatibility
+	if _, ok := err.(UnregisterServerError); !ok {
+		t.Errorf("expected UnregisterServerError, got: %T", err)
+	}
+	var candlepinError *CandlepinError
+	if !errors.As(err, &candlepinError) || candlepinError.StatusCode != 500 {
+		t.Errorf("expected CandlepinError with status code 500, got: %v", err)
+	}
 }
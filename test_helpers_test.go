@@ -1,6 +1,8 @@
 package rhsm2
 
 import (
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/fs"
@@ -8,6 +10,8 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 )
 
 // Here is set of JSON documents returned by candlepin server in body of response,
@@ -28,7 +32,7 @@ const response404 = `{
 
 const response410 = `{
   "displayMessage": "Consumer with 5e9745d5-624d-4af1-916e-2c17df4eb4e8 is already deleted.",
-  "requestUuid": "c4347004-8792-41fe-a4d8-fccaa0d3898a"
+  "requestUuid": "c4347004-8792-41fe-a4d8-fccaa0d3898a",
   "deletedId": "5e9745d5-624d-4af1-916e-2c17df4eb4e8"
 }`
 
@@ -88,6 +92,9 @@ type TestingFileSystem struct {
 	EtcDirPath             string
 	OsReleaseFilePath      string
 	DnfVarsReleaseFilePath string
+	Dnf5OverrideFilePath   string
+	ContainerCertsDirPath  string
+	OstreeRemotesFilePath  string
 	CACertDirPath          string
 	ConsumerDirPath        string
 	EntitlementDirPath     string
@@ -97,6 +104,7 @@ type TestingFileSystem struct {
 	SyspurposeFilePath     string
 	YumReposDirPath        string
 	YumRepoFilePath        string
+	CacheDirPath           string
 }
 
 func (testingFileSystem *TestingFileSystem) setupSyspurpose(perm *os.FileMode) error {
@@ -141,16 +149,108 @@ func (testingFileSystem *TestingFileSystem) setupEntitlementCertKey(perm *os.Fil
 		return fmt.Errorf(
 			"unable to create testing entitlement key file: %s", err)
 	}
-	// Copy entitlement cert to temporary directory
+	// Install entitlement cert with entitlement data signed by testing CA
+	// and install the testing CA to the temporary CA directory
+	ca, err := getTestingSigningCA()
+	if err != nil {
+		return err
+	}
 	srcEntitlementCertFilePath := "./testdata/etc/pki/entitlement/" + testEntCertSerialNumber + ".pem"
+	entCertContent, err := os.ReadFile(srcEntitlementCertFilePath)
+	if err != nil {
+		return fmt.Errorf("unable to read testing entitlement cert file: %s", err)
+	}
+	entCertContent, err = signEntitlementCert(ca, entCertContent)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if perm != nil {
+		mode = *perm
+	}
 	dstEntitlementCertFilePath := filepath.Join(testingFileSystem.EntitlementDirPath, testEntCertSerialNumber+".pem")
-	err = copyFile(&srcEntitlementCertFilePath, &dstEntitlementCertFilePath, perm)
+	err = os.WriteFile(dstEntitlementCertFilePath, entCertContent, mode)
 	if err != nil {
 		return fmt.Errorf("unable to create testing entitlement cert file: %s", err)
 	}
+	dstCACertFilePath := filepath.Join(testingFileSystem.CACertDirPath, "testing-candlepin-ca.pem")
+	err = os.WriteFile(dstCACertFilePath, ca.certPEM(), mode)
+	if err != nil {
+		return fmt.Errorf("unable to create testing CA cert file: %s", err)
+	}
 	return nil
 }
 
+var (
+	testingSigningCAOnce sync.Once
+	testingSigningCA     *testingCA
+	testingSigningCAErr  error
+)
+
+// signEntCertKeyList tries to sign entitlement data of all entitlement certificates
+// in given JSON document returned by candlepin server using testing CA
+func signEntCertKeyList(entCertKeyListJSON string) ([]byte, error) {
+	ca, err := getTestingSigningCA()
+	if err != nil {
+		return nil, err
+	}
+	// Serial numbers cannot be represented as float64
+	var entCertKeys []map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(entCertKeyListJSON))
+	decoder.UseNumber()
+	err = decoder.Decode(&entCertKeys)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse list of entitlement certificates: %s", err)
+	}
+	for _, entCertKey := range entCertKeys {
+		entCert, ok := entCertKey["cert"].(string)
+		if !ok {
+			continue
+		}
+		signedEntCert, err := signEntitlementCert(ca, []byte(entCert))
+		if err != nil {
+			return nil, err
+		}
+		entCertKey["cert"] = string(signedEntCert)
+	}
+	return json.Marshal(entCertKeys)
+}
+
+// getTestingSigningCA returns testing CA used for signing entitlement data of testing
+// entitlement certificates. The CA is generated only once, because it is not cheap.
+func getTestingSigningCA() (*testingCA, error) {
+	testingSigningCAOnce.Do(func() {
+		testingSigningCA, testingSigningCAErr = createTestingCA()
+	})
+	return testingSigningCA, testingSigningCAErr
+}
+
+// signEntitlementCert tries to replace the block "RSA SIGNATURE" of the entitlement
+// certificate with the signature of entitlement data created by given CA
+func signEntitlementCert(ca *testingCA, entCertContent []byte) ([]byte, error) {
+	var result []byte
+	var entitlementData []byte
+	for {
+		var block *pem.Block
+		block, entCertContent = pem.Decode(entCertContent)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "ENTITLEMENT DATA":
+			entitlementData = block.Bytes
+		case "RSA SIGNATURE":
+			signature, err := ca.signData(entitlementData)
+			if err != nil {
+				return nil, err
+			}
+			block = &pem.Block{Type: block.Type, Bytes: signature}
+		}
+		result = append(result, pem.EncodeToMemory(block)...)
+	}
+	return result, nil
+}
+
 func (testingFileSystem *TestingFileSystem) setupProductCerts(perm *os.FileMode) error {
 	srcProductCertFilePath := "./testdata/etc/pki/product/900.pem"
 	dstProductCertFilePath := filepath.Join(testingFileSystem.ProductDirPath, "900.pem")
@@ -267,6 +367,15 @@ func setupTestingDirectories(tempDirFilePath string, perm os.FileMode) (*Testing
 	// Set the file path for release dnf variable file
 	testingFileSystem.DnfVarsReleaseFilePath = filepath.Join(testingFileSystem.EtcDirPath, "dnf", "vars", "release")
 
+	// Set the file path for dnf5 repo override file
+	testingFileSystem.Dnf5OverrideFilePath = filepath.Join(
+		testingFileSystem.EtcDirPath, "dnf", "repos.override.d", dnf5ReposOverrideFileName)
+
+	// Set the file paths used by handlers of container image and ostree content
+	testingFileSystem.ContainerCertsDirPath = filepath.Join(testingFileSystem.EtcDirPath, "containers", "certs.d")
+	testingFileSystem.OstreeRemotesFilePath = filepath.Join(
+		testingFileSystem.EtcDirPath, "ostree", "remotes.d", "redhat.conf")
+
 	// Create temporary directory for CA certificate
 	caCertDirPath, err := createDirectory(tempDirFilePath, "etc/rhsm/ca", perm)
 	if err != nil {
@@ -316,6 +425,13 @@ func setupTestingDirectories(tempDirFilePath string, perm os.FileMode) (*Testing
 	}
 	testingFileSystem.YumReposDirPath = *yumReposDirPath
 
+	// Create directory for cached data
+	cacheDirPath, err := createDirectory(tempDirFilePath, "var/lib/rhsm", perm)
+	if err != nil {
+		return nil, err
+	}
+	testingFileSystem.CacheDirPath = *cacheDirPath
+
 	return &testingFileSystem, nil
 }
 
@@ -419,10 +535,22 @@ func setupTestingRHSMClient(testingFiles *TestingFileSystem, server *httptest.Se
 
 	// Fill rhsm conf with fake data and temporary paths
 	rhsmClient.RHSMConf = &RHSMConf{
-		yumRepoFilePath:        testingFiles.YumRepoFilePath,
-		syspurposeFilePath:     testingFiles.SyspurposeFilePath,
-		osReleaseFilePath:      testingFiles.OsReleaseFilePath,
-		dnfVarsReleaseFilePath: testingFiles.DnfVarsReleaseFilePath,
+		yumRepoFilePath:             testingFiles.YumRepoFilePath,
+		syspurposeFilePath:          testingFiles.SyspurposeFilePath,
+		osReleaseFilePath:           testingFiles.OsReleaseFilePath,
+		dnfVarsReleaseFilePath:      testingFiles.DnfVarsReleaseFilePath,
+		dnf5ReposOverrideFilePath:   testingFiles.Dnf5OverrideFilePath,
+		factsRootDirPath:            "./testdata",
+		customFactsDirPath:          "./testdata/etc/rhsm/facts",
+		factsCacheFilePath:          filepath.Join(testingFiles.CacheDirPath, "facts", "facts.json"),
+		packageProfileCacheFilePath: filepath.Join(testingFiles.CacheDirPath, "packages", "packages.json"),
+		repoFileCacheFilePath:       filepath.Join(testingFiles.CacheDirPath, "repos", "redhat.repo.json"),
+		contentOverridesCacheFilePath: filepath.Join(
+			testingFiles.CacheDirPath, "content_overrides", "content_overrides.json"),
+		containerCertsDirPath: testingFiles.ContainerCertsDirPath,
+		ostreeRemotesFilePath: testingFiles.OstreeRemotesFilePath,
+		productIdDBFilePath:   filepath.Join(testingFiles.CacheDirPath, "productid.js"),
+		dnfVersion:            DnfVersion5,
 		RHSM: RHSMConfRHSM{
 			ConsumerCertDir:       testingFiles.ConsumerDirPath,
 			EntitlementCertDir:    testingFiles.EntitlementDirPath,
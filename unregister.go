@@ -1,44 +1,50 @@
 package rhsm2
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
-
-	"github.com/rs/zerolog/log"
 )
 
-// UnregisterServerError is structure representing error
-// returned from server
+// UnregisterServerError is error returned, when unregistering is not successful.
+//
+// Deprecated: Use CandlepinError instead. UnregisterServerError embeds CandlepinError,
+// which can be retrieved using errors.As.
 type UnregisterServerError struct {
+	*CandlepinError
 	DisplayMessage string `json:"displayMessage"`
 	RequestUuid    string `json:"requestUuid"`
 	StatusCode     int
 	ParsingError   error
 }
 
+// newUnregisterServerError creates UnregisterServerError wrapping given CandlepinError
+func newUnregisterServerError(candlepinError *CandlepinError) UnregisterServerError {
+	return UnregisterServerError{
+		CandlepinError: candlepinError,
+		DisplayMessage: candlepinError.DisplayMessage,
+		RequestUuid:    candlepinError.RequestUuid,
+		StatusCode:     candlepinError.StatusCode,
+	}
+}
+
 // Error interface
 func (unregisterServerError UnregisterServerError) Error() string {
+	if unregisterServerError.CandlepinError != nil {
+		return unregisterServerError.CandlepinError.Error()
+	}
 	return unregisterServerError.DisplayMessage
 }
 
-// parseServerResponse tries to parse response from server and set corresponding fields
-// in UnregisterServerError structure
-func parseServerResponse(unregisterServerError *UnregisterServerError, res *http.Response) {
-	unregisterServerError.StatusCode = res.StatusCode
-	resBody, err := getResponseBody(res)
-	if err != nil {
-		unregisterServerError.ParsingError = fmt.Errorf("unable to get body from %d response", res.StatusCode)
-	}
-	err = json.Unmarshal([]byte(*resBody), &unregisterServerError)
-	if err != nil {
-		unregisterServerError.ParsingError = fmt.Errorf(
-			"unable to parse JSON document returned by candlepin server")
+// Unwrap returns wrapped CandlepinError
+func (unregisterServerError UnregisterServerError) Unwrap() error {
+	if unregisterServerError.CandlepinError == nil {
+		return nil
 	}
-	unregisterServerError.ParsingError = nil
+	return unregisterServerError.CandlepinError
 }
 
 // removeInstalledFiles tries to remove all installed files. When all
@@ -50,16 +56,16 @@ func (rhsmClient *RHSMClient) removeInstalledFiles() error {
 	removedAll := true
 
 	// Remove consumer certificate and key
-	log.Debug().Msgf("removing consumer certificate: %s", *rhsmClient.consumerCertPath())
+	rhsmClient.getLogger().Debug().Msgf("removing consumer certificate: %s", *rhsmClient.consumerCertPath())
 	err := os.Remove(*rhsmClient.consumerCertPath())
 	if err != nil {
-		log.Error().Msgf("unable to remove consumer certificate: %s", err)
+		rhsmClient.getLogger().Error().Msgf("unable to remove consumer certificate: %s", err)
 		removedAll = false
 	}
-	log.Debug().Msgf("removing consumer key: %s", *rhsmClient.consumerKeyPath())
+	rhsmClient.getLogger().Debug().Msgf("removing consumer key: %s", *rhsmClient.consumerKeyPath())
 	err = os.Remove(*rhsmClient.consumerKeyPath())
 	if err != nil {
-		log.Error().Msgf("unable to remove consumer key: %s", err)
+		rhsmClient.getLogger().Error().Msgf("unable to remove consumer key: %s", err)
 		removedAll = false
 	}
 
@@ -67,20 +73,20 @@ func (rhsmClient *RHSMClient) removeInstalledFiles() error {
 	entCertDir := &rhsmClient.RHSMConf.RHSM.EntitlementCertDir
 	entPemFiles, err := os.ReadDir(*entCertDir)
 	if err != nil {
-		log.Error().Msgf("unable to read directory %s with entitlement certs/keys: %s", *entCertDir, err)
+		rhsmClient.getLogger().Error().Msgf("unable to read directory %s with entitlement certs/keys: %s", *entCertDir, err)
 		removedAll = false
 	} else {
-		log.Debug().Msgf("removing installed entitlement certs & keys from %s", *entCertDir)
+		rhsmClient.getLogger().Debug().Msgf("removing installed entitlement certs & keys from %s", *entCertDir)
 		for _, entPemFile := range entPemFiles {
 			entPemFilePath := filepath.Join(*entCertDir, entPemFile.Name())
 			if strings.HasSuffix(entPemFilePath, "-key.pem") {
-				log.Debug().Msgf("removing entitlement key: %s", entPemFilePath)
+				rhsmClient.getLogger().Debug().Msgf("removing entitlement key: %s", entPemFilePath)
 			} else {
-				log.Debug().Msgf("removing entitlement cert: %s", entPemFilePath)
+				rhsmClient.getLogger().Debug().Msgf("removing entitlement cert: %s", entPemFilePath)
 			}
 			err = os.Remove(entPemFilePath)
 			if err != nil {
-				log.Error().Msgf("unable to remove %s: %s", entPemFilePath, err)
+				rhsmClient.getLogger().Error().Msgf("unable to remove %s: %s", entPemFilePath, err)
 				removedAll = false
 			}
 		}
@@ -90,11 +96,48 @@ func (rhsmClient *RHSMClient) removeInstalledFiles() error {
 	if rhsmClient.RHSMConf.yumRepoFilePath != "" {
 		err = os.Remove(rhsmClient.RHSMConf.yumRepoFilePath)
 		if err != nil {
-			log.Error().Msgf("unable to remove %s: %s", rhsmClient.RHSMConf.yumRepoFilePath, err)
+			rhsmClient.getLogger().Error().Msgf("unable to remove %s: %s", rhsmClient.RHSMConf.yumRepoFilePath, err)
 			removedAll = false
 		}
 	}
 
+	// Remove configuration of other types of content
+	err = rhsmClient.removeContent()
+	if err != nil {
+		removedAll = false
+	}
+
+	// Remove cache of reported facts
+	err = removeCacheFile(rhsmClient.RHSMConf.factsCacheFilePath)
+	if err != nil {
+		rhsmClient.getLogger().Error().Msgf("unable to remove %s: %s", rhsmClient.RHSMConf.factsCacheFilePath, err)
+		removedAll = false
+	}
+
+	// Remove cache of uploaded package profile
+	err = removeCacheFile(rhsmClient.RHSMConf.packageProfileCacheFilePath)
+	if err != nil {
+		rhsmClient.getLogger().Error().Msgf("unable to remove %s: %s", rhsmClient.RHSMConf.packageProfileCacheFilePath, err)
+		removedAll = false
+	}
+
+	// Remove cache of generated repositories
+	err = removeCacheFile(rhsmClient.RHSMConf.repoFileCacheFilePath)
+	if err != nil {
+		rhsmClient.getLogger().Error().Msgf("unable to remove %s: %s", rhsmClient.RHSMConf.repoFileCacheFilePath, err)
+		removedAll = false
+	}
+
+	// Remove cache of content overrides
+	err = removeCacheFile(rhsmClient.RHSMConf.contentOverridesCacheFilePath)
+	if err != nil {
+		rhsmClient.getLogger().Error().Msgf("unable to remove %s: %s", rhsmClient.RHSMConf.contentOverridesCacheFilePath, err)
+		removedAll = false
+	}
+
+	// Connections using removed certificates cannot be used anymore
+	rhsmClient.invalidateCertAuthConnections()
+
 	if !removedAll {
 		return fmt.Errorf("unable to remove all installed files")
 	}
@@ -105,12 +148,21 @@ func (rhsmClient *RHSMClient) removeInstalledFiles() error {
 // Clean tries to clean all installed files, but do not try to
 // remove consumer object from candlepin server
 func (rhsmClient *RHSMClient) Clean() error {
-	log.Warn().Msg("removing installed files without removing consumer from candlepin server")
+	rhsmClient.getLogger().Warn().Msg("removing installed files without removing consumer from candlepin server")
 	return rhsmClient.removeInstalledFiles()
 }
 
-// Unregister tries to unregister system
+// Unregister tries to unregister system. When candlepin server responds with
+// status code other than 204, then UnregisterServerError wrapping CandlepinError
+// is returned. This includes unknown status codes, because it is not known, if
+// the consumer was deleted on the server, and installed files are kept.
 func (rhsmClient *RHSMClient) Unregister(metadata *RequestMetadata) error {
+	return rhsmClient.UnregisterWithContext(context.Background(), metadata)
+}
+
+// UnregisterWithContext is like Unregister, but it uses the given context.
+// HTTP requests are canceled, when the context is canceled or its deadline is exceeded
+func (rhsmClient *RHSMClient) UnregisterWithContext(ctx context.Context, metadata *RequestMetadata) error {
 	consumerUuid, err := rhsmClient.GetConsumerUUID()
 
 	if err != nil {
@@ -126,6 +178,7 @@ func (rhsmClient *RHSMClient) Unregister(metadata *RequestMetadata) error {
 		return fmt.Errorf("unable to get consumer cert auth connection: %v", err)
 	}
 	res, err := connection.request(
+		ctx,
 		rhsmClient.UserAgent,
 		http.MethodDelete,
 		"consumers/"+*consumerUuid,
@@ -144,38 +197,37 @@ func (rhsmClient *RHSMClient) Unregister(metadata *RequestMetadata) error {
 	}
 
 	// Server can respond with following codes
-	var unregisterServerError UnregisterServerError
 	switch res.StatusCode {
 	case 204: // Consumer was successfully deleted from the server
-		log.Info().Msgf("system successfully unregistered on server")
+		rhsmClient.getLogger().Info().Msgf("system successfully unregistered on server")
 		// Try to remove all installed files.
 		err = rhsmClient.removeInstalledFiles()
 		// If it is not possible to remove any installed file, then only
 		// log it as error, but do not return error from this function, because
 		// system is technically unregistered at this moment
 		if err != nil {
-			log.Error().Msgf("%s", err)
+			rhsmClient.getLogger().Error().Msgf("%s", err)
 		}
 		return nil
 	case 403: // Not enough permission to delete consumer on server
 		// Do not remove installed files, because removing consumer was refused by server
-		parseServerResponse(&unregisterServerError, res)
-		log.Error().Msgf("unable to unregister: %s",
-			unregisterServerError.DisplayMessage)
+		unregisterServerError := newUnregisterServerError(newCandlepinError(res))
+		rhsmClient.getLogger().Error().Msgf("unable to unregister: %s", unregisterServerError.DisplayMessage)
 		return unregisterServerError
 	case 410: // Consumer has been already deleted on the server
 		_ = rhsmClient.removeInstalledFiles()
-		parseServerResponse(&unregisterServerError, res)
-		log.Warn().Msgf("already unregistered: %s", unregisterServerError.DisplayMessage)
+		unregisterServerError := newUnregisterServerError(newCandlepinError(res))
+		rhsmClient.getLogger().Warn().Msgf("already unregistered: %s", unregisterServerError.DisplayMessage)
 		return unregisterServerError
 	case 500: // Internal server error
-		parseServerResponse(&unregisterServerError, res)
-		log.Error().Msgf("unable to unregister: %s",
-			unregisterServerError.DisplayMessage)
+		unregisterServerError := newUnregisterServerError(newCandlepinError(res))
+		rhsmClient.getLogger().Error().Msgf("unable to unregister: %s", unregisterServerError.DisplayMessage)
 		return unregisterServerError
 	default:
-		log.Warn().Msgf("unknown status code %d returned during unregistering", res.StatusCode)
+		// Do not remove installed files, because it is not known, if
+		// the consumer was deleted on the server
+		unregisterServerError := newUnregisterServerError(newCandlepinError(res))
+		rhsmClient.getLogger().Warn().Msgf("unknown status code %d returned during unregistering", res.StatusCode)
+		return unregisterServerError
 	}
-
-	return nil
 }
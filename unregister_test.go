@@ -1,6 +1,7 @@
 package rhsm2
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -386,6 +387,18 @@ func TestUnregisterDeletedConsumer(t *testing.T) {
 		t.Fatalf("unregistering failed with no error")
 	}
 
+	if !IsGone(err) {
+		t.Fatalf("expected error with status code 410, got: %s", err)
+	}
+
+	var candlepinError *CandlepinError
+	if !errors.As(err, &candlepinError) {
+		t.Fatalf("expected CandlepinError, got: %T", err)
+	}
+	if candlepinError.DeletedId != expectedClientUUID {
+		t.Errorf("expected deletedId: %s, got: %s", expectedClientUUID, candlepinError.DeletedId)
+	}
+
 	if handlerCounter != 1 {
 		t.Fatalf("handler for unregister REST API pointed not called once, but called: %d", handlerCounter)
 	}
@@ -448,6 +461,10 @@ func TestUnregisterWrongConsumer(t *testing.T) {
 		t.Fatalf("unregistering failed with no error")
 	}
 
+	if !IsForbidden(err) {
+		t.Fatalf("expected error with status code 403, got: %s", err)
+	}
+
 	if handlerCounter != 1 {
 		t.Fatalf("handler for unregister REST API pointed not called once, but called: %d", handlerCounter)
 	}